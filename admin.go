@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ardanlabs/conf/v3"
+
+	"github.com/mtpereira/deck/deckclient"
+)
+
+// ClientConfig is the configuration shared by every subcommand that talks to
+// a running server. It is exported so conf can fill it in when it is embedded.
+type ClientConfig struct {
+	URL     string        `conf:"default:http://127.0.0.1:9000,help:base URL of the deck server"`
+	APIKey  string        `conf:"mask,help:API key sent to the server"`
+	Token   string        `conf:"mask,help:JWT bearer token sent to the server instead of an API key"`
+	Timeout time.Duration `conf:"default:5m,help:how long a command may take"`
+	Output  string        `conf:"default:table,help:how decks and cards are printed; table or json or glyph"`
+}
+
+// client returns a client for the server the configuration points at.
+func (cfg ClientConfig) client() *deckclient.Client {
+	return deckclient.New(cfg.URL, deckclient.WithAPIKey(cfg.APIKey), deckclient.WithBearerToken(cfg.Token))
+}
+
+// parseClientConfig parses the configuration for a client subcommand into
+// cfg, a struct embedding ClientConfig. The subcommand itself is dropped from
+// the arguments so flags can follow it.
+func parseClientConfig(cfg any) (bool, error) {
+	dropCommand()
+	help, err := conf.Parse("DECK", cfg)
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			fmt.Println(help)
+			return false, nil
+		}
+		return false, fmt.Errorf("error parsing config: %w", err)
+	}
+	return true, nil
+}
+
+// dropCommand removes the subcommand from the arguments.
+func dropCommand() {
+	if len(os.Args) > 1 {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+}
+
+// runExport writes the NDJSON dump of every deck on a running server to w.
+func runExport(ctx context.Context, w io.Writer) error {
+	var cfg ClientConfig
+	ok, err := parseClientConfig(&cfg)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	err = cfg.client().Export(ctx, w)
+	if err != nil {
+		return fmt.Errorf("error exporting decks: %w", err)
+	}
+	return nil
+}
+
+// runImport sends the NDJSON dump read from r to a running server.
+func runImport(ctx context.Context, r io.Reader, w io.Writer) error {
+	var cfg ClientConfig
+	ok, err := parseClientConfig(&cfg)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	n, err := cfg.client().Import(ctx, r)
+	if err != nil {
+		return fmt.Errorf("error importing decks: %w", err)
+	}
+	return json.NewEncoder(w).Encode(map[string]int{"imported": n})
+}
+
+// runPromote turns a running follower into a leader.
+func runPromote(ctx context.Context, w io.Writer) error {
+	var cfg ClientConfig
+	ok, err := parseClientConfig(&cfg)
+	if !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	status, err := cfg.client().Promote(ctx)
+	if err != nil {
+		return fmt.Errorf("error promoting server: %w", err)
+	}
+	return json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,473 @@
+// Package auth authenticates the clients of the deck APIs, with API keys,
+// share tokens and JWT bearer tokens, and decides what they can do to each
+// deck.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+var ErrUnauthenticated error = errors.New("Missing or invalid API key, share token or bearer token")
+var ErrForbidden error = errors.New("Access denied")
+var ErrKeyExists error = errors.New("API key already exists")
+var ErrKeyNotFound error = errors.New("API key not found")
+var ErrInvalidKeyID error = errors.New("Key IDs are 1 to 64 letters, digits, dots, dashes or underscores")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Access is what a client can do to a deck. Each access includes the ones
+// before it.
+type Access int
+
+const (
+	AccessNone Access = iota
+	// AccessRead lets clients see a deck and its events.
+	AccessRead
+	// AccessDraw also lets clients draw cards from a deck.
+	AccessDraw
+	// AccessOwner lets clients do anything to a deck, including sharing it.
+	AccessOwner
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessDraw:
+		return "draw"
+	case AccessOwner:
+		return "owner"
+	}
+	return "none"
+}
+
+// Scope returns the scope clients need for a access to any deck.
+func (a Access) Scope() Scope {
+	switch a {
+	case AccessNone:
+		return 0
+	case AccessRead:
+		return ScopeRead
+	}
+	return ScopeDraw
+}
+
+// ParseShareAccess parses the access a share token grants, read or draw.
+func ParseShareAccess(s string) (Access, error) {
+	switch s {
+	case "read":
+		return AccessRead, nil
+	case "draw":
+		return AccessDraw, nil
+	}
+	return AccessNone, fmt.Errorf("share access %q isn't read or draw", s)
+}
+
+// Scope is a set of the scopes a client was granted, which bound what it can
+// do whatever decks it has access to.
+type Scope uint8
+
+const (
+	// ScopeRead lets clients see decks and their events.
+	ScopeRead Scope = 1 << iota
+	// ScopeDraw lets clients create decks and change them.
+	ScopeDraw
+	// ScopeAdmin lets clients do anything to any deck, and use the admin and
+	// replication endpoints. It includes the other scopes.
+	ScopeAdmin
+)
+
+var scopeNames = []struct {
+	scope Scope
+	name  string
+}{
+	{ScopeRead, "deck:read"},
+	{ScopeDraw, "deck:draw"},
+	{ScopeAdmin, "deck:admin"},
+}
+
+// ParseScopes returns the scopes named in names, such as deck:read and
+// deck:draw. Names of other scopes are ignored.
+func ParseScopes(names ...string) Scope {
+	var s Scope
+	for _, name := range names {
+		for _, sn := range scopeNames {
+			if name == sn.name {
+				s |= sn.scope
+			}
+		}
+	}
+	return s
+}
+
+// String returns the names of the scopes, separated by spaces.
+func (s Scope) String() string {
+	var names []string
+	for _, sn := range scopeNames {
+		if s&sn.scope != 0 {
+			names = append(names, sn.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// max returns the highest access the scopes allow.
+func (s Scope) max() Access {
+	switch {
+	case s&(ScopeDraw|ScopeAdmin) != 0:
+		return AccessOwner
+	case s&ScopeRead != 0:
+		return AccessRead
+	}
+	return AccessNone
+}
+
+// Key is an API key, without its secret.
+type Key struct {
+	ID    string `json:"id"`
+	Admin bool   `json:"admin"`
+}
+
+// Share is a share token, which grants access to a single deck for as long as
+// the key that made it exists.
+type Share struct {
+	ID     string
+	DeckID uuid.UUID
+	Access Access
+	KeyID  string
+}
+
+// Principal is an authenticated client: the holder of an API key, of a
+// share token when Share is set, or the player of a bearer token when Player
+// is set.
+type Principal struct {
+	KeyID  string
+	Player string
+	Share  *Share
+	Scopes Scope
+	// Decks are the decks a bearer token grants draw access to, besides the
+	// ones its player created.
+	Decks []uuid.UUID
+}
+
+// ID identifies the principal, for rate limits, logs and the events of the
+// changes it makes.
+func (p Principal) ID() string {
+	switch {
+	case p.Share != nil:
+		return "share " + p.Share.ID
+	case p.Player != "":
+		return "player " + p.Player
+	}
+	return "key " + p.KeyID
+}
+
+// Owner returns the owner of the decks p creates. Key IDs can't have colons,
+// so players can't pass for keys.
+func (p Principal) Owner() string {
+	if p.Player != "" {
+		return "player:" + p.Player
+	}
+	return p.KeyID
+}
+
+// Has reports whether p was granted every scope in s. The deck:admin scope
+// includes the others.
+func (p Principal) Has(s Scope) bool {
+	return p.Scopes&ScopeAdmin != 0 || p.Scopes&s == s
+}
+
+// Access returns the access p has to d, within the bounds of its scopes.
+// Admins can do anything to any deck, and others anything to the decks they
+// created. Decks created without authentication belong to no one, so only
+// admins can use them.
+func (p Principal) Access(d deck.Deck) Access {
+	var a Access
+	switch {
+	case p.Share != nil:
+		if p.Share.DeckID == d.DeckID {
+			a = p.Share.Access
+		}
+	case p.Has(ScopeAdmin):
+		a = AccessOwner
+	case d.Owner != "" && d.Owner == p.Owner():
+		a = AccessOwner
+	case slices.Contains(p.Decks, d.DeckID):
+		a = AccessDraw
+	}
+	return min(a, p.Scopes.max())
+}
+
+// Need returns ErrForbidden unless p was granted every scope in s.
+func (p Principal) Need(s Scope) error {
+	if !p.Has(s) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Can returns ErrForbidden unless p has at least need access to d.
+func (p Principal) Can(d deck.Deck, need Access) error {
+	if p.Access(d) < need {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// CanCreate returns ErrForbidden unless p holds an API key or a bearer token
+// with the deck:draw scope, since decks belong to the key or player that
+// creates them.
+func (p Principal) CanCreate() error {
+	if p.Share != nil {
+		return ErrForbidden
+	}
+	return p.Need(ScopeDraw)
+}
+
+// CanPlayAs returns ErrForbidden if p is the player of a bearer token and
+// player is someone else, so players only hold and play their own cards.
+// Admins can play as anyone.
+func (p Principal) CanPlayAs(player string) error {
+	if p.Player != "" && p.Player != player && !p.Has(ScopeAdmin) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// CanAdmin returns ErrForbidden unless p holds an admin key or a bearer token
+// with the deck:admin scope.
+func (p Principal) CanAdmin() error {
+	if p.Share != nil {
+		return ErrForbidden
+	}
+	return p.Need(ScopeAdmin)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx that carries p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal ctx carries, and false if it carries
+// none, which is the case when authentication is off.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Keyring holds the API keys and share tokens clients authenticate with.
+// Only the SHA-256 hashes of their secrets are kept.
+type Keyring struct {
+	keys   map[[sha256.Size]byte]Key
+	ids    map[string][sha256.Size]byte
+	shares map[[sha256.Size]byte]Share
+	mu     sync.RWMutex
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys:   make(map[[sha256.Size]byte]Key),
+		ids:    make(map[string][sha256.Size]byte),
+		shares: make(map[[sha256.Size]byte]Share),
+	}
+}
+
+// LoadKeyring returns a keyring with the keys in the YAML file at path: a
+// list of keys, each with an id, the secret key, and admin set to true for
+// admin keys.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open keys: %w", err)
+	}
+	defer f.Close()
+	return ReadKeyring(f)
+}
+
+// ReadKeyring is LoadKeyring for keys read from r.
+func ReadKeyring(r io.Reader) (*Keyring, error) {
+	var keys []struct {
+		ID    string `yaml:"id"`
+		Key   string `yaml:"key"`
+		Admin bool   `yaml:"admin"`
+	}
+	err := yaml.NewDecoder(r).Decode(&keys)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode keys: %w", err)
+	}
+
+	kr := NewKeyring()
+	for i, k := range keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("key %d has no secret", i+1)
+		}
+		err := kr.Put(Key{ID: k.ID, Admin: k.Admin}, k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+	}
+	return kr, nil
+}
+
+// Put adds a key with the given secret.
+func (kr *Keyring) Put(k Key, secret string) error {
+	if !keyIDPattern.MatchString(k.ID) {
+		return ErrInvalidKeyID
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.ids[k.ID]; ok {
+		return ErrKeyExists
+	}
+	h := sha256.Sum256([]byte(secret))
+	if _, ok := kr.keys[h]; ok {
+		return ErrKeyExists
+	}
+	kr.keys[h] = k
+	kr.ids[k.ID] = h
+	return nil
+}
+
+// Add adds a key with a new random secret, and returns the secret. It can't
+// be found out again.
+func (kr *Keyring) Add(k Key) (string, error) {
+	secret := newSecret()
+	err := kr.Put(k, secret)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Delete removes a key and every share token it made.
+func (kr *Keyring) Delete(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	h, ok := kr.ids[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	delete(kr.keys, h)
+	delete(kr.ids, id)
+	for th, s := range kr.shares {
+		if s.KeyID == id {
+			delete(kr.shares, th)
+		}
+	}
+	return nil
+}
+
+// Keys returns every key, sorted by ID.
+func (kr *Keyring) Keys() []Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keys := make([]Key, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b Key) int { return strings.Compare(a.ID, b.ID) })
+	return keys
+}
+
+// Share makes a share token granting access to a deck on behalf of the key
+// with keyID, and returns the token.
+func (kr *Keyring) Share(deckID uuid.UUID, access Access, keyID string) (string, Share, error) {
+	if access != AccessRead && access != AccessDraw {
+		return "", Share{}, fmt.Errorf("share tokens can't grant %s access", access)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.ids[keyID]; !ok {
+		return "", Share{}, ErrKeyNotFound
+	}
+	token := newSecret()
+	s := Share{
+		ID:     uuid.NewString(),
+		DeckID: deckID,
+		Access: access,
+		KeyID:  keyID,
+	}
+	kr.shares[sha256.Sum256([]byte(token))] = s
+	return token, s, nil
+}
+
+// Authenticate returns the principal holding an API key or a share token.
+// Either can be empty, but not both.
+func (kr *Keyring) Authenticate(key, token string) (Principal, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	switch {
+	case key != "":
+		k, ok := kr.keys[sha256.Sum256([]byte(key))]
+		if !ok {
+			return Principal{}, ErrUnauthenticated
+		}
+		p := Principal{KeyID: k.ID, Scopes: ScopeRead | ScopeDraw}
+		if k.Admin {
+			p.Scopes |= ScopeAdmin
+		}
+		return p, nil
+	case token != "":
+		s, ok := kr.shares[sha256.Sum256([]byte(token))]
+		if !ok {
+			return Principal{}, ErrUnauthenticated
+		}
+		p := Principal{KeyID: s.KeyID, Share: &s, Scopes: ScopeRead}
+		if s.Access >= AccessDraw {
+			p.Scopes |= ScopeDraw
+		}
+		return p, nil
+	}
+	return Principal{}, ErrUnauthenticated
+}
+
+// Authenticate returns the principal of a client's credentials: a bearer
+// token, verified by v, or else an API key or share token, checked by kr.
+// Credentials checked by a nil keyring or verifier are rejected.
+func Authenticate(kr *Keyring, v *Verifier, key, token, bearer string) (Principal, error) {
+	if bearer != "" {
+		if v == nil {
+			return Principal{}, ErrUnauthenticated
+		}
+		return v.Verify(bearer)
+	}
+	if kr == nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	return kr.Authenticate(key, token)
+}
+
+// newSecret returns 32 random bytes in hex.
+func newSecret() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("auth: read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestKeyring(t *testing.T) {
+	kr, err := ReadKeyring(strings.NewReader(`
+- id: ops
+  key: ops-secret
+  admin: true
+- id: lobby
+  key: lobby-secret
+`))
+	if err != nil {
+		t.Fatalf("Failed to read keys: %v", err)
+	}
+
+	// Test keys authenticate as themselves, and nothing else does.
+	p, err := kr.Authenticate("ops-secret", "")
+	if err != nil || p.KeyID != "ops" || !p.Has(ScopeAdmin) {
+		t.Errorf("Expected the ops admin, got %v, %v", p, err)
+	}
+	p, err = kr.Authenticate("lobby-secret", "")
+	if err != nil || p.KeyID != "lobby" || p.Scopes != ScopeRead|ScopeDraw {
+		t.Errorf("Expected lobby, got %v, %v", p, err)
+	}
+	for _, key := range []string{"", "lobby", "LOBBY-SECRET"} {
+		if _, err := kr.Authenticate(key, ""); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Expected %q to be rejected, got %v", key, err)
+		}
+	}
+
+	// Test new keys get a secret and IDs are unique.
+	secret, err := kr.Add(Key{ID: "bot"})
+	if err != nil || len(secret) != 64 {
+		t.Fatalf("Expected a 64 character secret, got %q, %v", secret, err)
+	}
+	if _, err := kr.Add(Key{ID: "bot"}); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Expected %v, got %v", ErrKeyExists, err)
+	}
+	if _, err := kr.Add(Key{ID: "no spaces"}); !errors.Is(err, ErrInvalidKeyID) {
+		t.Errorf("Expected %v, got %v", ErrInvalidKeyID, err)
+	}
+	keys := kr.Keys()
+	if len(keys) != 3 || keys[0].ID != "bot" || keys[2].ID != "ops" {
+		t.Errorf("Expected bot, lobby and ops, got %v", keys)
+	}
+
+	// Test share tokens grant their access, and go away with their key.
+	deckID := uuid.New()
+	token, _, err := kr.Share(deckID, AccessDraw, "bot")
+	if err != nil {
+		t.Fatalf("Failed to share: %v", err)
+	}
+	p, err = kr.Authenticate("", token)
+	if err != nil || p.Share == nil || p.Share.DeckID != deckID || p.Share.Access != AccessDraw {
+		t.Errorf("Expected draw access to %s, got %v, %v", deckID, p, err)
+	}
+	if _, _, err := kr.Share(deckID, AccessOwner, "bot"); err == nil {
+		t.Errorf("Expected share tokens not to grant owner access")
+	}
+	err = kr.Delete("bot")
+	if err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	if _, err := kr.Authenticate(secret, ""); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected the deleted key to be rejected, got %v", err)
+	}
+	if _, err := kr.Authenticate("", token); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected the share token of the deleted key to be rejected, got %v", err)
+	}
+	if err := kr.Delete("bot"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected %v, got %v", ErrKeyNotFound, err)
+	}
+
+	// Test keys need a secret.
+	_, err = ReadKeyring(strings.NewReader("- id: ops\n"))
+	if err == nil {
+		t.Errorf("Expected a key without a secret to be rejected")
+	}
+}
+
+func TestPrincipalAccess(t *testing.T) {
+	owned := deck.Deck{DeckID: uuid.New(), Owner: "ann"}
+	unowned := deck.Deck{DeckID: uuid.New()}
+	played := deck.Deck{DeckID: uuid.New(), Owner: "player:ann"}
+	share := &Share{DeckID: owned.DeckID, Access: AccessRead, KeyID: "ann"}
+	key := ScopeRead | ScopeDraw
+	admin := key | ScopeAdmin
+
+	cases := []struct {
+		name string
+		p    Principal
+		d    deck.Deck
+		want Access
+	}{
+		{"owner", Principal{KeyID: "ann", Scopes: key}, owned, AccessOwner},
+		{"other key", Principal{KeyID: "bob", Scopes: key}, owned, AccessNone},
+		{"admin", Principal{KeyID: "ops", Scopes: admin}, owned, AccessOwner},
+		{"unowned deck", Principal{KeyID: "ann", Scopes: key}, unowned, AccessNone},
+		{"unowned deck as admin", Principal{KeyID: "ops", Scopes: admin}, unowned, AccessOwner},
+		{"share token", Principal{KeyID: "ann", Share: share, Scopes: ScopeRead}, owned, AccessRead},
+		{"share token of another deck", Principal{KeyID: "ann", Share: share, Scopes: ScopeRead}, unowned, AccessNone},
+		{"player", Principal{Player: "ann", Scopes: key}, played, AccessOwner},
+		{"player with the name of a key", Principal{Player: "ann", Scopes: key}, owned, AccessNone},
+		{"player with read scope", Principal{Player: "ann", Scopes: ScopeRead}, played, AccessRead},
+		{"player without scopes", Principal{Player: "ann"}, played, AccessNone},
+		{"player of a lobby deck", Principal{Player: "bob", Scopes: key, Decks: []uuid.UUID{owned.DeckID}}, owned, AccessDraw},
+		{"player with admin scope", Principal{Player: "bob", Scopes: ScopeAdmin}, owned, AccessOwner},
+	}
+	for _, c := range cases {
+		if got := c.p.Access(c.d); got != c.want {
+			t.Errorf("%s: expected %s access, got %s", c.name, c.want, got)
+		}
+	}
+
+	if err := (Principal{KeyID: "ann", Share: share, Scopes: key}).CanCreate(); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected share tokens not to create decks, got %v", err)
+	}
+	if err := (Principal{Player: "ann", Scopes: ScopeRead}).CanCreate(); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected players without deck:draw not to create decks, got %v", err)
+	}
+	if err := (Principal{KeyID: "ann", Scopes: key}).CanAdmin(); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected keys that aren't admin keys to be forbidden, got %v", err)
+	}
+}
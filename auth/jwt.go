@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Algorithms bearer tokens can be signed with.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// defaultLeeway is how far clocks may drift apart before tokens are
+// considered expired or not valid yet.
+const defaultLeeway = time.Minute
+
+// TokenError is returned for bearer tokens that can't be verified. It matches
+// ErrUnauthenticated with errors.Is.
+type TokenError struct {
+	Reason string
+}
+
+func (e *TokenError) Error() string {
+	return "Invalid bearer token: " + e.Reason
+}
+
+func (e *TokenError) Unwrap() error {
+	return ErrUnauthenticated
+}
+
+// JWK is a key bearer tokens can be signed with.
+type JWK struct {
+	// ID is matched against the kid header of tokens, when both are set.
+	ID string
+	// Alg is AlgHS256, AlgRS256 or AlgEdDSA.
+	Alg string
+	// Key is the secret as a []byte for HS256, an *rsa.PublicKey for RS256
+	// and an ed25519.PublicKey for EdDSA.
+	Key any
+}
+
+func (k JWK) check() error {
+	switch key := k.Key.(type) {
+	case []byte:
+		if k.Alg != AlgHS256 {
+			return fmt.Errorf("secret keys are for %s, not %s", AlgHS256, k.Alg)
+		}
+		if len(key) < sha256.Size {
+			return fmt.Errorf("%s secrets need at least %d bytes", AlgHS256, sha256.Size)
+		}
+	case *rsa.PublicKey:
+		if k.Alg != AlgRS256 {
+			return fmt.Errorf("RSA keys are for %s, not %s", AlgRS256, k.Alg)
+		}
+		if key.N.BitLen() < 2048 {
+			return errors.New("RSA keys need at least 2048 bits")
+		}
+	case ed25519.PublicKey:
+		if k.Alg != AlgEdDSA {
+			return fmt.Errorf("Ed25519 keys are for %s, not %s", AlgEdDSA, k.Alg)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return errors.New("invalid Ed25519 key")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", k.Key)
+	}
+	return nil
+}
+
+// verify reports whether sig is the signature of input with k.
+func (k JWK) verify(input string, sig []byte) bool {
+	switch key := k.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256([]byte(input))
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, []byte(input), sig)
+	}
+	return false
+}
+
+// LoadPublicKey returns the RSA or Ed25519 public key in the PEM file at path,
+// for RS256 or EdDSA tokens.
+func LoadPublicKey(path string) (JWK, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return JWK{}, fmt.Errorf("read public key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return JWK{}, fmt.Errorf("%s isn't a PEM file", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return JWK{}, fmt.Errorf("%s holds a %s, not a public key", path, block.Type)
+	}
+	if err != nil {
+		return JWK{}, fmt.Errorf("parse public key: %w", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{Alg: AlgRS256, Key: key}, nil
+	case ed25519.PublicKey:
+		return JWK{Alg: AlgEdDSA, Key: key}, nil
+	}
+	return JWK{}, fmt.Errorf("%s holds a %T, not an RSA or Ed25519 key", path, key)
+}
+
+// LoadJWKS returns the keys in the JSON Web Key Set file at path. Keys that
+// aren't for signatures with HS256, RS256 or EdDSA are left out.
+func LoadJWKS(path string) ([]JWK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open JWKS: %w", err)
+	}
+	defer f.Close()
+	return ReadJWKS(f)
+}
+
+// ReadJWKS is LoadJWKS for a key set read from r.
+func ReadJWKS(r io.Reader) ([]JWK, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+		} `json:"keys"`
+	}
+	err := json.NewDecoder(r).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	var keys []JWK
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var jwk JWK
+		switch {
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == AlgHS256):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: decode k: %w", i+1, err)
+			}
+			jwk = JWK{ID: k.Kid, Alg: AlgHS256, Key: secret}
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == AlgRS256):
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: decode n: %w", i+1, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("key %d: invalid exponent", i+1)
+			}
+			key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			jwk = JWK{ID: k.Kid, Alg: AlgRS256, Key: key}
+		case k.Kty == "OKP" && k.Crv == "Ed25519" && (k.Alg == "" || k.Alg == AlgEdDSA):
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: decode x: %w", i+1, err)
+			}
+			jwk = JWK{ID: k.Kid, Alg: AlgEdDSA, Key: ed25519.PublicKey(x)}
+		default:
+			continue
+		}
+		err := jwk.check()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		keys = append(keys, jwk)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no HS256, RS256 or EdDSA signing keys")
+	}
+	return keys, nil
+}
+
+// Verifier verifies the JWT bearer tokens of players, such as the ones a game
+// lobby issues.
+type Verifier struct {
+	keys     []JWK
+	issuer   string
+	audience string
+	claim    string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// VerifierOption configures optional Verifier checks.
+type VerifierOption func(v *Verifier)
+
+// WithIssuer makes the verifier reject tokens whose iss claim isn't iss.
+func WithIssuer(iss string) VerifierOption {
+	return func(v *Verifier) {
+		v.issuer = iss
+	}
+}
+
+// WithAudience makes the verifier reject tokens whose aud claim doesn't
+// include aud.
+func WithAudience(aud string) VerifierOption {
+	return func(v *Verifier) {
+		v.audience = aud
+	}
+}
+
+// WithPlayerClaim sets the claim holding the player of tokens, sub unless
+// configured otherwise.
+func WithPlayerClaim(claim string) VerifierOption {
+	return func(v *Verifier) {
+		v.claim = claim
+	}
+}
+
+// NewVerifier returns a verifier of tokens signed with any of keys.
+func NewVerifier(keys []JWK, opts ...VerifierOption) (*Verifier, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no keys to verify tokens with")
+	}
+	for i, k := range keys {
+		err := k.check()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+	}
+	v := &Verifier{
+		keys:   keys,
+		claim:  "sub",
+		leeway: defaultLeeway,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v, nil
+}
+
+// Verify returns the principal of a token: its player, the scopes in its
+// scope or scp claim, separated by spaces or in an array, and the decks in
+// its decks claim. Tokens must be signed with one of the keys of the verifier
+// and have an expiry.
+func (v *Verifier) Verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, &TokenError{"not a JWT"}
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return Principal{}, &TokenError{"invalid header"}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, &TokenError{"invalid signature"}
+	}
+	input := parts[0] + "." + parts[1]
+	verified := slices.ContainsFunc(v.keys, func(k JWK) bool {
+		if k.Alg != header.Alg || (header.Kid != "" && k.ID != "" && k.ID != header.Kid) {
+			return false
+		}
+		return k.verify(input, sig)
+	})
+	if !verified {
+		return Principal{}, &TokenError{"invalid signature"}
+	}
+
+	var claims map[string]any
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return Principal{}, &TokenError{"invalid claims"}
+	}
+	return v.principal(claims)
+}
+
+// principal checks the claims of a token with a valid signature, and returns
+// its principal.
+func (v *Verifier) principal(claims map[string]any) (Principal, error) {
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return Principal{}, &TokenError{"no expiry"}
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.leeway)) {
+		return Principal{}, &TokenError{"expired"}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return Principal{}, &TokenError{"not valid yet"}
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return Principal{}, &TokenError{"wrong issuer"}
+	}
+	if v.audience != "" && !slices.Contains(stringList(claims["aud"]), v.audience) {
+		return Principal{}, &TokenError{"wrong audience"}
+	}
+
+	player, _ := claims[v.claim].(string)
+	if player == "" {
+		return Principal{}, &TokenError{fmt.Sprintf("no %s claim", v.claim)}
+	}
+	p := Principal{Player: player}
+	for _, name := range []string{"scope", "scp"} {
+		for _, s := range stringList(claims[name]) {
+			p.Scopes |= ParseScopes(strings.Fields(s)...)
+		}
+	}
+	for _, s := range stringList(claims["decks"]) {
+		u, err := uuid.Parse(s)
+		if err != nil {
+			return Principal{}, &TokenError{"invalid deck in decks claim"}
+		}
+		p.Decks = append(p.Decks, u)
+	}
+	return p, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token into v.
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// stringList returns the strings in a claim that is either a string or an
+// array of them.
+func stringList(claim any) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []any:
+		var ss []string
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// signJWT returns a token with claims signed by key, an HS256 secret, an RSA
+// or an Ed25519 private key.
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Failed to encode %v: %v", v, err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(input))
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, []byte(input))
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifier(t *testing.T) {
+	secret := []byte("a secret of at least thirty-two bytes")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys, err := ReadJWKS(strings.NewReader(fmt.Sprintf(`{"keys":[
+		{"kty":"oct","kid":"hs","k":%q},
+		{"kty":"RSA","kid":"rs","alg":"RS256","use":"sig","n":%q,"e":%q},
+		{"kty":"OKP","kid":"ed","crv":"Ed25519","x":%q},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":"","y":""},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"","e":""}
+	]}`, b64(secret), b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()), b64(edPub))))
+	if err != nil {
+		t.Fatalf("Failed to read JWKS: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("Expected the EC and encryption keys to be left out, got %v", keys)
+	}
+	now := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	v, err := NewVerifier(keys, WithIssuer("lobby"), WithAudience("deck"))
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	v.now = func() time.Time { return now }
+
+	deckID := uuid.New()
+	claims := func(extra ...any) map[string]any {
+		c := map[string]any{
+			"sub":   "ann",
+			"iss":   "lobby",
+			"aud":   []string{"deck", "chat"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"scope": "deck:read deck:draw profile",
+		}
+		for i := 0; i < len(extra); i += 2 {
+			if extra[i+1] == nil {
+				delete(c, extra[i].(string))
+			} else {
+				c[extra[i].(string)] = extra[i+1]
+			}
+		}
+		return c
+	}
+
+	// Test tokens signed with each algorithm map to their player and scopes.
+	for _, c := range []struct {
+		alg, kid string
+		key      any
+	}{
+		{AlgHS256, "hs", secret},
+		{AlgRS256, "rs", rsaKey},
+		{AlgEdDSA, "ed", edKey},
+		{AlgEdDSA, "", edKey},
+	} {
+		p, err := v.Verify(signJWT(t, c.alg, c.kid, c.key, claims()))
+		if err != nil {
+			t.Errorf("%s: failed to verify: %v", c.alg, err)
+			continue
+		}
+		if p.Player != "ann" || p.Scopes != ScopeRead|ScopeDraw || p.ID() != "player ann" || p.Owner() != "player:ann" {
+			t.Errorf("%s: expected ann with deck:read and deck:draw, got %+v", c.alg, p)
+		}
+	}
+
+	p, err := v.Verify(signJWT(t, AlgHS256, "hs", secret, claims("scope", nil, "scp", []string{"deck:admin"}, "decks", []string{deckID.String()})))
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	if p.Scopes != ScopeAdmin || !reflect.DeepEqual(p.Decks, []uuid.UUID{deckID}) {
+		t.Errorf("Expected deck:admin and deck %s, got %+v", deckID, p)
+	}
+
+	// Test tokens are rejected unless they're signed, current and meant for
+	// this server.
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, c := range []struct {
+		name  string
+		token string
+	}{
+		{"garbage", "not.a.jwt"},
+		{"unsigned", signJWT(t, "none", "", nil, claims())},
+		{"wrong secret", signJWT(t, AlgHS256, "hs", []byte("another secret of thirty-two bytes"), claims())},
+		{"wrong key", signJWT(t, AlgRS256, "rs", other, claims())},
+		{"wrong key ID", signJWT(t, AlgEdDSA, "hs", edKey, claims())},
+		{"expired", signJWT(t, AlgHS256, "hs", secret, claims("exp", now.Add(-2*time.Minute).Unix()))},
+		{"no expiry", signJWT(t, AlgHS256, "hs", secret, claims("exp", nil))},
+		{"not valid yet", signJWT(t, AlgHS256, "hs", secret, claims("nbf", now.Add(time.Hour).Unix()))},
+		{"wrong issuer", signJWT(t, AlgHS256, "hs", secret, claims("iss", "chat"))},
+		{"wrong audience", signJWT(t, AlgHS256, "hs", secret, claims("aud", "chat"))},
+		{"no player", signJWT(t, AlgHS256, "hs", secret, claims("sub", nil))},
+		{"invalid deck", signJWT(t, AlgHS256, "hs", secret, claims("decks", []string{"nope"}))},
+	} {
+		_, err := v.Verify(c.token)
+		var te *TokenError
+		if !errors.As(err, &te) || !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: expected the token to be rejected, got %v", c.name, err)
+		}
+	}
+
+	// Test clocks may drift a little.
+	_, err = v.Verify(signJWT(t, AlgHS256, "hs", secret, claims("exp", now.Add(-30*time.Second).Unix())))
+	if err != nil {
+		t.Errorf("Expected a token that just expired to be accepted, got %v", err)
+	}
+
+	// Test the player can come from another claim.
+	v, _ = NewVerifier(keys, WithPlayerClaim("player"))
+	v.now = func() time.Time { return now }
+	p, err = v.Verify(signJWT(t, AlgHS256, "hs", secret, claims("player", "bob")))
+	if err != nil || p.Player != "bob" {
+		t.Errorf("Expected bob, got %+v, %v", p, err)
+	}
+
+	// Test weak keys are refused.
+	for _, k := range []JWK{
+		{Alg: AlgHS256, Key: []byte("short")},
+		{Alg: AlgRS256, Key: edPub},
+		{Alg: "none", Key: secret},
+	} {
+		if _, err := NewVerifier([]JWK{k}); err == nil {
+			t.Errorf("Expected %s key %T to be refused", k.Alg, k.Key)
+		}
+	}
+}
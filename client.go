@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ardanlabs/conf/v3"
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/deckclient"
+)
+
+var errUsage = errors.New("wrong arguments; see --help")
+
+// runNew creates a deck on a running server.
+func runNew(ctx context.Context, w io.Writer) error {
+	cfg := struct {
+		ClientConfig
+		Shuffled bool   `conf:"default:false,help:shuffle the new deck"`
+		Cards    string `conf:"help:codes of the cards in the deck separated by commas; a full deck when empty"`
+	}{}
+	ok, err := parseClientConfig(&cfg)
+	if !ok {
+		return err
+	}
+	p, err := newPrinter(w, cfg.Output)
+	if err != nil {
+		return err
+	}
+
+	var cards []deck.Card
+	for _, code := range strings.FieldsFunc(cfg.Cards, func(r rune) bool { return r == ',' || r == ' ' }) {
+		cards = append(cards, deck.Card{Code: strings.ToUpper(code)})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	d, err := cfg.client().NewDeck(ctx, cfg.Shuffled, cards)
+	if err != nil {
+		return fmt.Errorf("error creating deck: %w", err)
+	}
+	return p.deck(d)
+}
+
+// runGet shows a deck on a running server.
+func runGet(ctx context.Context, w io.Writer) error {
+	cfg := struct {
+		ClientConfig
+		At   uint64 `conf:"default:0,help:show the deck as it was right after this event; needs event sourcing"`
+		Args conf.Args
+	}{}
+	ok, err := parseClientConfig(&cfg)
+	if !ok {
+		return err
+	}
+	p, err := newPrinter(w, cfg.Output)
+	if err != nil {
+		return err
+	}
+	id, err := parseDeckID(cfg.Args, 1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	c := cfg.client()
+	var d *deckclient.Deck
+	if cfg.At == 0 {
+		d, err = c.GetDeck(ctx, id)
+	} else {
+		d, err = c.GetDeckAt(ctx, id, cfg.At)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting deck: %w", err)
+	}
+	return p.deck(d)
+}
+
+// runDraw draws cards from a deck on a running server.
+func runDraw(ctx context.Context, w io.Writer) error {
+	cfg := struct {
+		ClientConfig
+		Args conf.Args
+	}{}
+	ok, err := parseClientConfig(&cfg)
+	if !ok {
+		return err
+	}
+	p, err := newPrinter(w, cfg.Output)
+	if err != nil {
+		return err
+	}
+	id, err := parseDeckID(cfg.Args, 2)
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(cfg.Args.Num(1))
+	if err != nil {
+		return fmt.Errorf("invalid number of cards %q: %w", cfg.Args.Num(1), errUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	cards, err := cfg.client().Draw(ctx, id, count)
+	if err != nil {
+		return fmt.Errorf("error drawing cards: %w", err)
+	}
+	return p.cards(cards)
+}
+
+// runShuffle shuffles the cards remaining in a deck on a running server.
+func runShuffle(ctx context.Context, w io.Writer) error {
+	cfg := struct {
+		ClientConfig
+		Args conf.Args
+	}{}
+	ok, err := parseClientConfig(&cfg)
+	if !ok {
+		return err
+	}
+	p, err := newPrinter(w, cfg.Output)
+	if err != nil {
+		return err
+	}
+	id, err := parseDeckID(cfg.Args, 1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	c := cfg.client()
+	_, err = c.Shuffle(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error shuffling deck: %w", err)
+	}
+	// The shuffle response doesn't carry the cards. The deck only shows
+	// their new order to admins; everyone else sees them counted.
+	d, err := c.GetDeck(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error getting deck: %w", err)
+	}
+	err = p.deck(d)
+	if err != nil || len(d.Cards) > 0 || d.Remaining == 0 || p.format == "json" {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "The order of the %d cards left is hidden.\n", d.Remaining)
+	return err
+}
+
+// parseDeckID checks the number of arguments and parses the first one as a
+// deck ID.
+func parseDeckID(args conf.Args, n int) (uuid.UUID, error) {
+	if len(args) != n {
+		return uuid.Nil, errUsage
+	}
+	id, err := uuid.Parse(args.Num(0))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid deck ID %q: %w", args.Num(0), errUsage)
+	}
+	return id, nil
+}
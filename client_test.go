@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/web"
+)
+
+// deckIDs matches the deck IDs in the output of a subcommand, which change
+// from run to run.
+var deckIDs = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+func TestClientCommands(t *testing.T) {
+	// Test every subcommand prints the server's answer or returns its error.
+	args := os.Args
+	defer func() { os.Args = args }()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	server := httptest.NewServer(web.NewMux(log, da))
+	defer server.Close()
+	follower := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	err := follower.Follow(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to follow: %v", err)
+	}
+	followerServer := httptest.NewServer(web.NewMux(log, follower))
+	defer followerServer.Close()
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	id := d.DeckID.String()
+	url := "--url=" + server.URL
+
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, w io.Writer) error
+		args []string
+		want string
+		err  error
+	}{
+		{name: "new", run: runNew, args: []string{"new", url, "--cards=as,kh"}, want: `DECK ID                               SHUFFLED  REMAINING
+00000000-0000-0000-0000-000000000000  false     2
+`},
+		{name: "new json", run: runNew, args: []string{"new", url, "--cards=as", "--output=json"}, want: `{
+  "deck_id": "00000000-0000-0000-0000-000000000000",
+  "shuffled": false,
+  "remaining": 1
+}
+`},
+		{name: "new unknown output", run: runNew, args: []string{"new", url, "--output=yaml"}, err: errUsage},
+		{name: "draw", run: runDraw, args: []string{"draw", url, id, "2"}, want: "#  CODE  CARD\n1  2C    2♣\n2  3C    3♣\n"},
+		{name: "draw json", run: runDraw, args: []string{"draw", url, "--output=json", id, "1"}, want: `{
+  "cards": [
+    {
+      "code": "4C"
+    }
+  ]
+}
+`},
+		{name: "draw glyph", run: runDraw, args: []string{"draw", url, "--output=glyph", id, "3"}, want: "5♣ 6♣ 7♣\n"},
+		{name: "draw too many", run: runDraw, args: []string{"draw", url, id, "47"}, err: deck.ErrUnsufficientCards},
+		{name: "draw no count", run: runDraw, args: []string{"draw", url, id}, err: errUsage},
+		{name: "draw invalid count", run: runDraw, args: []string{"draw", url, id, "some"}, err: errUsage},
+		{name: "get", run: runGet, args: []string{"get", url, id}, want: `DECK ID                               SHUFFLED  REMAINING
+00000000-0000-0000-0000-000000000000  false     46
+`},
+		{name: "get glyph", run: runGet, args: []string{"get", url, "--output=glyph", id}, want: "00000000-0000-0000-0000-000000000000 shuffled=false remaining=46\n"},
+		{name: "get at", run: runGet, args: []string{"get", url, "--at=1", "--output=glyph", id}, want: "00000000-0000-0000-0000-000000000000 shuffled=false remaining=52\n"},
+		{name: "get unknown deck", run: runGet, args: []string{"get", url, uuid.NewString()}, err: deck.ErrDeckNotFound},
+		{name: "get invalid deck ID", run: runGet, args: []string{"get", url, "some"}, err: errUsage},
+		{name: "shuffle", run: runShuffle, args: []string{"shuffle", url, id}, want: `DECK ID                               SHUFFLED  REMAINING
+00000000-0000-0000-0000-000000000000  true      46
+The order of the 46 cards left is hidden.
+`},
+		{name: "shuffle json", run: runShuffle, args: []string{"shuffle", url, "--output=json", id}, want: `{
+  "deck_id": "00000000-0000-0000-0000-000000000000",
+  "shuffled": true,
+  "remaining": 46
+}
+`},
+		{name: "shuffle unknown deck", run: runShuffle, args: []string{"shuffle", url, uuid.NewString()}, err: deck.ErrDeckNotFound},
+		{name: "promote", run: runPromote, args: []string{"promote", "--url=" + followerServer.URL}, want: `{"role":"leader","applied_seq":0,"lag_events":0,"lag_seconds":0}` + "\n"},
+		{name: "promote leader", run: runPromote, args: []string{"promote", url}, err: deck.ErrNotFollower},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Args = append([]string{"deck"}, tt.args...)
+			var out bytes.Buffer
+			err := tt.run(context.Background(), &out)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v, got %v", tt.err, err)
+			}
+			got := deckIDs.ReplaceAllString(out.String(), uuid.Nil.String())
+			if got != tt.want {
+				t.Errorf("Expected output\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMainExitStatus(t *testing.T) {
+	// Test a failed command exits with status 1 and logs to stderr. main
+	// exits, so it runs in a copy of the test binary.
+	if os.Getenv("DECK_TEST_MAIN") == "1" {
+		os.Args = []string{"deck", "get", "some"}
+		main()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMainExitStatus$")
+	cmd.Env = append(os.Environ(), "DECK_TEST_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Errorf("Expected exit status 1, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), errUsage.Error()) {
+		t.Errorf("Expected the error on stderr, got %q", stderr.String())
+	}
+	if strings.Contains(stdout.String(), errUsage.Error()) {
+		t.Errorf("Expected nothing about the error on stdout, got %q", stdout.String())
+	}
+}
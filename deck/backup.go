@@ -0,0 +1,113 @@
+package deck
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidDeck error = errors.New("Invalid deck")
+
+// Validate checks that a deck is well-formed before it is loaded into a store.
+func (d Deck) Validate() error {
+	if d.DeckID == uuid.Nil {
+		return fmt.Errorf("%w: missing deck_id", ErrInvalidDeck)
+	}
+	if d.Remaining != len(d.Cards) {
+		return fmt.Errorf("%w: remaining is %d but the deck has %d cards", ErrInvalidDeck, d.Remaining, len(d.Cards))
+	}
+	seen := make(map[CardID]bool)
+	check := func(where string, cards []CardID) error {
+		for i, c := range cards {
+			if !c.Valid() {
+				return fmt.Errorf("%w: card %d%s is unknown", ErrInvalidDeck, i, where)
+			}
+			if seen[c] {
+				return fmt.Errorf("%w: card %s%s is duplicated", ErrInvalidDeck, c.Card().Code, where)
+			}
+			seen[c] = true
+		}
+		return nil
+	}
+	err := check("", d.Cards)
+	if err != nil {
+		return err
+	}
+	for name, cards := range d.Hands {
+		if !validName(name) {
+			return fmt.Errorf("%w: %w", ErrInvalidDeck, ErrInvalidName)
+		}
+		err = check(fmt.Sprintf(" of the hand of %q", name), cards)
+		if err != nil {
+			return err
+		}
+	}
+	for name, cards := range d.Piles {
+		if !validName(name) {
+			return fmt.Errorf("%w: %w", ErrInvalidDeck, ErrInvalidName)
+		}
+		err = check(fmt.Sprintf(" of pile %q", name), cards)
+		if err != nil {
+			return err
+		}
+	}
+	for i, c := range d.Flipped {
+		if !seen[c] || slices.Contains(d.Cards, c) || slices.Contains(d.Flipped[:i], c) {
+			return fmt.Errorf("%w: flipped card %d isn't in a hand or pile", ErrInvalidDeck, i)
+		}
+	}
+	return nil
+}
+
+// Export calls fn for every deck in the store, stopping at the first error.
+// The history of the decks isn't exported.
+func (da *DeckAPI) Export(fn func(d Deck) error) error {
+	var err error
+	da.store.Range(func(d Deck) bool {
+		err = fn(d)
+		return err == nil
+	})
+	return err
+}
+
+// Import validates all decks and then loads them into the store, all of them
+// or none: a store that fills up partway through keeps the decks it had.
+// Decks that already exist are overwritten, so importing the same decks twice
+// leaves the store in the same state. Only the decks are imported, not their
+// history, which restarts with a deck_imported event for each.
+func (da *DeckAPI) Import(decks []Deck) (_ int, err error) {
+	da, span := da.startSpan("Import", attrDecks.Int(len(decks)))
+	defer func() { endSpan(span, err) }()
+
+	for i, d := range decks {
+		err := d.Validate()
+		if err != nil {
+			return 0, fmt.Errorf("deck %d: %w", i+1, err)
+		}
+	}
+
+	err = da.Batch(func(tx *DeckAPI) error {
+		for i, d := range decks {
+			_, err := tx.record(Event{
+				Type:     EventDeckImported,
+				DeckID:   d.DeckID,
+				Shuffled: d.Shuffled,
+				Cards:    d.Cards,
+				Hands:    d.Hands,
+				Piles:    d.Piles,
+				Flipped:  d.Flipped,
+				Owner:    d.Owner,
+			}, Deck{})
+			if err != nil {
+				return fmt.Errorf("deck %d: %w", i+1, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(decks), nil
+}
@@ -0,0 +1,68 @@
+package deck
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	// Test a store that fills up partway through keeps the decks it had.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := NewAPI(log, NewStore(log, Limits{}))
+	var decks []Deck
+	for range 2 {
+		d, err := source.New(true, nil)
+		if err != nil {
+			t.Fatalf("Failed to create deck: %v", err)
+		}
+		decks = append(decks, *d)
+	}
+
+	da := NewAPI(log, NewStore(log, Limits{MaxDecks: 2, Policy: PolicyReject}))
+	kept, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	n, err := da.Import(decks)
+	if !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected %v, got %v", ErrStoreFull, err)
+	}
+	if n != 0 {
+		t.Errorf("Expected no imported decks, got %d", n)
+	}
+	if u := da.Usage(); u.Decks != 1 {
+		t.Errorf("Expected the store to keep 1 deck, got %d", u.Decks)
+	}
+	_, err = da.Get(decks[0].DeckID)
+	if !errors.Is(err, ErrDeckNotFound) {
+		t.Errorf("Expected %v for a deck of the failed import, got %v", ErrDeckNotFound, err)
+	}
+	_, err = da.Get(kept.DeckID)
+	if err != nil {
+		t.Errorf("Expected the existing deck to be kept, got %v", err)
+	}
+
+	// Test the history of a deck isn't imported.
+	_, err = source.Draw(decks[0].DeckID, 3)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	drawn, err := source.Get(decks[0].DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	da = NewAPI(log, NewStore(log, Limits{}), WithEventLog(NewMemoryEventLog()))
+	n, err = da.Import([]Deck{*drawn})
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 imported deck, got %d and %v", n, err)
+	}
+	events, err := da.History(drawn.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != EventDeckImported {
+		t.Errorf("Expected only a %s event, got %v", EventDeckImported, events)
+	}
+}
@@ -0,0 +1,176 @@
+package deck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Batch runs fn against a DeckAPI whose changes are only applied when fn
+// returns without an error, all at once: other requests never see part of a
+// batch, and a failed batch changes nothing. fn must only use the DeckAPI it
+// is given, and not keep it.
+func (da *DeckAPI) Batch(fn func(tx *DeckAPI) error) (err error) {
+	da, span := da.startSpan("Batch")
+	defer func() { endSpan(span, err) }()
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return err
+	}
+
+	ov := &overlayStore{Store: da.store, decks: make(map[uuid.UUID]Deck), deleted: make(map[uuid.UUID]bool)}
+	pending := &pendingLog{EventLog: da.events}
+	tx := &DeckAPI{
+		state: &state{
+			store:  ov,
+			events: pending,
+			repl:   replication{role: RoleLeader},
+			log:    da.log,
+			stats:  da.stats,
+			batch:  true,
+		},
+		actor: da.actor,
+		ctx:   da.ctx,
+	}
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return da.commit(ov, pending.events)
+}
+
+// commit writes the decks changed by a batch to the store, undoing the writes
+// if one fails, and then records its events. It must be called with da.mu
+// held, which keeps readers from seeing part of it.
+func (da *DeckAPI) commit(ov *overlayStore, events []Event) error {
+	var undos []undo
+	for _, u := range ov.order {
+		prev, err := da.store.QueryById(da.ctx, u)
+		existed := err == nil
+		if ov.deleted[u] {
+			if !existed {
+				// Created and deleted by the batch.
+				continue
+			}
+			err = da.store.Delete(da.ctx, u)
+		} else {
+			err = da.put(ov.decks[u])
+		}
+		if err != nil {
+			return errors.Join(err, da.undo(undos))
+		}
+		if !existed {
+			prev = Deck{DeckID: u}
+		}
+		undos = append(undos, undo{deck: prev, existed: existed})
+	}
+
+	for _, e := range events {
+		if da.events != nil {
+			var err error
+			e, err = da.events.Append(e)
+			if err != nil {
+				return err
+			}
+		}
+		da.stats.count(e)
+		da.hub.publish(e)
+	}
+	return nil
+}
+
+// overlayStore keeps the decks changed and deleted by a batch apart from the
+// store they were read from.
+type overlayStore struct {
+	Store
+	decks   map[uuid.UUID]Deck
+	deleted map[uuid.UUID]bool
+	order   []uuid.UUID
+}
+
+func (ov *overlayStore) Create(ctx context.Context, d Deck) error {
+	return ov.Update(ctx, d.DeckID, d)
+}
+
+func (ov *overlayStore) QueryById(ctx context.Context, u uuid.UUID) (Deck, error) {
+	if ov.deleted[u] {
+		return Deck{}, ErrDeckNotFound
+	}
+	d, ok := ov.decks[u]
+	if ok {
+		return d, nil
+	}
+	return ov.Store.QueryById(ctx, u)
+}
+
+func (ov *overlayStore) Update(ctx context.Context, u uuid.UUID, d Deck) error {
+	ov.touch(u)
+	ov.decks[u] = d
+	delete(ov.deleted, u)
+	return nil
+}
+
+func (ov *overlayStore) Delete(ctx context.Context, u uuid.UUID) error {
+	_, err := ov.QueryById(ctx, u)
+	if err != nil {
+		return err
+	}
+	ov.touch(u)
+	delete(ov.decks, u)
+	ov.deleted[u] = true
+	return nil
+}
+
+// touch records that the batch writes u, in the order it first does.
+func (ov *overlayStore) touch(u uuid.UUID) {
+	_, changed := ov.decks[u]
+	if !changed && !ov.deleted[u] {
+		ov.order = append(ov.order, u)
+	}
+}
+
+// undo is how a deck was before a batch wrote it, and whether it existed.
+type undo struct {
+	deck    Deck
+	existed bool
+}
+
+// undo puts back the decks of undos in reverse order, after a failed commit.
+// The writes it couldn't undo are logged and returned, since the store is
+// then left with part of the batch.
+func (da *DeckAPI) undo(undos []undo) error {
+	var errs []error
+	for i := len(undos) - 1; i >= 0; i-- {
+		var err error
+		if undos[i].existed {
+			err = da.put(undos[i].deck)
+		} else {
+			err = da.store.Delete(da.ctx, undos[i].deck.DeckID)
+		}
+		if err != nil {
+			da.log.ErrorContext(da.ctx, "batch", "undo", "failed", "deckID", undos[i].deck.DeckID, "error", err)
+			errs = append(errs, fmt.Errorf("undoing deck %s: %w", undos[i].deck.DeckID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pendingLog holds the events of a batch until it's committed. Reads go to
+// the log it wraps, if any.
+type pendingLog struct {
+	EventLog
+	events []Event
+}
+
+func (pl *pendingLog) Append(e Event) (Event, error) {
+	e.Time = time.Now().UTC()
+	pl.events = append(pl.events, e)
+	return e, nil
+}
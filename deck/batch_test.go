@@ -0,0 +1,194 @@
+package deck
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBatch(t *testing.T) {
+	// Test a failed batch leaves the store and the event log untouched.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	el := NewMemoryEventLog()
+	da := NewAPI(log, NewStore(log, Limits{}), WithEventLog(el))
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	var created *Deck
+	err = da.Batch(func(tx *DeckAPI) error {
+		_, err := tx.Draw(d.DeckID, 5)
+		if err != nil {
+			return err
+		}
+		created, err = tx.New(false, nil)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Draw(d.DeckID, 48)
+		return err
+	})
+	if !errors.Is(err, ErrUnsufficientCards) {
+		t.Fatalf("Expected %v, got %v", ErrUnsufficientCards, err)
+	}
+	u, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if !reflect.DeepEqual(u, d) {
+		t.Errorf("Expected the deck to stay %v, got %v", d, u)
+	}
+	_, err = da.Get(created.DeckID)
+	if !errors.Is(err, ErrDeckNotFound) {
+		t.Errorf("Expected the deck created by the batch to be gone, got %v", err)
+	}
+	events, err := da.History(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected only the creation event, got %v", events)
+	}
+
+	// Test a batch is applied and recorded as a whole.
+	var drawn []CardID
+	err = da.Batch(func(tx *DeckAPI) error {
+		var err error
+		drawn, err = tx.Draw(d.DeckID, 5)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Return(d.DeckID, drawn[:2])
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to run batch: %v", err)
+	}
+	u, err = da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if u.Remaining != 49 || !reflect.DeepEqual(u.Cards[47:], drawn[:2]) {
+		t.Errorf("Expected 49 cards ending with %v, got %v", drawn[:2], u.Cards)
+	}
+	events, err = da.History(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	folded, _ := Fold(events)
+	if len(events) != 3 || !reflect.DeepEqual(&folded, u) {
+		t.Errorf("Expected 3 events folding into %v, got %v", u, events)
+	}
+
+	// Test cards already in the deck can't be returned.
+	_, err = da.Return(d.DeckID, u.Cards[:1])
+	if !errors.Is(err, ErrCardInDeck) {
+		t.Errorf("Expected %v, got %v", ErrCardInDeck, err)
+	}
+
+	// Test reads wait for a batch to finish instead of seeing part of it.
+	inside, release := make(chan struct{}), make(chan struct{})
+	read := make(chan *Deck)
+	go func() {
+		<-inside
+		got, _ := da.Get(d.DeckID)
+		read <- got
+	}()
+	go da.Batch(func(tx *DeckAPI) error {
+		_, err := tx.Draw(d.DeckID, 1)
+		close(inside)
+		<-release
+		return err
+	})
+	select {
+	case <-read:
+		t.Errorf("Expected the read to wait for the batch")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if u := <-read; u.Remaining != 48 {
+		t.Errorf("Expected the read to see the batch's draw, got %d cards", u.Remaining)
+	}
+
+	// Test deletes are only applied with the rest of the batch.
+	for _, fail := range []bool{true, false} {
+		err = da.Batch(func(tx *DeckAPI) error {
+			err := tx.store.Delete(tx.ctx, d.DeckID)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Get(d.DeckID); !errors.Is(err, ErrDeckNotFound) {
+				t.Errorf("Expected the batch to see the deck deleted, got %v", err)
+			}
+			if fail {
+				return errBatchFailed
+			}
+			return nil
+		})
+		_, getErr := da.Get(d.DeckID)
+		if fail && (!errors.Is(err, errBatchFailed) || getErr != nil) {
+			t.Errorf("Expected a failed batch to keep the deck, got %v and %v", err, getErr)
+		}
+		if !fail && (err != nil || !errors.Is(getErr, ErrDeckNotFound)) {
+			t.Errorf("Expected the batch to delete the deck, got %v and %v", err, getErr)
+		}
+	}
+}
+
+var errBatchFailed = errors.New("batch failed")
+
+// failingStore fails to update or delete the decks in fail.
+type failingStore struct {
+	Store
+	fail map[uuid.UUID]bool
+}
+
+var errStoreFailed = errors.New("store failed")
+
+func (fs *failingStore) Update(ctx context.Context, u uuid.UUID, d Deck) error {
+	if fs.fail[u] {
+		return errStoreFailed
+	}
+	return fs.Store.Update(ctx, u, d)
+}
+
+func (fs *failingStore) Delete(ctx context.Context, u uuid.UUID) error {
+	if fs.fail[u] {
+		return errStoreFailed
+	}
+	return fs.Store.Delete(ctx, u)
+}
+
+func TestBatchUndo(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	fs := &failingStore{Store: NewStore(log, Limits{}), fail: make(map[uuid.UUID]bool)}
+	da := NewAPI(log, fs)
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	// Test the writes a failed commit can't undo are reported with the
+	// failure.
+	var created *Deck
+	err = da.Batch(func(tx *DeckAPI) error {
+		created, err = tx.New(false, nil)
+		if err != nil {
+			return err
+		}
+		fs.fail[d.DeckID], fs.fail[created.DeckID] = true, true
+		_, err = tx.Draw(d.DeckID, 1)
+		return err
+	})
+	if !errors.Is(err, errStoreFailed) || !strings.Contains(err.Error(), "undoing deck "+created.DeckID.String()) {
+		t.Errorf("Expected the failed write and undo, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CardID is the compact, in-memory representation of a card: an index into
+// the card table. Decks only hold CardIDs, they are expanded into a Card when
+// they're sent out.
+type CardID uint8
+
+// cardTable holds every card in the sorted deck order, from the 2 of clubs to
+// the ace of spades.
+var cardTable = func() []Card {
+	var cards []Card
+	for _, suit := range []string{"C", "D", "H", "S"} {
+		for _, value := range []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K", "A"} {
+			cards = append(cards, Card{Code: value + suit})
+		}
+	}
+	return cards
+}()
+
+var cardIDs = func() map[string]CardID {
+	ids := make(map[string]CardID, len(cardTable))
+	for i, c := range cardTable {
+		ids[c.Code] = CardID(i)
+	}
+	return ids
+}()
+
+var suitGlyphs = map[byte]string{'C': "♣", 'D': "♦", 'H': "♥", 'S': "♠"}
+
+// Glyph returns the card with its suit symbol, such as "10♥". Unknown codes
+// are returned as they are.
+func (c Card) Glyph() string {
+	if len(c.Code) < 2 {
+		return c.Code
+	}
+	glyph, ok := suitGlyphs[c.Code[len(c.Code)-1]]
+	if !ok {
+		return c.Code
+	}
+	return c.Code[:len(c.Code)-1] + glyph
+}
+
+// ParseCard returns the CardID of a card code, such as "10H".
+func ParseCard(code string) (CardID, error) {
+	id, ok := cardIDs[code]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown card code %q", ErrInvalidDeck, code)
+	}
+	return id, nil
+}
+
+// Card expands the CardID into the card it represents.
+func (c CardID) Card() Card {
+	return cardTable[c]
+}
+
+func (c CardID) Valid() bool {
+	return int(c) < len(cardTable)
+}
+
+func (c CardID) MarshalJSON() ([]byte, error) {
+	if !c.Valid() {
+		return nil, fmt.Errorf("invalid card id %d", c)
+	}
+	return json.Marshal(c.Card())
+}
+
+func (c *CardID) UnmarshalJSON(b []byte) error {
+	var card Card
+	err := json.Unmarshal(b, &card)
+	if err != nil {
+		return err
+	}
+	*c, err = ParseCard(card.Code)
+	return err
+}
+
+// Expand turns CardIDs into the cards they represent.
+func Expand(ids []CardID) []Card {
+	if ids == nil {
+		return nil
+	}
+	cards := make([]Card, len(ids))
+	for i, id := range ids {
+		cards[i] = id.Card()
+	}
+	return cards
+}
@@ -0,0 +1,113 @@
+package deck
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCardJSON(t *testing.T) {
+	// Test card ids are encoded with the public card shape.
+	id, err := ParseCard("10H")
+	if err != nil {
+		t.Fatalf("Failed to parse card: %v", err)
+	}
+	d := Deck{DeckID: uuid.Nil, Remaining: 1, Cards: []CardID{id}}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("Failed to encode deck: %v", err)
+	}
+	expected := `{"deck_id":"00000000-0000-0000-0000-000000000000","shuffled":false,"remaining":1,"cards":[{"code":"10H"}]}`
+	if string(b) != expected {
+		t.Errorf("Expected %v, got %v", expected, string(b))
+	}
+
+	// Test card ids are decoded back from the public card shape.
+	var decoded Deck
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("Failed to decode deck: %v", err)
+	}
+	if len(decoded.Cards) != 1 || decoded.Cards[0] != id {
+		t.Errorf("Expected cards %v, got %v", d.Cards, decoded.Cards)
+	}
+
+	// Test cards are shown with their suit symbol.
+	if glyph := id.Card().Glyph(); glyph != "10♥" {
+		t.Errorf("Expected 10♥, got %v", glyph)
+	}
+
+	// Test unknown cards are rejected.
+	err = json.Unmarshal([]byte(`{"cards":[{"code":"1Z"}]}`), &decoded)
+	if err == nil {
+		t.Errorf("Expected an error decoding an unknown card")
+	}
+}
+
+func TestCardTable(t *testing.T) {
+	// Test every card has a code of its own, and the suit of a code is the
+	// suit of the card: spades used to be coded as hearts.
+	seen := make(map[string]bool, len(cardTable))
+	for _, c := range cardTable {
+		if seen[c.Code] {
+			t.Errorf("Expected a single %v", c.Code)
+		}
+		seen[c.Code] = true
+	}
+	if len(seen) != 52 {
+		t.Errorf("Expected 52 cards, got %d", len(seen))
+	}
+	for i, c := range cardTable[39:] {
+		if c.Code[len(c.Code)-1] != 'S' || !strings.HasSuffix(c.Glyph(), "♠") {
+			t.Errorf("Expected card %d to be a spade, got %v", 39+i, c.Code)
+		}
+	}
+}
+
+// stringDeck is the previous deck layout, where every card carried its
+// strings, kept to compare memory usage against.
+type stringDeck struct {
+	DeckID    uuid.UUID
+	Shuffled  bool
+	Remaining int
+	Cards     []Card
+}
+
+func BenchmarkIdleDeckStrings(b *testing.B) {
+	benchmarkIdleDecks(b, func() any {
+		cards := make([]Card, len(cardTable))
+		copy(cards, cardTable)
+		return &stringDeck{DeckID: uuid.New(), Remaining: len(cards), Cards: cards}
+	})
+}
+
+func BenchmarkIdleDeckCardIDs(b *testing.B) {
+	benchmarkIdleDecks(b, func() any {
+		cards := getSortedCards()
+		return &Deck{DeckID: uuid.New(), Remaining: len(cards), Cards: cards}
+	})
+}
+
+// benchmarkIdleDecks keeps b.N decks alive and reports the heap they retain.
+func benchmarkIdleDecks(b *testing.B, newDeck func() any) {
+	b.ReportAllocs()
+	decks := make([]any, b.N)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := range b.N {
+		decks[i] = newDeck()
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	retained := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	b.ReportMetric(float64(retained)/float64(b.N), "heap-B/deck")
+	runtime.KeepAlive(decks)
+}
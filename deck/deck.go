@@ -1,6 +1,7 @@
 package deck
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"math/rand"
@@ -14,200 +15,125 @@ type Deck struct {
 	DeckID    uuid.UUID `json:"deck_id"`
 	Shuffled  bool      `json:"shuffled"`
 	Remaining int       `json:"remaining"`
-	Cards     []Card    `json:"cards,omitempty"`
+	Cards     []CardID  `json:"cards,omitempty"`
+	// Hands holds the cards dealt to each player, and Piles the cards played
+	// to each pile, the last one on top.
+	Hands map[string][]CardID `json:"hands,omitempty"`
+	Piles map[string][]CardID `json:"piles,omitempty"`
+	// Flipped holds the cards that lie the other way up from how they were
+	// dealt or played: face up in hands, and face down in piles.
+	Flipped []CardID `json:"flipped,omitempty"`
+	// Owner is who created the deck, if anyone: the ID of an API key, or a
+	// player prefixed with "player:".
+	Owner string `json:"owner,omitempty"`
 }
 
+// Card is the public shape of a card. Cards the viewer can't see only have
+// Hidden set, and cards in hands and piles their Orientation.
 type Card struct {
-	Code  string `json:"code"`
-	Value string `json:"value,omitempty"`
-	Suit  string `json:"suit,omitempty"`
+	Code        string      `json:"code,omitempty"`
+	Value       string      `json:"value,omitempty"`
+	Suit        string      `json:"suit,omitempty"`
+	Hidden      bool        `json:"hidden,omitempty"`
+	Orientation Orientation `json:"orientation,omitempty"`
 }
 
 type DeckAPI struct {
-	store *DeckStore
-	mu    sync.Mutex
+	*state
+	// actor is recorded in the events of the changes made through this
+	// DeckAPI.
+	actor string
+	// ctx is passed on to the store, so it logs the request ID of the
+	// request being served, and its spans are part of the request's trace.
+	ctx context.Context
+}
+
+// state is shared by a DeckAPI and the copies As returns.
+type state struct {
+	store  Store
+	events EventLog
+	repl   replication
+	hub    hub
+	// mu is held by the operations that change decks, and for reading by
+	// those that read them, so reads don't see part of a batch.
+	mu    sync.RWMutex
 	log   *slog.Logger
+	stats *stats
+	// batch is set for the DeckAPI of a batch, whose events are only counted
+	// in stats once they're committed.
+	batch bool
+}
+
+// Option configures optional DeckAPI features.
+type Option func(da *DeckAPI)
+
+// WithEventLog enables event sourcing: every operation is appended to el and
+// the store becomes a projection that can be rebuilt from it.
+func WithEventLog(el EventLog) Option {
+	return func(da *DeckAPI) {
+		da.events = el
+	}
 }
 
 var ErrUnsufficientCards error = errors.New("Deck doesn't have that many cards to draw")
 var ErrDeckNotFound error = errors.New("Deck not found")
 
-func getSortedCards() []Card {
-	return []Card{
-		// Clubs
-		{
-			Code: "2C",
-		},
-		{
-			Code: "3C",
-		},
-		{
-			Code: "4C",
-		},
-		{
-			Code: "5C",
-		},
-		{
-			Code: "6C",
-		},
-		{
-			Code: "7C",
-		},
-		{
-			Code: "8C",
-		},
-		{
-			Code: "9C",
-		},
-		{
-			Code: "10C",
-		},
-		{
-			Code: "JC",
-		},
-		{
-			Code: "QC",
-		},
-		{
-			Code: "KC",
-		},
-		{
-			Code: "AC",
-		},
-
-		// Diamonds
-		{
-			Code: "2D",
-		},
-		{
-			Code: "3D",
-		},
-		{
-			Code: "4D",
-		},
-		{
-			Code: "5D",
-		},
-		{
-			Code: "6D",
-		},
-		{
-			Code: "7D",
-		},
-		{
-			Code: "8D",
-		},
-		{
-			Code: "9D",
-		},
-		{
-			Code: "10D",
-		},
-		{
-			Code: "JD",
-		},
-		{
-			Code: "QD",
-		},
-		{
-			Code: "KD",
-		},
-		{
-			Code: "AD",
-		},
-
-		// Hearts
-		{
-			Code: "2H",
-		},
-		{
-			Code: "3H",
-		},
-		{
-			Code: "4H",
-		},
-		{
-			Code: "5H",
-		},
-		{
-			Code: "6H",
-		},
-		{
-			Code: "7H",
-		},
-		{
-			Code: "8H",
-		},
-		{
-			Code: "9H",
-		},
-		{
-			Code: "10H",
-		},
-		{
-			Code: "JH",
-		},
-		{
-			Code: "QH",
-		},
-		{
-			Code: "KH",
-		},
-		{
-			Code: "AH",
-		},
-
-		// Spades
-		{
-			Code: "2H",
-		},
-		{
-			Code: "3H",
-		},
-		{
-			Code: "4H",
-		},
-		{
-			Code: "5H",
-		},
-		{
-			Code: "6H",
-		},
-		{
-			Code: "7H",
-		},
-		{
-			Code: "8H",
-		},
-		{
-			Code: "9H",
-		},
-		{
-			Code: "10H",
-		},
-		{
-			Code: "JH",
-		},
-		{
-			Code: "QH",
-		},
-		{
-			Code: "KH",
-		},
-		{
-			Code: "AH",
-		},
+// InsufficientCardsError is returned for draws and deals of more cards than
+// a deck has left. It matches ErrUnsufficientCards with errors.Is.
+type InsufficientCardsError struct {
+	Remaining int
+	Requested int
+}
+
+func (e *InsufficientCardsError) Error() string {
+	return ErrUnsufficientCards.Error()
+}
+
+func (e *InsufficientCardsError) Unwrap() error {
+	return ErrUnsufficientCards
+}
+
+func getSortedCards() []CardID {
+	cards := make([]CardID, len(cardTable))
+	for i := range cards {
+		cards[i] = CardID(i)
 	}
+	return cards
 }
 
-func NewAPI(log *slog.Logger) *DeckAPI {
-	return &DeckAPI{
+func NewAPI(log *slog.Logger, store Store, opts ...Option) *DeckAPI {
+	da := &DeckAPI{state: &state{
 		log:   log,
-		store: NewStore(log),
+		store: store,
+		repl:  replication{role: RoleLeader},
+		stats: &stats{},
+	}, ctx: context.Background()}
+	for _, opt := range opts {
+		opt(da)
 	}
+	return da
+}
+
+// As returns a DeckAPI for the same decks that records actor, such as the
+// player or API key making a request, in the events of the changes made
+// through it.
+func (da *DeckAPI) As(actor string) *DeckAPI {
+	return &DeckAPI{state: da.state, actor: actor, ctx: da.ctx}
+}
+
+// WithContext returns a DeckAPI for the same decks and actor that passes ctx
+// on to the store, such as the context of the request it serves.
+func (da *DeckAPI) WithContext(ctx context.Context) *DeckAPI {
+	return &DeckAPI{state: da.state, actor: da.actor, ctx: ctx}
 }
 
-func (da *DeckAPI) New(shuffle bool, cards []Card) *Deck {
+func (da *DeckAPI) New(shuffle bool, cards []CardID) (*Deck, error) {
+	return da.NewOwned("", shuffle, cards)
+}
+
+// NewOwned creates a deck that belongs to owner, such as the ID of the API key
+// that created it.
+func (da *DeckAPI) NewOwned(owner string, shuffle bool, cards []CardID) (_ *Deck, err error) {
 	if cards == nil {
 		cards = getSortedCards()
 	}
@@ -218,44 +144,114 @@ func (da *DeckAPI) New(shuffle bool, cards []Card) *Deck {
 		})
 	}
 
-	d := &Deck{
-		DeckID:    uuid.New(),
-		Shuffled:  shuffle,
-		Remaining: len(cards),
-		Cards:     cards,
+	da, span := da.startSpan("New", attrCards.Int(len(cards)))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return nil, err
 	}
 
-	da.store.Create(*d)
+	d, err := da.record(Event{
+		Type:     EventDeckCreated,
+		DeckID:   uuid.New(),
+		Shuffled: shuffle,
+		Cards:    cards,
+		Owner:    owner,
+	}, Deck{})
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(deckIDAttr(d.DeckID))
 
-	return d
+	return &d, nil
 }
 
-func (da *DeckAPI) Get(u uuid.UUID) (*Deck, error) {
-	d, err := da.store.QueryById(u)
+func (da *DeckAPI) Get(u uuid.UUID) (_ *Deck, err error) {
+	da, span := da.startSpan("Get", deckIDAttr(u))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.RLock()
+	defer da.mu.RUnlock()
+
+	d, err := da.store.QueryById(da.ctx, u)
 	if err != nil {
 		return nil, ErrDeckNotFound
 	}
 	return &d, nil
 }
 
-func (da *DeckAPI) Draw(u uuid.UUID, n int) ([]Card, error) {
+func (da *DeckAPI) Draw(u uuid.UUID, n int) (_ []CardID, err error) {
+	da, span := da.startSpan("Draw", deckIDAttr(u), attrCards.Int(n))
+	defer func() { endSpan(span, err) }()
+
 	da.mu.Lock()
 	defer da.mu.Unlock()
 
-	d, err := da.store.QueryById(u)
+	err = da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
 	if err != nil {
 		return nil, ErrDeckNotFound
 	}
 
 	if n > d.Remaining {
-		return nil, ErrUnsufficientCards
+		return nil, da.stats.insufficient(&InsufficientCardsError{Remaining: d.Remaining, Requested: n})
 	}
 
-	var drawn []Card
+	var drawn []CardID
 	drawn = append(drawn, d.Cards[0:n]...)
-	d.Cards = slices.Delete(d.Cards, 0, n)
-	d.Remaining = len(d.Cards)
-	updatedDeck := Deck{DeckID: d.DeckID, Cards: d.Cards, Remaining: len(d.Cards)}
-	da.store.Update(u, updatedDeck)
+	_, err = da.record(Event{
+		Type:   EventCardsDrawn,
+		DeckID: u,
+		Count:  n,
+		Cards:  drawn,
+	}, d)
+	if err != nil {
+		return nil, err
+	}
 	return drawn, nil
 }
+
+// Shuffle shuffles the cards remaining in a deck.
+func (da *DeckAPI) Shuffle(u uuid.UUID) (_ *Deck, err error) {
+	da, span := da.startSpan("Shuffle", deckIDAttr(u))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
+	if err != nil {
+		return nil, ErrDeckNotFound
+	}
+
+	cards := slices.Clone(d.Cards)
+	rand.Shuffle(len(cards), func(i, j int) {
+		cards[i], cards[j] = cards[j], cards[i]
+	})
+	d, err = da.record(Event{
+		Type:   EventDeckShuffled,
+		DeckID: u,
+		Cards:  cards,
+	}, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (da *DeckAPI) Usage() Usage {
+	return da.store.Usage()
+}
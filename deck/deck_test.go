@@ -9,7 +9,8 @@ import (
 
 func TestNew(t *testing.T) {
 	// Test sorted deck creation.
-	da := NewAPI(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := NewAPI(log, NewStore(log, Limits{}))
 
 	sortedCards := []Card{
 		// Clubs
@@ -137,66 +138,72 @@ func TestNew(t *testing.T) {
 
 		// Spades
 		{
-			Code: "2H",
+			Code: "2S",
 		},
 		{
-			Code: "3H",
+			Code: "3S",
 		},
 		{
-			Code: "4H",
+			Code: "4S",
 		},
 		{
-			Code: "5H",
+			Code: "5S",
 		},
 		{
-			Code: "6H",
+			Code: "6S",
 		},
 		{
-			Code: "7H",
+			Code: "7S",
 		},
 		{
-			Code: "8H",
+			Code: "8S",
 		},
 		{
-			Code: "9H",
+			Code: "9S",
 		},
 		{
-			Code: "10H",
+			Code: "10S",
 		},
 		{
-			Code: "JH",
+			Code: "JS",
 		},
 		{
-			Code: "QH",
+			Code: "QS",
 		},
 		{
-			Code: "KH",
+			Code: "KS",
 		},
 		{
-			Code: "AH",
+			Code: "AS",
 		},
 	}
 
 	sortedDeck := Deck{
 		Shuffled:  false,
 		Remaining: 52,
-		Cards:     sortedCards,
+		Cards:     getSortedCards(),
 	}
 
-	d := da.New(false, nil)
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
 	if d.Shuffled != sortedDeck.Shuffled {
 		t.Fatalf("Expected deck.Shuffled to be false, it is not")
 	}
-	if !reflect.DeepEqual(d.Cards, sortedCards) {
+	if !reflect.DeepEqual(Expand(d.Cards), sortedCards) {
 		t.Fatalf("Deck cards are not sorted, got %v, want %v", d.Cards, sortedCards)
 	}
 
 	// Test shuffled deck creation.
-	d = da.New(true, nil)
+	d, err = da.New(true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
 	if d.Shuffled == sortedDeck.Shuffled {
 		t.Fatalf("Expected deck.Shuffled to be true, it is not")
 	}
-	if reflect.DeepEqual(d.Cards, sortedCards) {
+	if reflect.DeepEqual(Expand(d.Cards), sortedCards) {
 		t.Fatalf("Deck cards are not shuffled, got %v", d.Cards)
 	}
 
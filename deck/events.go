@@ -0,0 +1,312 @@
+package deck
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrEventsDisabled error = errors.New("Event sourcing is not enabled")
+var ErrOutOfOrder error = errors.New("Event is out of order")
+
+type EventType string
+
+const (
+	EventDeckCreated   EventType = "deck_created"
+	EventCardsDrawn    EventType = "cards_drawn"
+	EventDeckImported  EventType = "deck_imported"
+	EventDeckShuffled  EventType = "deck_shuffled"
+	EventCardsDealt    EventType = "cards_dealt"
+	EventCardPlayed    EventType = "card_played"
+	EventCardsReturned EventType = "cards_returned"
+	EventCardFlipped   EventType = "card_flipped"
+	EventPileFlipped   EventType = "pile_flipped"
+	EventHandRevealed  EventType = "hand_revealed"
+)
+
+// Event is an immutable record of a change made to a deck. Folding every
+// event of a deck, in order, yields its current state.
+type Event struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	DeckID   uuid.UUID `json:"deck_id"`
+	Shuffled bool      `json:"shuffled,omitempty"`
+	Count    int       `json:"count,omitempty"`
+	// Cards holds the whole deck for created and imported events, the drawn
+	// cards for draw events, the new order of the remaining cards for
+	// shuffle events, the cards that changed place for deal, play and
+	// return events, and the cards turned over for flip and reveal events,
+	// in the order they were in.
+	Cards   []CardID `json:"cards,omitempty"`
+	Players []string `json:"players,omitempty"`
+	Player  string   `json:"player,omitempty"`
+	Pile    string   `json:"pile,omitempty"`
+	// Hands, Piles and Flipped hold the hands, piles and flipped cards of
+	// imported decks.
+	Hands   map[string][]CardID `json:"hands,omitempty"`
+	Piles   map[string][]CardID `json:"piles,omitempty"`
+	Flipped []CardID            `json:"flipped,omitempty"`
+	// Owner is the owner of created and imported decks.
+	Owner string `json:"owner,omitempty"`
+	// Actor is who made the change, such as the player or API key of the
+	// request, if known.
+	Actor string `json:"actor,omitempty"`
+}
+
+// Apply returns the state of the deck after the event happened.
+func (e Event) Apply(d Deck) Deck {
+	switch e.Type {
+	case EventDeckCreated, EventDeckImported:
+		return Deck{
+			DeckID:    e.DeckID,
+			Shuffled:  e.Shuffled,
+			Remaining: len(e.Cards),
+			Cards:     slices.Clone(e.Cards),
+			Hands:     cloneCards(e.Hands),
+			Piles:     cloneCards(e.Piles),
+			Flipped:   slices.Clone(e.Flipped),
+			Owner:     e.Owner,
+		}
+	case EventCardsDrawn:
+		d.Cards = slices.Clone(d.Cards[min(e.Count, len(d.Cards)):])
+		d.Remaining = len(d.Cards)
+	case EventDeckShuffled:
+		d.Shuffled = true
+		d.Cards = slices.Clone(e.Cards)
+		d.Remaining = len(d.Cards)
+	case EventCardsDealt:
+		d = d.deal(e)
+	case EventCardPlayed:
+		d = d.play(e)
+	case EventCardsReturned:
+		d = d.returnCards(e)
+	case EventCardFlipped, EventPileFlipped, EventHandRevealed:
+		d = d.flip(e)
+	}
+	return d
+}
+
+// Fold rebuilds a deck from its events. It returns false if there are no
+// events to fold.
+func Fold(events []Event) (Deck, bool) {
+	var d Deck
+	for _, e := range events {
+		d = e.Apply(d)
+	}
+	return d, len(events) > 0
+}
+
+// EventLog is an append-only log of every deck event.
+type EventLog interface {
+	// Append assigns the next sequence number and a timestamp to the event
+	// and stores it.
+	Append(e Event) (Event, error)
+	// Events returns every event of a deck, in sequence order.
+	Events(u uuid.UUID) ([]Event, error)
+	// Since returns every event with a sequence number greater than seq.
+	Since(seq uint64) ([]Event, error)
+	// Head returns the sequence number of the last event, 0 if there's none.
+	Head() uint64
+	// Replicate stores an event that already has a sequence number, which
+	// must directly follow Head.
+	Replicate(e Event) error
+}
+
+type MemoryEventLog struct {
+	events []Event
+	byDeck map[uuid.UUID][]int
+	mu     sync.RWMutex
+}
+
+func NewMemoryEventLog() *MemoryEventLog {
+	return &MemoryEventLog{
+		byDeck: make(map[uuid.UUID][]int),
+	}
+}
+
+func (el *MemoryEventLog) Append(e Event) (Event, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	e.Seq = uint64(len(el.events)) + 1
+	e.Time = time.Now().UTC()
+	e.Cards = slices.Clone(e.Cards)
+	e.Hands = cloneCards(e.Hands)
+	e.Piles = cloneCards(e.Piles)
+	e.Flipped = slices.Clone(e.Flipped)
+	el.byDeck[e.DeckID] = append(el.byDeck[e.DeckID], len(el.events))
+	el.events = append(el.events, e)
+	return e, nil
+}
+
+func (el *MemoryEventLog) Replicate(e Event) error {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	next := uint64(len(el.events)) + 1
+	if e.Seq != next {
+		return fmt.Errorf("%w: got %d, want %d", ErrOutOfOrder, e.Seq, next)
+	}
+	e.Cards = slices.Clone(e.Cards)
+	e.Hands = cloneCards(e.Hands)
+	e.Piles = cloneCards(e.Piles)
+	e.Flipped = slices.Clone(e.Flipped)
+	el.byDeck[e.DeckID] = append(el.byDeck[e.DeckID], len(el.events))
+	el.events = append(el.events, e)
+	return nil
+}
+
+func (el *MemoryEventLog) Head() uint64 {
+	el.mu.RLock()
+	defer el.mu.RUnlock()
+	return uint64(len(el.events))
+}
+
+func (el *MemoryEventLog) Events(u uuid.UUID) ([]Event, error) {
+	el.mu.RLock()
+	defer el.mu.RUnlock()
+	idx := el.byDeck[u]
+	if len(idx) == 0 {
+		return nil, ErrDeckNotFound
+	}
+	events := make([]Event, 0, len(idx))
+	for _, i := range idx {
+		events = append(events, el.events[i])
+	}
+	return events, nil
+}
+
+func (el *MemoryEventLog) Since(seq uint64) ([]Event, error) {
+	el.mu.RLock()
+	defer el.mu.RUnlock()
+	if seq >= uint64(len(el.events)) {
+		return nil, nil
+	}
+	return slices.Clone(el.events[seq:]), nil
+}
+
+// History returns every event of a deck, proving how it reached its state.
+func (da *DeckAPI) History(u uuid.UUID) (_ []Event, err error) {
+	da, span := da.startSpan("History", deckIDAttr(u))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.RLock()
+	defer da.mu.RUnlock()
+
+	if da.events == nil {
+		return nil, ErrEventsDisabled
+	}
+	return da.events.Events(u)
+}
+
+// GetAt rebuilds a deck as it was right after the event with sequence number
+// seq was recorded.
+func (da *DeckAPI) GetAt(u uuid.UUID, seq uint64) (_ *Deck, err error) {
+	da, span := da.startSpan("GetAt", deckIDAttr(u))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.RLock()
+	defer da.mu.RUnlock()
+
+	if da.events == nil {
+		return nil, ErrEventsDisabled
+	}
+	events, err := da.events.Events(u)
+	if err != nil {
+		return nil, err
+	}
+	i, _ := slices.BinarySearchFunc(events, seq+1, func(e Event, seq uint64) int {
+		return cmp.Compare(e.Seq, seq)
+	})
+	d, ok := Fold(events[:i])
+	if !ok {
+		return nil, ErrDeckNotFound
+	}
+	return &d, nil
+}
+
+// Rebuild replays the whole event log into the store, replacing the current
+// projection of every deck that has events.
+func (da *DeckAPI) Rebuild() error {
+	if da.events == nil {
+		return ErrEventsDisabled
+	}
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	events, err := da.events.Since(0)
+	if err != nil {
+		return err
+	}
+	decks := make(map[uuid.UUID]Deck)
+	var order []uuid.UUID
+	for _, e := range events {
+		d, ok := decks[e.DeckID]
+		if !ok {
+			order = append(order, e.DeckID)
+		}
+		decks[e.DeckID] = e.Apply(d)
+	}
+	for _, u := range order {
+		err := da.put(decks[u])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record applies an event to the deck it refers to, stores the resulting
+// snapshot and, when event sourcing is enabled, appends the event to the log.
+// Subscribers of the deck get the event last. It must be called with da.mu
+// held.
+func (da *DeckAPI) record(e Event, current Deck) (Deck, error) {
+	if e.Actor == "" {
+		e.Actor = da.actor
+	}
+	d := e.Apply(current)
+
+	var err error
+	switch e.Type {
+	case EventDeckCreated:
+		err = da.store.Create(da.ctx, d)
+	case EventDeckImported:
+		err = da.put(d)
+	default:
+		err = da.store.Update(da.ctx, d.DeckID, d)
+	}
+	if err != nil {
+		return Deck{}, err
+	}
+
+	if da.events != nil {
+		e, err = da.events.Append(e)
+		if err != nil {
+			return Deck{}, err
+		}
+	} else {
+		e.Time = time.Now().UTC()
+	}
+	if !da.batch {
+		da.stats.count(e)
+	}
+	da.hub.publish(e)
+	return d, nil
+}
+
+// put creates the deck, or overwrites it if it already exists.
+func (da *DeckAPI) put(d Deck) error {
+	_, err := da.store.QueryById(da.ctx, d.DeckID)
+	switch {
+	case errors.Is(err, ErrDeckNotFound):
+		return da.store.Create(da.ctx, d)
+	case err != nil:
+		return err
+	}
+	return da.store.Update(da.ctx, d.DeckID, d)
+}
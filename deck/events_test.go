@@ -0,0 +1,151 @@
+package deck
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	// Test every operation is recorded and decks can be rebuilt at any point.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	el := NewMemoryEventLog()
+	da := NewAPI(log, NewStore(log, Limits{}), WithEventLog(el))
+
+	d, err := da.New(true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = da.Draw(d.DeckID, 2)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	_, err = da.Draw(d.DeckID, 3)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+
+	events, err := da.History(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	types := []EventType{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	expectedTypes := []EventType{EventDeckCreated, EventCardsDrawn, EventCardsDrawn}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Fatalf("Expected events %v, got %v", expectedTypes, types)
+	}
+
+	at, err := da.GetAt(d.DeckID, events[1].Seq)
+	if err != nil {
+		t.Fatalf("Failed to get deck at %d: %v", events[1].Seq, err)
+	}
+	if at.Remaining != 50 || !reflect.DeepEqual(at.Cards, d.Cards[2:]) {
+		t.Errorf("Expected 50 cards remaining in the original order, got %v", at)
+	}
+
+	current, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	at, err = da.GetAt(d.DeckID, events[2].Seq)
+	if err != nil {
+		t.Fatalf("Failed to get deck at %d: %v", events[2].Seq, err)
+	}
+	if !reflect.DeepEqual(at, current) {
+		t.Errorf("Expected folded deck %v to match the projection %v", at, current)
+	}
+	if !current.Shuffled {
+		t.Errorf("Expected deck to stay shuffled after drawing")
+	}
+
+	// Test shuffling keeps the remaining cards and is recorded.
+	shuffled, err := da.Shuffle(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to shuffle deck: %v", err)
+	}
+	if shuffled.Remaining != current.Remaining || !reflect.DeepEqual(sorted(shuffled.Cards), sorted(current.Cards)) {
+		t.Errorf("Expected the same %d cards after shuffling, got %v", current.Remaining, shuffled.Cards)
+	}
+	events, err = da.History(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if last := events[len(events)-1]; last.Type != EventDeckShuffled {
+		t.Errorf("Expected the last event to be %v, got %v", EventDeckShuffled, last.Type)
+	}
+	folded, _ := Fold(events)
+	if !reflect.DeepEqual(&folded, shuffled) {
+		t.Errorf("Expected folded deck %v to match the shuffled deck %v", folded, shuffled)
+	}
+	current = shuffled
+
+	// Test a deck doesn't exist before its creation event.
+	other, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = da.GetAt(other.DeckID, events[0].Seq)
+	if !errors.Is(err, ErrDeckNotFound) {
+		t.Errorf("Expected %v, got %v", ErrDeckNotFound, err)
+	}
+
+	// Test the projection is rebuilt from the log into an empty store.
+	rebuilt := NewAPI(log, NewStore(log, Limits{}), WithEventLog(el))
+	err = rebuilt.Rebuild()
+	if err != nil {
+		t.Fatalf("Failed to rebuild: %v", err)
+	}
+	r, err := rebuilt.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Deck missing after rebuild: %v", err)
+	}
+	if !reflect.DeepEqual(r, current) {
+		t.Errorf("Expected rebuilt deck %v, got %v", current, r)
+	}
+
+	// Test owners are recorded and survive a rebuild.
+	owned, err := da.NewOwned("lobby", false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	err = rebuilt.Rebuild()
+	if err != nil {
+		t.Fatalf("Failed to rebuild: %v", err)
+	}
+	r, err = rebuilt.Get(owned.DeckID)
+	if err != nil || r.Owner != "lobby" {
+		t.Errorf("Expected the rebuilt deck to belong to lobby, got %v, %v", r, err)
+	}
+
+	// Test events record who made the change.
+	_, err = da.As("player bob").Draw(owned.DeckID, 1)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	events, err = da.History(owned.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if events[0].Actor != "" || events[1].Actor != "player bob" {
+		t.Errorf("Expected only the draw to be made by player bob, got %v", events)
+	}
+
+	// Test point in time reads need the event log.
+	da = NewAPI(log, NewStore(log, Limits{}))
+	_, err = da.GetAt(d.DeckID, 1)
+	if !errors.Is(err, ErrEventsDisabled) {
+		t.Errorf("Expected %v, got %v", ErrEventsDisabled, err)
+	}
+}
+
+func sorted(cards []CardID) []CardID {
+	cards = slices.Clone(cards)
+	slices.Sort(cards)
+	return cards
+}
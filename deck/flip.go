@@ -0,0 +1,254 @@
+package deck
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var ErrCardNotInPlay error = errors.New("Card isn't in a hand or pile")
+var ErrPileNotFound error = errors.New("Pile not found")
+var ErrHandNotFound error = errors.New("Player has no cards in hand")
+
+// Orientation is which way up a card in a hand or pile lies.
+type Orientation string
+
+const (
+	FaceDown Orientation = "face_down"
+	FaceUp   Orientation = "face_up"
+)
+
+// Orientation returns which way up card lies in d: cards are dealt into hands
+// face down and played onto piles face up, until they're flipped.
+func (d Deck) Orientation(card CardID) Orientation {
+	flipped := slices.Contains(d.Flipped, card)
+	for _, cards := range d.Hands {
+		if slices.Contains(cards, card) {
+			if flipped {
+				return FaceUp
+			}
+			return FaceDown
+		}
+	}
+	if flipped {
+		return FaceDown
+	}
+	return FaceUp
+}
+
+// Holder returns the player holding card in their hand, if anyone.
+func (d Deck) Holder(card CardID) (string, bool) {
+	for player, cards := range d.Hands {
+		if slices.Contains(cards, card) {
+			return player, true
+		}
+	}
+	return "", false
+}
+
+// pileOf returns the pile card lies in, if any.
+func (d Deck) pileOf(card CardID) (string, bool) {
+	for name, cards := range d.Piles {
+		if slices.Contains(cards, card) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// FlipCard turns over a card in a hand or pile.
+func (da *DeckAPI) FlipCard(u uuid.UUID, card CardID) (_ *Deck, err error) {
+	da, span := da.startSpan("FlipCard", deckIDAttr(u), attrCards.Int(1))
+	defer func() { endSpan(span, err) }()
+
+	return da.flipCard(u, func(d Deck) (CardID, error) {
+		return card, nil
+	})
+}
+
+// FlipCardAs turns over a card for player, who can name the cards in their
+// own hand and the cards lying face up in piles. Any other card fails with
+// ErrCardNotInHand, wherever it is, so players can't find out where the
+// cards they can't see are; face down cards in piles are flipped with
+// FlipTop. The card is checked under the same lock as the flip, so it can't
+// move in between.
+func (da *DeckAPI) FlipCardAs(u uuid.UUID, player string, card CardID) (_ *Deck, err error) {
+	da, span := da.startSpan("FlipCard", deckIDAttr(u), attrCards.Int(1))
+	defer func() { endSpan(span, err) }()
+
+	return da.flipCard(u, func(d Deck) (CardID, error) {
+		if holder, ok := d.Holder(card); ok && holder == player {
+			return card, nil
+		}
+		if _, ok := d.pileOf(card); ok && d.Orientation(card) == FaceUp {
+			return card, nil
+		}
+		return 0, ErrCardNotInHand
+	})
+}
+
+// FlipTop turns over the card on top of a pile, which players can't name if
+// it lies face down.
+func (da *DeckAPI) FlipTop(u uuid.UUID, pile string) (_ *Deck, err error) {
+	da, span := da.startSpan("FlipTop", deckIDAttr(u), attrCards.Int(1))
+	defer func() { endSpan(span, err) }()
+
+	return da.flipCard(u, func(d Deck) (CardID, error) {
+		cards := d.Piles[pile]
+		if len(cards) == 0 {
+			return 0, fmt.Errorf("%w: %q", ErrPileNotFound, pile)
+		}
+		return cards[len(cards)-1], nil
+	})
+}
+
+// flipCard turns over the card pick picks out of the deck.
+func (da *DeckAPI) flipCard(u uuid.UUID, pick func(d Deck) (CardID, error)) (*Deck, error) {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err := da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
+	if err != nil {
+		return nil, ErrDeckNotFound
+	}
+
+	card, err := pick(d)
+	if err != nil {
+		return nil, err
+	}
+	e := Event{Type: EventCardFlipped, DeckID: u, Cards: []CardID{card}}
+	if player, ok := d.Holder(card); ok {
+		e.Player = player
+	} else if pile, ok := d.pileOf(card); ok {
+		e.Pile = pile
+	} else {
+		return nil, ErrCardNotInPlay
+	}
+
+	d, err = da.record(e, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// FlipPile turns a whole pile over, like flipping the waste pile in
+// solitaire: the bottom card ends up on top, and every card the other way up.
+func (da *DeckAPI) FlipPile(u uuid.UUID, pile string) (_ *Deck, err error) {
+	da, span := da.startSpan("FlipPile", deckIDAttr(u))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
+	if err != nil {
+		return nil, ErrDeckNotFound
+	}
+
+	cards, ok := d.Piles[pile]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrPileNotFound, pile)
+	}
+
+	d, err = da.record(Event{
+		Type:   EventPileFlipped,
+		DeckID: u,
+		Pile:   pile,
+		Cards:  slices.Clone(cards),
+	}, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RevealHand turns every card in a player's hand face up, for everyone to
+// see.
+func (da *DeckAPI) RevealHand(u uuid.UUID, player string) (_ *Deck, err error) {
+	da, span := da.startSpan("RevealHand", deckIDAttr(u))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
+	if err != nil {
+		return nil, ErrDeckNotFound
+	}
+
+	cards, ok := d.Hands[player]
+	if !ok {
+		return nil, ErrHandNotFound
+	}
+
+	d, err = da.record(Event{
+		Type:   EventHandRevealed,
+		DeckID: u,
+		Player: player,
+		Cards:  slices.Clone(cards),
+	}, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// flip applies a flipped or revealed event, copying what changes like deal.
+func (d Deck) flip(e Event) Deck {
+	flipped := slices.Clone(d.Flipped)
+	for _, c := range e.Cards {
+		i := slices.Index(flipped, c)
+		switch {
+		case i >= 0 && e.Type != EventHandRevealed:
+			flipped = slices.Delete(flipped, i, i+1)
+		case i < 0:
+			flipped = append(flipped, c)
+		}
+	}
+	if len(flipped) == 0 {
+		flipped = nil
+	}
+	d.Flipped = flipped
+
+	if e.Type == EventPileFlipped {
+		pile := slices.Clone(d.Piles[e.Pile])
+		slices.Reverse(pile)
+		d.Piles = maps.Clone(d.Piles)
+		d.Piles[e.Pile] = pile
+	}
+	return d
+}
+
+// unflip returns flipped without the given cards, which left the hand or
+// pile they were flipped in.
+func unflip(flipped, cards []CardID) []CardID {
+	if len(flipped) == 0 {
+		return flipped
+	}
+	flipped = slices.DeleteFunc(slices.Clone(flipped), func(c CardID) bool {
+		return slices.Contains(cards, c)
+	})
+	if len(flipped) == 0 {
+		return nil
+	}
+	return flipped
+}
@@ -0,0 +1,148 @@
+package deck
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestFlip(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := NewAPI(log, NewStore(log, Limits{}), WithEventLog(NewMemoryEventLog()))
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = da.Deal(d.DeckID, []string{"ann", "bob"}, 3)
+	if err != nil {
+		t.Fatalf("Failed to deal: %v", err)
+	}
+	for _, c := range []CardID{1, 3} {
+		_, err = da.Play(d.DeckID, "bob", c, "stock")
+		if err != nil {
+			t.Fatalf("Failed to play: %v", err)
+		}
+	}
+
+	// Test cards are dealt face down and played face up until flipped.
+	flipped, err := da.FlipCard(d.DeckID, 0)
+	if err != nil {
+		t.Fatalf("Failed to flip card: %v", err)
+	}
+	for c, o := range map[CardID]Orientation{0: FaceUp, 2: FaceDown, 1: FaceUp, 5: FaceDown} {
+		if got := flipped.Orientation(c); got != o {
+			t.Errorf("Expected card %d to be %s, got %s", c, o, got)
+		}
+	}
+	flipped, err = da.FlipCard(d.DeckID, 0)
+	if err != nil {
+		t.Fatalf("Failed to flip card: %v", err)
+	}
+	if flipped.Flipped != nil {
+		t.Errorf("Expected the card to be face down again, got %v", flipped.Flipped)
+	}
+	_, err = da.FlipCardAs(d.DeckID, "bob", 0)
+	if !errors.Is(err, ErrCardNotInHand) {
+		t.Errorf("Expected bob not to flip ann's card, got %v", err)
+	}
+
+	// Test players can't tell where the cards they can't name are: in other
+	// hands, in the deck or face down in piles.
+	flipped, err = da.FlipCardAs(d.DeckID, "ann", 3)
+	if err != nil || flipped.Orientation(3) != FaceDown {
+		t.Fatalf("Expected ann to flip the face up card on the stock, got %v", err)
+	}
+	for _, c := range []CardID{0, 51, 3} {
+		_, err = da.FlipCardAs(d.DeckID, "bob", c)
+		if !errors.Is(err, ErrCardNotInHand) {
+			t.Errorf("Expected bob not to flip card %d with %v, got %v", c, ErrCardNotInHand, err)
+		}
+	}
+	_, err = da.FlipTop(d.DeckID, "stock")
+	if err != nil {
+		t.Fatalf("Failed to flip card: %v", err)
+	}
+
+	// Test flipping a pile turns it upside down.
+	flipped, err = da.FlipTop(d.DeckID, "stock")
+	if err != nil {
+		t.Fatalf("Failed to flip card: %v", err)
+	}
+	if flipped.Orientation(3) != FaceDown {
+		t.Errorf("Expected the top of the stock to be face down, got %v", flipped.Flipped)
+	}
+	flipped, err = da.FlipPile(d.DeckID, "stock")
+	if err != nil {
+		t.Fatalf("Failed to flip pile: %v", err)
+	}
+	if !reflect.DeepEqual(flipped.Piles["stock"], []CardID{3, 1}) || !reflect.DeepEqual(flipped.Flipped, []CardID{1}) {
+		t.Errorf("Expected the stock to be [3 1] with 1 face down, got %v with %v flipped", flipped.Piles["stock"], flipped.Flipped)
+	}
+
+	// Test revealing a hand turns all of it face up, and cards leaving a
+	// hand or pile lose their orientation.
+	flipped, err = da.RevealHand(d.DeckID, "ann")
+	if err != nil {
+		t.Fatalf("Failed to reveal hand: %v", err)
+	}
+	if !reflect.DeepEqual(flipped.Flipped, []CardID{1, 0, 2, 4}) {
+		t.Errorf("Expected ann's hand to be face up, got %v", flipped.Flipped)
+	}
+	_, err = da.Play(d.DeckID, "ann", 0, "discard")
+	if err != nil {
+		t.Fatalf("Failed to play: %v", err)
+	}
+	flipped, err = da.Return(d.DeckID, []CardID{1})
+	if err != nil {
+		t.Fatalf("Failed to return: %v", err)
+	}
+	if !reflect.DeepEqual(flipped.Flipped, []CardID{2, 4}) || flipped.Orientation(0) != FaceUp {
+		t.Errorf("Expected only the rest of ann's hand to be flipped, got %v", flipped.Flipped)
+	}
+	if err := flipped.Validate(); err != nil {
+		t.Errorf("Expected a valid deck, got %v", err)
+	}
+
+	// Test flips are recorded.
+	events, err := da.History(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	var types []EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	want := []EventType{EventDeckCreated, EventCardsDealt, EventCardPlayed, EventCardPlayed, EventCardFlipped, EventCardFlipped, EventCardFlipped, EventCardFlipped, EventCardFlipped, EventPileFlipped, EventHandRevealed, EventCardPlayed, EventCardsReturned}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("Expected events %v, got %v", want, types)
+	}
+	if e := events[4]; e.Player != "ann" || !reflect.DeepEqual(e.Cards, []CardID{0}) {
+		t.Errorf("Expected ann's card to be flipped, got %+v", e)
+	}
+
+	// Test invalid flips are rejected.
+	_, err = da.FlipCard(d.DeckID, 51)
+	if !errors.Is(err, ErrCardNotInPlay) {
+		t.Errorf("Expected %v, got %v", ErrCardNotInPlay, err)
+	}
+	_, err = da.FlipPile(d.DeckID, "waste")
+	if !errors.Is(err, ErrPileNotFound) {
+		t.Errorf("Expected %v, got %v", ErrPileNotFound, err)
+	}
+	_, err = da.FlipTop(d.DeckID, "waste")
+	if !errors.Is(err, ErrPileNotFound) {
+		t.Errorf("Expected %v, got %v", ErrPileNotFound, err)
+	}
+	_, err = da.RevealHand(d.DeckID, "cat")
+	if !errors.Is(err, ErrHandNotFound) {
+		t.Errorf("Expected %v, got %v", ErrHandNotFound, err)
+	}
+	invalid := *flipped
+	invalid.Flipped = []CardID{51}
+	if err := invalid.Validate(); !errors.Is(err, ErrInvalidDeck) {
+		t.Errorf("Expected %v, got %v", ErrInvalidDeck, err)
+	}
+}
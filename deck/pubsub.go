@@ -0,0 +1,66 @@
+package deck
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// subscriptionBuffer is how many events a subscriber may fall behind before
+// it's dropped.
+const subscriptionBuffer = 64
+
+// hub fans the events of a deck out to its subscribers.
+type hub struct {
+	subs map[uuid.UUID]map[chan Event]struct{}
+	mu   sync.Mutex
+}
+
+// Subscribe returns a channel that receives every event of a deck recorded or
+// replicated from now on, and a function to stop receiving them. A subscriber
+// that falls too far behind has its channel closed, and should subscribe
+// again and catch up from the event log.
+func (da *DeckAPI) Subscribe(u uuid.UUID) (<-chan Event, func()) {
+	ch := make(chan Event, subscriptionBuffer)
+
+	da.hub.mu.Lock()
+	defer da.hub.mu.Unlock()
+	if da.hub.subs == nil {
+		da.hub.subs = make(map[uuid.UUID]map[chan Event]struct{})
+	}
+	if da.hub.subs[u] == nil {
+		da.hub.subs[u] = make(map[chan Event]struct{})
+	}
+	da.hub.subs[u][ch] = struct{}{}
+
+	return ch, func() {
+		da.hub.mu.Lock()
+		defer da.hub.mu.Unlock()
+		da.hub.remove(u, ch)
+	}
+}
+
+// publish sends an event to the subscribers of its deck without blocking.
+func (h *hub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[e.DeckID] {
+		select {
+		case ch <- e:
+		default:
+			h.remove(e.DeckID, ch)
+		}
+	}
+}
+
+// remove closes a subscriber channel. It must be called with h.mu held.
+func (h *hub) remove(u uuid.UUID, ch chan Event) {
+	if _, ok := h.subs[u][ch]; !ok {
+		return
+	}
+	close(ch)
+	delete(h.subs[u], ch)
+	if len(h.subs[u]) == 0 {
+		delete(h.subs, u)
+	}
+}
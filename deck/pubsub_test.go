@@ -0,0 +1,60 @@
+package deck
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	// Test subscribers get the events of their deck only.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := NewAPI(log, NewStore(log, Limits{}))
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	other, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	events, unsubscribe := da.Subscribe(d.DeckID)
+
+	_, err = da.Draw(other.DeckID, 1)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	_, err = da.Draw(d.DeckID, 2)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	e := <-events
+	if e.Type != EventCardsDrawn || e.DeckID != d.DeckID || e.Count != 2 || e.Time.IsZero() {
+		t.Errorf("Expected a draw of 2 cards from %v, got %v", d.DeckID, e)
+	}
+
+	// Test unsubscribing closes the channel.
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Errorf("Expected the channel to be closed")
+	}
+	unsubscribe()
+
+	// Test subscribers that fall behind are dropped.
+	events, unsubscribe = da.Subscribe(d.DeckID)
+	defer unsubscribe()
+	for range subscriptionBuffer + 1 {
+		_, err = da.Shuffle(d.DeckID)
+		if err != nil {
+			t.Fatalf("Failed to shuffle deck: %v", err)
+		}
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != subscriptionBuffer {
+		t.Errorf("Expected %d buffered events before being dropped, got %d", subscriptionBuffer, n)
+	}
+}
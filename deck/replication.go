@@ -0,0 +1,173 @@
+package deck
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrReadOnly error = errors.New("Server is a read-only follower")
+var ErrNotFollower error = errors.New("Server isn't a follower")
+
+type Role string
+
+const (
+	RoleLeader   Role = "leader"
+	RoleFollower Role = "follower"
+)
+
+// ReplicationStatus describes the replication role of a server and, for
+// followers, how far behind the leader they are.
+type ReplicationStatus struct {
+	Role        Role       `json:"role"`
+	Leader      string     `json:"leader,omitempty"`
+	AppliedSeq  uint64     `json:"applied_seq"`
+	LeaderSeq   uint64     `json:"leader_seq,omitempty"`
+	LagEvents   uint64     `json:"lag_events"`
+	LagSeconds  float64    `json:"lag_seconds"`
+	LastContact *time.Time `json:"last_contact,omitempty"`
+}
+
+type replication struct {
+	role        Role
+	leader      string
+	leaderSeq   uint64
+	lastContact time.Time
+	lastApplied time.Time
+}
+
+// Follow turns the API into a read-only follower of the leader at the given
+// URL. Every mutation fails with ErrReadOnly until Promote is called.
+func (da *DeckAPI) Follow(leader string) error {
+	if da.events == nil {
+		return ErrEventsDisabled
+	}
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	da.repl = replication{
+		role:   RoleFollower,
+		leader: leader,
+	}
+	return nil
+}
+
+// Promote turns a follower into a leader that accepts mutations again.
+func (da *DeckAPI) Promote() error {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	if da.repl.role != RoleFollower {
+		return ErrNotFollower
+	}
+	da.log.InfoContext(da.ctx, "replication", "promote", "finished", "leader", da.repl.leader)
+	da.repl = replication{role: RoleLeader}
+	return nil
+}
+
+// Following reports whether the API is currently a follower.
+func (da *DeckAPI) Following() bool {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	return da.repl.role == RoleFollower
+}
+
+// Head returns the sequence number of the last recorded event.
+func (da *DeckAPI) Head() (uint64, error) {
+	if da.events == nil {
+		return 0, ErrEventsDisabled
+	}
+	return da.events.Head(), nil
+}
+
+// EventsSince returns every event recorded after seq, to be streamed to
+// followers.
+func (da *DeckAPI) EventsSince(seq uint64) ([]Event, error) {
+	if da.events == nil {
+		return nil, ErrEventsDisabled
+	}
+	return da.events.Since(seq)
+}
+
+// ObserveLeader records the latest sequence number the leader reported.
+func (da *DeckAPI) ObserveLeader(seq uint64) {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	da.repl.leaderSeq = seq
+	da.repl.lastContact = time.Now()
+}
+
+// Replicate applies an event streamed from the leader, keeping its sequence
+// number so that point in time reads return the same decks on every server.
+func (da *DeckAPI) Replicate(e Event) error {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	if da.repl.role != RoleFollower {
+		return ErrNotFollower
+	}
+
+	current, err := da.store.QueryById(da.ctx, e.DeckID)
+	if errors.Is(err, ErrDeckNotFound) && e.Type != EventDeckCreated && e.Type != EventDeckImported {
+		// The store may have evicted the deck, the log still knows it.
+		events, err := da.events.Events(e.DeckID)
+		if err != nil {
+			return fmt.Errorf("replicate event %d: %w", e.Seq, err)
+		}
+		current, _ = Fold(events)
+	} else if err != nil && !errors.Is(err, ErrDeckNotFound) {
+		return err
+	}
+
+	err = da.events.Replicate(e)
+	if err != nil {
+		return fmt.Errorf("replicate event %d: %w", e.Seq, err)
+	}
+	err = da.put(e.Apply(current))
+	if err != nil {
+		return fmt.Errorf("replicate event %d: %w", e.Seq, err)
+	}
+	da.repl.lastApplied = e.Time
+	da.hub.publish(e)
+	return nil
+}
+
+func (da *DeckAPI) ReplicationStatus() ReplicationStatus {
+	var applied uint64
+	if da.events != nil {
+		applied = da.events.Head()
+	}
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	s := ReplicationStatus{
+		Role:       RoleLeader,
+		AppliedSeq: applied,
+	}
+	if da.repl.role != RoleFollower {
+		return s
+	}
+
+	s.Role = RoleFollower
+	s.Leader = da.repl.leader
+	s.LeaderSeq = da.repl.leaderSeq
+	if !da.repl.lastContact.IsZero() {
+		lastContact := da.repl.lastContact
+		s.LastContact = &lastContact
+	}
+	if s.LeaderSeq > applied {
+		s.LagEvents = s.LeaderSeq - applied
+		if !da.repl.lastApplied.IsZero() {
+			s.LagSeconds = time.Since(da.repl.lastApplied).Seconds()
+		}
+	}
+	return s
+}
+
+// writable fails with ErrReadOnly on followers. It must be called with da.mu
+// held.
+func (da *DeckAPI) writable() error {
+	if da.repl.role == RoleFollower {
+		return ErrReadOnly
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package deck
+
+import "sync/atomic"
+
+// Stats counts what the decks went through since the DeckAPI was created.
+type Stats struct {
+	DecksCreated uint64
+	// CardsDrawn counts the cards drawn or dealt from decks.
+	CardsDrawn uint64
+	// InsufficientCards counts the draws and deals refused for asking for
+	// more cards than a deck had left.
+	InsufficientCards uint64
+}
+
+// stats holds the counters of Stats.
+type stats struct {
+	decksCreated      atomic.Uint64
+	cardsDrawn        atomic.Uint64
+	insufficientCards atomic.Uint64
+}
+
+// count counts a recorded event.
+func (s *stats) count(e Event) {
+	switch e.Type {
+	case EventDeckCreated:
+		s.decksCreated.Add(1)
+	case EventCardsDrawn, EventCardsDealt:
+		s.cardsDrawn.Add(uint64(len(e.Cards)))
+	}
+}
+
+// insufficient counts err, and returns it.
+func (s *stats) insufficient(err *InsufficientCardsError) error {
+	s.insufficientCards.Add(1)
+	return err
+}
+
+// Stats returns the counters of the decks, for metrics.
+func (da *DeckAPI) Stats() Stats {
+	return Stats{
+		DecksCreated:      da.stats.decksCreated.Load(),
+		CardsDrawn:        da.stats.cardsDrawn.Load(),
+		InsufficientCards: da.stats.insufficientCards.Load(),
+	}
+}
@@ -1,53 +1,264 @@
 package deck
 
 import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"sync"
+	"unsafe"
 
 	"github.com/google/uuid"
 )
 
-type store map[uuid.UUID]*Deck
+var ErrStoreFull error = errors.New("Store is full")
+var ErrDeckTooLarge error = errors.New("Deck is larger than the store capacity")
+
+// EvictionPolicy decides what the store does when creating a deck would
+// exceed its limits.
+type EvictionPolicy string
+
+const (
+	// PolicyEvict drops the least recently used decks until the new one fits.
+	PolicyEvict EvictionPolicy = "evict"
+	// PolicyReject refuses to create the deck and returns ErrStoreFull.
+	PolicyReject EvictionPolicy = "reject"
+)
+
+// Limits bounds the store size. A zero value on any of the maximums means
+// that dimension is unbounded.
+type Limits struct {
+	MaxDecks int
+	MaxCards int
+	Policy   EvictionPolicy
+}
+
+// Usage is a snapshot of how much of the store is in use.
+type Usage struct {
+	Decks    int   `json:"decks"`
+	Cards    int   `json:"cards"`
+	Bytes    int64 `json:"bytes"`
+	MaxDecks int   `json:"max_decks"`
+	MaxCards int   `json:"max_cards"`
+	Evicted  int64 `json:"evicted"`
+}
+
+// Store persists decks. DeckStore is the in-memory implementation; any
+// other backend has to provide the same semantics, namely returning
+// ErrDeckNotFound for unknown decks.
+type Store interface {
+	// Create, QueryById, Update and Delete log with ctx, which carries the
+	// request ID of the request they serve, if any.
+	Create(ctx context.Context, d Deck) error
+	QueryById(ctx context.Context, u uuid.UUID) (Deck, error)
+	Update(ctx context.Context, u uuid.UUID, update Deck) error
+	Delete(ctx context.Context, u uuid.UUID) error
+	// Range calls fn for every deck in the store until fn returns false.
+	Range(fn func(d Deck) bool)
+	Usage() Usage
+}
+
+type store map[uuid.UUID]*list.Element
 
 type DeckStore struct {
-	log   *slog.Logger
-	store store
-	mu    sync.Mutex
+	log     *slog.Logger
+	store   store
+	lru     *list.List
+	limits  Limits
+	cards   int
+	bytes   int64
+	evicted int64
+	mu      sync.Mutex
 }
 
-func NewStore(log *slog.Logger) *DeckStore {
+func NewStore(log *slog.Logger, limits Limits) *DeckStore {
+	if limits.Policy == "" {
+		limits.Policy = PolicyEvict
+	}
 	ds := DeckStore{
-		log:   log,
-		store: make(store, 1),
+		log:    log,
+		store:  make(store, 1),
+		lru:    list.New(),
+		limits: limits,
 	}
 	return &ds
 }
 
-func (ds *DeckStore) Create(d Deck) {
-	ds.log.Info("store", "create", "started", "deckID", d.DeckID)
+// ParsePolicy validates an eviction policy name coming from configuration.
+func ParsePolicy(s string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(s); p {
+	case PolicyEvict, PolicyReject:
+		return p, nil
+	case "":
+		return PolicyEvict, nil
+	}
+	return "", fmt.Errorf("unknown eviction policy %q", s)
+}
+
+func (ds *DeckStore) Create(ctx context.Context, d Deck) (err error) {
+	ctx, span := startStoreSpan(ctx, "Create", d.DeckID, attrCards.Int(cardCount(&d)))
+	defer func() { endSpan(span, err) }()
+
+	ds.log.InfoContext(ctx, "store", "create", "started", "deckID", d.DeckID)
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	ds.store[d.DeckID] = &d
-	ds.log.Info("store", "create", "finished", "deckID", d.DeckID)
+
+	if ds.limits.MaxCards > 0 && cardCount(&d) > ds.limits.MaxCards {
+		ds.log.InfoContext(ctx, "store", "create", "too large", "deckID", d.DeckID)
+		return ErrDeckTooLarge
+	}
+
+	for ds.overLimits(1, cardCount(&d)) {
+		if ds.limits.Policy == PolicyReject || ds.lru.Len() == 0 {
+			ds.log.InfoContext(ctx, "store", "create", "rejected", "deckID", d.DeckID)
+			return ErrStoreFull
+		}
+		ds.evictOldest(ctx)
+	}
+
+	ds.store[d.DeckID] = ds.lru.PushFront(&d)
+	ds.account(&d, 1)
+	ds.log.InfoContext(ctx, "store", "create", "finished", "deckID", d.DeckID)
+	return nil
 }
 
-func (ds *DeckStore) QueryById(u uuid.UUID) (Deck, error) {
-	ds.log.Info("store", "query", "started", "deckID", u)
+func (ds *DeckStore) QueryById(ctx context.Context, u uuid.UUID) (_ Deck, err error) {
+	ctx, span := startStoreSpan(ctx, "QueryById", u)
+	defer func() { endSpan(span, err) }()
+
+	ds.log.InfoContext(ctx, "store", "query", "started", "deckID", u)
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	d := ds.store[u]
-	if d == nil {
-		ds.log.Info("store", "query", "not found", "deckID", u)
+	e := ds.store[u]
+	if e == nil {
+		ds.log.InfoContext(ctx, "store", "query", "not found", "deckID", u)
 		return Deck{}, ErrDeckNotFound
 	}
-	ds.log.Info("store", "query", "finished", "deckID", u)
+	ds.lru.MoveToFront(e)
+	d := e.Value.(*Deck)
+	span.SetAttributes(attrCards.Int(cardCount(d)))
+	ds.log.InfoContext(ctx, "store", "query", "finished", "deckID", u)
 	return *d, nil
 }
 
-func (ds *DeckStore) Update(u uuid.UUID, update Deck) {
-	ds.log.Info("store", "update", "started", "deckID", u)
+func (ds *DeckStore) Update(ctx context.Context, u uuid.UUID, update Deck) (err error) {
+	ctx, span := startStoreSpan(ctx, "Update", u, attrCards.Int(cardCount(&update)))
+	defer func() { endSpan(span, err) }()
+
+	ds.log.InfoContext(ctx, "store", "update", "started", "deckID", u)
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	e := ds.store[u]
+	if e == nil {
+		ds.log.InfoContext(ctx, "store", "update", "not found", "deckID", u)
+		return ErrDeckNotFound
+	}
+	ds.account(e.Value.(*Deck), -1)
+	e.Value = &update
+	ds.account(&update, 1)
+	ds.lru.MoveToFront(e)
+	ds.log.InfoContext(ctx, "store", "update", "finished", "deckID", u)
+	return nil
+}
+
+func (ds *DeckStore) Delete(ctx context.Context, u uuid.UUID) (err error) {
+	ctx, span := startStoreSpan(ctx, "Delete", u)
+	defer func() { endSpan(span, err) }()
+
+	ds.log.InfoContext(ctx, "store", "delete", "started", "deckID", u)
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	ds.store[u] = &update
-	ds.log.Info("store", "update", "started", "deckID", u)
+	e := ds.store[u]
+	if e == nil {
+		ds.log.InfoContext(ctx, "store", "delete", "not found", "deckID", u)
+		return ErrDeckNotFound
+	}
+	ds.lru.Remove(e)
+	delete(ds.store, u)
+	ds.account(e.Value.(*Deck), -1)
+	ds.log.InfoContext(ctx, "store", "delete", "finished", "deckID", u)
+	return nil
+}
+
+// Range iterates over a snapshot of the store, from the most to the least
+// recently used deck. The store isn't locked while fn runs, and iterating
+// doesn't change the LRU order.
+func (ds *DeckStore) Range(fn func(d Deck) bool) {
+	ds.mu.Lock()
+	decks := make([]Deck, 0, ds.lru.Len())
+	for e := ds.lru.Front(); e != nil; e = e.Next() {
+		decks = append(decks, *e.Value.(*Deck))
+	}
+	ds.mu.Unlock()
+
+	for _, d := range decks {
+		if !fn(d) {
+			return
+		}
+	}
+}
+
+// Usage reports the current number of decks, cards and the estimated memory
+// held by the store.
+func (ds *DeckStore) Usage() Usage {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return Usage{
+		Decks:    ds.lru.Len(),
+		Cards:    ds.cards,
+		Bytes:    ds.bytes,
+		MaxDecks: ds.limits.MaxDecks,
+		MaxCards: ds.limits.MaxCards,
+		Evicted:  ds.evicted,
+	}
+}
+
+func (ds *DeckStore) overLimits(decks, cards int) bool {
+	if ds.limits.MaxDecks > 0 && ds.lru.Len()+decks > ds.limits.MaxDecks {
+		return true
+	}
+	if ds.limits.MaxCards > 0 && ds.cards+cards > ds.limits.MaxCards {
+		return true
+	}
+	return false
+}
+
+func (ds *DeckStore) evictOldest(ctx context.Context) {
+	e := ds.lru.Back()
+	d := ds.lru.Remove(e).(*Deck)
+	delete(ds.store, d.DeckID)
+	ds.account(d, -1)
+	ds.evicted++
+	ds.log.InfoContext(ctx, "store", "evict", "finished", "deckID", d.DeckID)
+}
+
+// account adds (sign 1) or removes (sign -1) a deck from the usage counters.
+func (ds *DeckStore) account(d *Deck, sign int) {
+	ds.cards += sign * cardCount(d)
+	ds.bytes += int64(sign) * deckSize(d)
+}
+
+// cardCount counts the cards of a deck, wherever they are.
+func cardCount(d *Deck) int {
+	n := len(d.Cards)
+	for _, cards := range d.Hands {
+		n += len(cards)
+	}
+	for _, cards := range d.Piles {
+		n += len(cards)
+	}
+	return n
+}
+
+// deckSize estimates the heap memory retained by a deck, including the
+// backing arrays of its cards, hands, piles and flipped cards.
+func deckSize(d *Deck) int64 {
+	size := int64(unsafe.Sizeof(*d)) + int64(cap(d.Cards)+cap(d.Flipped))*int64(unsafe.Sizeof(CardID(0)))
+	for _, m := range []map[string][]CardID{d.Hands, d.Piles} {
+		for name, cards := range m {
+			size += int64(unsafe.Sizeof(cards)) + int64(len(name)) + int64(cap(cards))*int64(unsafe.Sizeof(CardID(0)))
+		}
+	}
+	return size
 }
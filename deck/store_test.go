@@ -0,0 +1,87 @@
+package deck
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestStoreLimits(t *testing.T) {
+	// Test the least recently used deck is evicted when the store is full.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := NewAPI(log, NewStore(log, Limits{MaxDecks: 2, Policy: PolicyEvict}))
+
+	first, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	second, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	// Touch the first deck so the second one becomes the least recently used.
+	_, err = da.Get(first.DeckID)
+	if err != nil {
+		t.Fatalf("Expected first deck to exist, got %v", err)
+	}
+
+	_, err = da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	_, err = da.Get(second.DeckID)
+	if !errors.Is(err, ErrDeckNotFound) {
+		t.Errorf("Expected second deck to be evicted, got %v", err)
+	}
+	_, err = da.Get(first.DeckID)
+	if err != nil {
+		t.Errorf("Expected first deck to be kept, got %v", err)
+	}
+
+	u := da.Usage()
+	if u.Decks != 2 || u.Cards != 104 || u.Evicted != 1 {
+		t.Errorf("Expected 2 decks, 104 cards and 1 eviction, got %+v", u)
+	}
+	if u.Bytes <= 0 {
+		t.Errorf("Expected usage to account for memory, got %d bytes", u.Bytes)
+	}
+
+	// Test creation is rejected when the policy says so.
+	da = NewAPI(log, NewStore(log, Limits{MaxCards: 100, Policy: PolicyReject}))
+
+	_, err = da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = da.New(false, nil)
+	if !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected %v, got %v", ErrStoreFull, err)
+	}
+
+	// Test a deck bigger than the whole store is never accepted.
+	da = NewAPI(log, NewStore(log, Limits{MaxCards: 10, Policy: PolicyEvict}))
+
+	_, err = da.New(false, nil)
+	if !errors.Is(err, ErrDeckTooLarge) {
+		t.Errorf("Expected %v, got %v", ErrDeckTooLarge, err)
+	}
+
+	// Test drawing cards frees them from the accounting.
+	da = NewAPI(log, NewStore(log, Limits{}))
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = da.Draw(d.DeckID, 2)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	u = da.Usage()
+	if u.Cards != 50 {
+		t.Errorf("Expected 50 cards in use, got %d", u.Cards)
+	}
+}
@@ -0,0 +1,237 @@
+package deck
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var ErrCardNotInHand error = errors.New("Card isn't in the player's hand")
+var ErrCardInDeck error = errors.New("Card is already in the deck")
+var ErrInvalidName error = errors.New("Player and pile names must be 1 to 64 characters long")
+
+const maxNameLength = 64
+
+func validName(name string) bool {
+	return name != "" && len(name) <= maxNameLength
+}
+
+// Deal deals n cards from the top of a deck to each player, one at a time
+// and in turns, into their hands.
+func (da *DeckAPI) Deal(u uuid.UUID, players []string, n int) (_ *Deck, err error) {
+	da, span := da.startSpan("Deal", deckIDAttr(u), attrCards.Int(n*len(players)))
+	defer func() { endSpan(span, err) }()
+
+	if len(players) == 0 {
+		return nil, fmt.Errorf("%w: no players", ErrInvalidName)
+	}
+	for i, p := range players {
+		if !validName(p) || slices.Contains(players[:i], p) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidName, p)
+		}
+	}
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
+	if err != nil {
+		return nil, ErrDeckNotFound
+	}
+
+	if n < 1 {
+		return nil, ErrUnsufficientCards
+	}
+	// Dividing instead of multiplying, as n*len(players) can overflow.
+	if n > d.Remaining/len(players) {
+		requested := n * len(players)
+		if requested/len(players) != n {
+			requested = math.MaxInt
+		}
+		return nil, da.stats.insufficient(&InsufficientCardsError{Remaining: d.Remaining, Requested: requested})
+	}
+
+	d, err = da.record(Event{
+		Type:    EventCardsDealt,
+		DeckID:  u,
+		Count:   n,
+		Players: slices.Clone(players),
+		Cards:   slices.Clone(d.Cards[:n*len(players)]),
+	}, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Play moves a card from a player's hand to the top of a pile, creating the
+// pile if it doesn't exist.
+func (da *DeckAPI) Play(u uuid.UUID, player string, card CardID, pile string) (_ *Deck, err error) {
+	da, span := da.startSpan("Play", deckIDAttr(u), attrCards.Int(1))
+	defer func() { endSpan(span, err) }()
+
+	if !validName(player) || !validName(pile) {
+		return nil, ErrInvalidName
+	}
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
+	if err != nil {
+		return nil, ErrDeckNotFound
+	}
+
+	if !slices.Contains(d.Hands[player], card) {
+		return nil, ErrCardNotInHand
+	}
+
+	d, err = da.record(Event{
+		Type:   EventCardPlayed,
+		DeckID: u,
+		Player: player,
+		Pile:   pile,
+		Cards:  []CardID{card},
+	}, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Return puts cards back at the bottom of a deck, from a hand, a pile, or
+// drawn from it earlier.
+func (da *DeckAPI) Return(u uuid.UUID, cards []CardID) (_ *Deck, err error) {
+	da, span := da.startSpan("Return", deckIDAttr(u), attrCards.Int(len(cards)))
+	defer func() { endSpan(span, err) }()
+
+	da.mu.Lock()
+	defer da.mu.Unlock()
+
+	err = da.writable()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := da.store.QueryById(da.ctx, u)
+	if err != nil {
+		return nil, ErrDeckNotFound
+	}
+
+	for i, c := range cards {
+		if !c.Valid() {
+			return nil, fmt.Errorf("%w: card %d is unknown", ErrInvalidDeck, i)
+		}
+		if slices.Contains(d.Cards, c) || slices.Contains(cards[:i], c) {
+			return nil, fmt.Errorf("%w: %s", ErrCardInDeck, c.Card().Code)
+		}
+	}
+
+	d, err = da.record(Event{
+		Type:   EventCardsReturned,
+		DeckID: u,
+		Cards:  slices.Clone(cards),
+	}, d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// deal applies a dealt event. Decks returned by the store share their maps
+// and slices with it, so they are copied before they change.
+func (d Deck) deal(e Event) Deck {
+	n := min(len(e.Cards), len(d.Cards))
+	d.Cards = slices.Clone(d.Cards[n:])
+	d.Remaining = len(d.Cards)
+	d.Hands = maps.Clone(d.Hands)
+	if d.Hands == nil {
+		d.Hands = make(map[string][]CardID)
+	}
+	for i, c := range e.Cards[:n] {
+		p := e.Players[i%len(e.Players)]
+		d.Hands[p] = append(slices.Clip(d.Hands[p]), c)
+	}
+	return d
+}
+
+// play applies a played event, copying what changes like deal. Cards are
+// played face up, however they lay in the hand.
+func (d Deck) play(e Event) Deck {
+	hand := d.Hands[e.Player]
+	d.Hands = maps.Clone(d.Hands)
+	d.Piles = maps.Clone(d.Piles)
+	if d.Piles == nil {
+		d.Piles = make(map[string][]CardID)
+	}
+	for _, c := range e.Cards {
+		i := slices.Index(hand, c)
+		if i < 0 {
+			continue
+		}
+		hand = slices.Delete(slices.Clone(hand), i, i+1)
+		d.Piles[e.Pile] = append(slices.Clip(d.Piles[e.Pile]), c)
+	}
+	if len(hand) == 0 {
+		delete(d.Hands, e.Player)
+	} else {
+		d.Hands[e.Player] = hand
+	}
+	d.Flipped = unflip(d.Flipped, e.Cards)
+	return d
+}
+
+// returnCards applies a returned event, taking the cards out of any hand or
+// pile they are in, copying what changes like deal.
+func (d Deck) returnCards(e Event) Deck {
+	d.Hands = takeCards(d.Hands, e.Cards)
+	d.Piles = takeCards(d.Piles, e.Cards)
+	d.Flipped = unflip(d.Flipped, e.Cards)
+	d.Cards = append(slices.Clip(d.Cards), e.Cards...)
+	d.Remaining = len(d.Cards)
+	return d
+}
+
+// takeCards returns a copy of hands or piles without the given cards, and
+// without the ones left empty.
+func takeCards(m map[string][]CardID, cards []CardID) map[string][]CardID {
+	if len(m) == 0 {
+		return m
+	}
+	taken := make(map[string][]CardID, len(m))
+	for name, held := range m {
+		held = slices.DeleteFunc(slices.Clone(held), func(c CardID) bool {
+			return slices.Contains(cards, c)
+		})
+		if len(held) > 0 {
+			taken[name] = held
+		}
+	}
+	return taken
+}
+
+// cloneCards deep copies hands or piles.
+func cloneCards(m map[string][]CardID) map[string][]CardID {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string][]CardID, len(m))
+	for k, v := range m {
+		c[k] = slices.Clone(v)
+	}
+	return c
+}
@@ -0,0 +1,103 @@
+package deck
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDealAndPlay(t *testing.T) {
+	// Test cards are dealt in turns from the top of the deck.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := NewAPI(log, NewStore(log, Limits{}), WithEventLog(NewMemoryEventLog()))
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	dealt, err := da.Deal(d.DeckID, []string{"ann", "bob"}, 2)
+	if err != nil {
+		t.Fatalf("Failed to deal: %v", err)
+	}
+	expected := map[string][]CardID{"ann": {0, 2}, "bob": {1, 3}}
+	if !reflect.DeepEqual(dealt.Hands, expected) || dealt.Remaining != 48 {
+		t.Errorf("Expected hands %v and 48 cards remaining, got %v and %d", expected, dealt.Hands, dealt.Remaining)
+	}
+
+	// Test playing a card moves it to the top of the pile.
+	_, err = da.Play(d.DeckID, "bob", 3, "discard")
+	if err != nil {
+		t.Fatalf("Failed to play: %v", err)
+	}
+	played, err := da.Play(d.DeckID, "bob", 1, "discard")
+	if err != nil {
+		t.Fatalf("Failed to play: %v", err)
+	}
+	if !reflect.DeepEqual(played.Piles["discard"], []CardID{3, 1}) {
+		t.Errorf("Expected the discard pile to be [3 1], got %v", played.Piles["discard"])
+	}
+	if _, ok := played.Hands["bob"]; ok {
+		t.Errorf("Expected bob's hand to be gone, got %v", played.Hands["bob"])
+	}
+
+	// Test earlier states aren't changed by later moves.
+	if !reflect.DeepEqual(dealt.Hands, expected) {
+		t.Errorf("Expected the dealt deck to keep hands %v, got %v", expected, dealt.Hands)
+	}
+	events, err := da.History(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	at, err := da.GetAt(d.DeckID, events[1].Seq)
+	if err != nil {
+		t.Fatalf("Failed to get deck at %d: %v", events[1].Seq, err)
+	}
+	if !reflect.DeepEqual(at, dealt) {
+		t.Errorf("Expected the deck after the deal to be %v, got %v", dealt, at)
+	}
+	if err := played.Validate(); err != nil {
+		t.Errorf("Expected a valid deck, got %v", err)
+	}
+
+	// Test invalid moves are rejected.
+	_, err = da.Play(d.DeckID, "ann", 1, "discard")
+	if !errors.Is(err, ErrCardNotInHand) {
+		t.Errorf("Expected %v, got %v", ErrCardNotInHand, err)
+	}
+	_, err = da.Deal(d.DeckID, []string{"ann", "ann"}, 1)
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected %v, got %v", ErrInvalidName, err)
+	}
+	_, err = da.Deal(d.DeckID, []string{"ann", "bob"}, 25)
+	if !errors.Is(err, ErrUnsufficientCards) {
+		t.Errorf("Expected %v, got %v", ErrUnsufficientCards, err)
+	}
+	_, err = da.Deal(d.DeckID, []string{"ann", "bob"}, math.MaxInt/2+2)
+	if !errors.Is(err, ErrUnsufficientCards) {
+		t.Errorf("Expected %v for a count that overflows, got %v", ErrUnsufficientCards, err)
+	}
+
+	// Test hands and piles survive an export and import.
+	other := NewAPI(log, NewStore(log, Limits{}))
+	_, err = other.Import([]Deck{*played})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	imported, err := other.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if !reflect.DeepEqual(imported, played) {
+		t.Errorf("Expected imported deck %v, got %v", played, imported)
+	}
+
+	// Test a card can't be in two places.
+	dup := *played
+	dup.Piles = map[string][]CardID{"discard": {3, 1, 4}}
+	if err := dup.Validate(); !errors.Is(err, ErrInvalidDeck) {
+		t.Errorf("Expected %v, got %v", ErrInvalidDeck, err)
+	}
+}
@@ -0,0 +1,53 @@
+package deck
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of DeckAPI operations and store calls, which are
+// recorded once a tracer provider is set up.
+var tracer = otel.Tracer("github.com/mtpereira/deck/deck")
+
+// The attributes of deck spans.
+const (
+	attrDeckID    = attribute.Key("deck.id")
+	attrOperation = attribute.Key("deck.operation")
+	// attrCards is the number of cards an operation asks for or moves, or
+	// the cards of the deck a store call reads or writes.
+	attrCards = attribute.Key("deck.cards")
+	// attrDecks is the number of decks an import loads.
+	attrDecks = attribute.Key("deck.decks")
+)
+
+// startSpan starts the span of the operation op, returning a DeckAPI whose
+// store calls are part of it.
+func (da *DeckAPI) startSpan(op string, attrs ...attribute.KeyValue) (*DeckAPI, trace.Span) {
+	attrs = append(attrs, attrOperation.String(op))
+	ctx, span := tracer.Start(da.ctx, "DeckAPI."+op, trace.WithAttributes(attrs...))
+	return &DeckAPI{state: da.state, actor: da.actor, ctx: ctx}, span
+}
+
+// startStoreSpan starts the span of the store call op on deck u.
+func startStoreSpan(ctx context.Context, op string, u uuid.UUID, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, deckIDAttr(u), attrOperation.String(op))
+	return tracer.Start(ctx, "DeckStore."+op, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it as failed with err, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func deckIDAttr(u uuid.UUID) attribute.KeyValue {
+	return attrDeckID.String(u.String())
+}
@@ -0,0 +1,134 @@
+package deck
+
+import "slices"
+
+// Viewer is who a deck or its events are shown to, which decides the cards
+// they see: everyone sees the cards lying face up in hands and piles, players
+// the cards in their own hand, and the cards left in a deck are only counted.
+// Viewers with All set see every card, in order.
+type Viewer struct {
+	Player string
+	All    bool
+}
+
+// SeesHand reports whether v sees the cards in the hand of player, face down
+// or not.
+func (v Viewer) SeesHand(player string) bool {
+	return v.All || (v.Player != "" && v.Player == player)
+}
+
+// Cards returns the cards left in d as v sees them, nil unless v sees them
+// all.
+func (v Viewer) Cards(d Deck) []Card {
+	if !v.All {
+		return nil
+	}
+	return Expand(d.Cards)
+}
+
+// Hands returns the hands of d as v sees them. Cards v can't see are hidden,
+// but keep their place.
+func (v Viewer) Hands(d Deck) map[string][]Card {
+	return v.show(d.Hands, func(player string, c CardID) (Orientation, bool) {
+		if slices.Contains(d.Flipped, c) {
+			return FaceUp, true
+		}
+		return FaceDown, v.SeesHand(player)
+	})
+}
+
+// Piles returns the piles of d as v sees them, hiding the cards lying face
+// down like Hands.
+func (v Viewer) Piles(d Deck) map[string][]Card {
+	return v.show(d.Piles, func(_ string, c CardID) (Orientation, bool) {
+		if slices.Contains(d.Flipped, c) {
+			return FaceDown, v.All
+		}
+		return FaceUp, true
+	})
+}
+
+// show expands hands or piles, with the orientation of each card and whether
+// v sees it.
+func (v Viewer) show(m map[string][]CardID, seen func(name string, c CardID) (Orientation, bool)) map[string][]Card {
+	if len(m) == 0 {
+		return nil
+	}
+	shown := make(map[string][]Card, len(m))
+	for name, ids := range m {
+		cards := make([]Card, len(ids))
+		for i, c := range ids {
+			o, ok := seen(name, c)
+			if ok {
+				cards[i] = c.Card()
+			} else {
+				cards[i].Hidden = true
+			}
+			cards[i].Orientation = o
+		}
+		shown[name] = cards
+	}
+	return shown
+}
+
+// Event returns e as v sees it. Cards v can't see are left out, and counted
+// in Count when the event has no count of its own. Imported decks keep the
+// hands v sees and the piles with no card face down.
+func (v Viewer) Event(e Event) Event {
+	if v.All {
+		return e
+	}
+	switch e.Type {
+	case EventCardPlayed, EventCardFlipped, EventPileFlipped, EventHandRevealed:
+		// The cards were face up before or after the event.
+		return e
+	case EventDeckImported:
+		e.Hands = keep(e.Hands, func(player string, _ []CardID) bool {
+			return v.SeesHand(player)
+		})
+		e.Piles = keep(e.Piles, func(_ string, ids []CardID) bool {
+			return !slices.ContainsFunc(ids, func(c CardID) bool {
+				return slices.Contains(e.Flipped, c)
+			})
+		})
+		e.Flipped = slices.DeleteFunc(slices.Clone(e.Flipped), func(c CardID) bool {
+			for _, ids := range e.Hands {
+				if slices.Contains(ids, c) {
+					return false
+				}
+			}
+			return true
+		})
+		if len(e.Flipped) == 0 {
+			e.Flipped = nil
+		}
+	}
+	if e.Count == 0 {
+		e.Count = len(e.Cards)
+	}
+	e.Cards = nil
+	return e
+}
+
+// keep returns the hands or piles of m that keep reports true for.
+func keep(m map[string][]CardID, keep func(name string, ids []CardID) bool) map[string][]CardID {
+	var kept map[string][]CardID
+	for name, ids := range m {
+		if keep(name, ids) {
+			if kept == nil {
+				kept = make(map[string][]CardID)
+			}
+			kept[name] = ids
+		}
+	}
+	return kept
+}
+
+// Events returns events as v sees them.
+func (v Viewer) Events(events []Event) []Event {
+	events = slices.Clone(events)
+	for i, e := range events {
+		events[i] = v.Event(e)
+	}
+	return events
+}
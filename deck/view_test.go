@@ -0,0 +1,100 @@
+package deck
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestViewer(t *testing.T) {
+	ace, king, queen := mustParse(t, "AS"), mustParse(t, "KH"), mustParse(t, "QD")
+	d := Deck{
+		DeckID:    uuid.New(),
+		Remaining: 1,
+		Cards:     []CardID{queen},
+		Hands:     map[string][]CardID{"ann": {ace}, "bob": {king}},
+		Piles:     map[string][]CardID{"discard": {queen}},
+	}
+
+	// Test players see their own hand and the piles, but not the deck.
+	ann := Viewer{Player: "ann"}
+	if cards := ann.Cards(d); cards != nil {
+		t.Errorf("Expected the cards left to be hidden, got %v", cards)
+	}
+	shown := func(c CardID, o Orientation) Card {
+		card := c.Card()
+		card.Orientation = o
+		return card
+	}
+	hidden := Card{Hidden: true, Orientation: FaceDown}
+	want := map[string][]Card{"ann": {shown(ace, FaceDown)}, "bob": {hidden}}
+	if hands := ann.Hands(d); !reflect.DeepEqual(hands, want) {
+		t.Errorf("Expected %v, got %v", want, hands)
+	}
+	if piles := ann.Piles(d); !reflect.DeepEqual(piles["discard"], []Card{shown(queen, FaceUp)}) {
+		t.Errorf("Expected ann to see the discard pile, got %v", piles)
+	}
+	if (Viewer{}).SeesHand("") {
+		t.Errorf("Expected viewers without a player not to see hands without one")
+	}
+
+	// Test flipped cards are seen face up in hands, and hidden in piles.
+	flipped := d
+	flipped.Flipped = []CardID{king, queen}
+	if hands := ann.Hands(flipped); !reflect.DeepEqual(hands["bob"], []Card{shown(king, FaceUp)}) {
+		t.Errorf("Expected bob's card to be face up, got %v", hands["bob"])
+	}
+	if piles := ann.Piles(flipped); !reflect.DeepEqual(piles["discard"], []Card{hidden}) {
+		t.Errorf("Expected the discard pile to be face down, got %v", piles)
+	}
+
+	// Test viewers who see all see everything.
+	all := Viewer{All: true}
+	if cards := all.Cards(d); !reflect.DeepEqual(cards, Expand(d.Cards)) {
+		t.Errorf("Expected every card left, got %v", cards)
+	}
+	if piles := all.Piles(flipped); !reflect.DeepEqual(piles["discard"], []Card{shown(queen, FaceDown)}) {
+		t.Errorf("Expected the face down queen, got %v", piles)
+	}
+	if !all.SeesHand("bob") {
+		t.Errorf("Expected bob's hand to be seen")
+	}
+
+	// Test events keep the cards their viewer sees, and count the others.
+	events := []Event{
+		{Type: EventDeckCreated, Cards: []CardID{ace, king, queen}},
+		{Type: EventCardsDealt, Players: []string{"ann", "bob"}, Count: 1, Cards: []CardID{ace, king}},
+		{Type: EventCardPlayed, Player: "ann", Pile: "discard", Cards: []CardID{ace}},
+		{Type: EventDeckImported, Cards: []CardID{queen}, Hands: map[string][]CardID{"ann": {ace}, "bob": {king}}},
+		{Type: EventDeckImported, Hands: map[string][]CardID{"ann": {ace}}, Piles: map[string][]CardID{"discard": {queen}, "stock": {king}}, Flipped: []CardID{ace, queen}},
+		{Type: EventCardFlipped, Pile: "discard", Cards: []CardID{queen}},
+	}
+	seen := ann.Events(events)
+	wantEvents := []Event{
+		{Type: EventDeckCreated, Count: 3},
+		{Type: EventCardsDealt, Players: []string{"ann", "bob"}, Count: 1},
+		events[2],
+		{Type: EventDeckImported, Count: 1, Hands: map[string][]CardID{"ann": {ace}}},
+		{Type: EventDeckImported, Hands: map[string][]CardID{"ann": {ace}}, Piles: map[string][]CardID{"stock": {king}}, Flipped: []CardID{ace}},
+		events[5],
+	}
+	if !reflect.DeepEqual(seen, wantEvents) {
+		t.Errorf("Expected %v, got %v", wantEvents, seen)
+	}
+	if events[0].Cards == nil {
+		t.Errorf("Expected the events to be left as they were")
+	}
+	if seen := all.Events(events); !reflect.DeepEqual(seen, events) {
+		t.Errorf("Expected every card of every event, got %v", seen)
+	}
+}
+
+func mustParse(t *testing.T, code string) CardID {
+	t.Helper()
+	c, err := ParseCard(code)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", code, err)
+	}
+	return c
+}
@@ -0,0 +1,476 @@
+// Package deckclient is a client for the deck HTTP API.
+package deckclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+// Deck is a deck as returned by the API.
+type Deck struct {
+	DeckID    uuid.UUID   `json:"deck_id"`
+	Shuffled  bool        `json:"shuffled"`
+	Remaining int         `json:"remaining"`
+	Cards     []deck.Card `json:"cards,omitempty"`
+}
+
+// Violation is a part of a request that doesn't match the API spec.
+type Violation struct {
+	Pointer string `json:"pointer"`
+	Detail  string `json:"detail"`
+}
+
+// Error is returned for every response that isn't successful. It wraps the
+// matching sentinel error of the deck or auth package, if any, so callers can
+// use errors.Is(err, deck.ErrDeckNotFound).
+type Error struct {
+	StatusCode int
+	// Code identifies the kind of error, such as "deck_not_found".
+	Code       string
+	Message    string
+	Violations []Violation
+	err        error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("deck api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("deck api: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// ErrRateLimited is returned, wrapped in an Error, when the client called the
+// API more often than the server allows. It's retried after the delay the
+// server asks for.
+var ErrRateLimited = errors.New("Rate limited")
+
+// ErrQuotaExceeded is returned, wrapped in an Error, when the client created
+// as many decks as the server allows for the day. It isn't retried.
+var ErrQuotaExceeded = errors.New("Quota exceeded")
+
+// codeErrors maps the problem codes of the API to the errors they stand for.
+var codeErrors = map[string]error{
+	"deck_not_found":     deck.ErrDeckNotFound,
+	"insufficient_cards": deck.ErrUnsufficientCards,
+	"card_not_in_hand":   deck.ErrCardNotInHand,
+	"card_not_in_play":   deck.ErrCardNotInPlay,
+	"pile_not_found":     deck.ErrPileNotFound,
+	"hand_not_found":     deck.ErrHandNotFound,
+	"card_in_deck":       deck.ErrCardInDeck,
+	"invalid_deck":       deck.ErrInvalidDeck,
+	"invalid_name":       deck.ErrInvalidName,
+	"store_full":         deck.ErrStoreFull,
+	"deck_too_large":     deck.ErrDeckTooLarge,
+	"read_only":          deck.ErrReadOnly,
+	"events_disabled":    deck.ErrEventsDisabled,
+	"not_follower":       deck.ErrNotFollower,
+	"rate_limited":       ErrRateLimited,
+	"quota_exceeded":     ErrQuotaExceeded,
+	"unauthenticated":    auth.ErrUnauthenticated,
+	"forbidden":          auth.ErrForbidden,
+}
+
+type Client struct {
+	baseURL     string
+	http        *http.Client
+	retries     int
+	backoff     time.Duration
+	replays     bool
+	apiKey      string
+	shareToken  string
+	bearerToken string
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient sets the HTTP client requests are sent with.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithRetries sets how many times requests are retried after a network error
+// or a transient server error, and the delay before the first retry, which
+// doubles on every attempt. Negative retries are taken as 0.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = max(retries, 0)
+		c.backoff = backoff
+	}
+}
+
+// WithIdempotentRetries also retries mutations that may have reached the
+// server, such as when their response got lost, counting on the server to
+// replay the first response to their idempotency key. Only use it with
+// servers that keep responses, which DECK_IDEMPOTENCY_WINDOW set to 0 turns
+// off; otherwise a retry can draw or create twice.
+func WithIdempotentRetries() Option {
+	return func(c *Client) {
+		c.replays = true
+	}
+}
+
+// WithAPIKey sends key with every request, for servers that need one.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithShareToken sends a share token with every request, to use a deck
+// someone else shared.
+func WithShareToken(token string) Option {
+	return func(c *Client) {
+		c.shareToken = token
+	}
+}
+
+// WithBearerToken sends a JWT bearer token with every request, for players
+// of a lobby the server trusts.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.bearerToken = token
+	}
+}
+
+// New returns a client for the deck server at baseURL, such as
+// http://127.0.0.1:9000. By default it retries requests twice.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    http.DefaultClient,
+		retries: 2,
+		backoff: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewDeck creates a deck. A nil cards creates a full deck.
+func (c *Client) NewDeck(ctx context.Context, shuffled bool, cards []deck.Card) (*Deck, error) {
+	var body io.Reader
+	if cards != nil {
+		b, err := json.Marshal(cards)
+		if err != nil {
+			return nil, fmt.Errorf("encode cards: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	q := url.Values{"shuffled": {strconv.FormatBool(shuffled)}}
+
+	var d Deck
+	err := c.do(ctx, http.MethodPost, "/v1/decks?"+q.Encode(), body, uuid.NewString(), &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (c *Client) GetDeck(ctx context.Context, id uuid.UUID) (*Deck, error) {
+	var d Deck
+	err := c.do(ctx, http.MethodGet, "/v1/decks/"+id.String(), nil, "", &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetDeckAt returns a deck as it was right after the event with sequence
+// number seq. The server needs event sourcing enabled.
+func (c *Client) GetDeckAt(ctx context.Context, id uuid.UUID, seq uint64) (*Deck, error) {
+	var d Deck
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v1/decks/%s?at=%d", id, seq), nil, "", &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Draw draws n cards from the top of a deck. It's sent with an idempotency
+// key, so retries never draw more cards than asked for, as long as the server
+// keeps responses.
+func (c *Client) Draw(ctx context.Context, id uuid.UUID, n int) ([]deck.Card, error) {
+	var resp struct {
+		Cards []deck.Card `json:"cards"`
+	}
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/decks/%s/cards/%d", id, n), nil, uuid.NewString(), &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Cards, nil
+}
+
+// Shuffle shuffles the cards remaining in a deck.
+func (c *Client) Shuffle(ctx context.Context, id uuid.UUID) (*Deck, error) {
+	var d Deck
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/v1/decks/%s/shuffle", id), nil, uuid.NewString(), &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// History returns every event of a deck. The server needs event sourcing
+// enabled.
+func (c *Client) History(ctx context.Context, id uuid.UUID) ([]deck.Event, error) {
+	var resp struct {
+		Events []deck.Event `json:"events"`
+	}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v1/decks/%s/events", id), nil, "", &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+func (c *Client) Usage(ctx context.Context) (deck.Usage, error) {
+	var u deck.Usage
+	err := c.do(ctx, http.MethodGet, "/v1/admin/usage", nil, "", &u)
+	return u, err
+}
+
+// Export writes every deck on the server to w as newline delimited JSON.
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/v1/admin/export", "", nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return fmt.Errorf("export decks: %w", err)
+	}
+	return nil
+}
+
+// Import loads decks, as written by Export, into the server. The decks are
+// read from r once, so the import is never retried.
+func (c *Client) Import(ctx context.Context, r io.Reader) (int, error) {
+	resp, err := c.send(ctx, http.MethodPost, "/v1/admin/import", "application/x-ndjson", r, "")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Imported int `json:"imported"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return 0, fmt.Errorf("decode import response: %w", err)
+	}
+	return body.Imported, nil
+}
+
+func (c *Client) ReplicationStatus(ctx context.Context) (deck.ReplicationStatus, error) {
+	var s deck.ReplicationStatus
+	err := c.do(ctx, http.MethodGet, "/v1/replication/status", nil, "", &s)
+	return s, err
+}
+
+// Promote turns a follower into a leader.
+func (c *Client) Promote(ctx context.Context) (deck.ReplicationStatus, error) {
+	var s deck.ReplicationStatus
+	err := c.do(ctx, http.MethodPost, "/v1/replication/promote", nil, uuid.NewString(), &s)
+	return s, err
+}
+
+// do sends a request with an optional JSON body and decodes its JSON response
+// into v.
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, key string, v any) error {
+	resp, err := c.send(ctx, method, path, "application/json", body, key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+// send sends a request and returns the response if it's successful. Reads are
+// retried. Mutations with an idempotency key are retried when they never
+// reached the server, or it turned them down with a 429 or 503, unless
+// WithIdempotentRetries says the server replays them.
+func (c *Client) send(ctx context.Context, method, path, contentType string, body io.Reader, key string) (*http.Response, error) {
+	attempts := 1
+	if method == http.MethodGet || key != "" {
+		attempts += c.retries
+	}
+	// Bodies are buffered so every attempt can send them again.
+	var b []byte
+	if body != nil && attempts > 1 {
+		var err error
+		b, err = io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+	}
+
+	var err error
+	var retryAfter time.Duration
+	for attempt := range attempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(max(c.delay(attempt), retryAfter)):
+			}
+		}
+
+		var req *http.Request
+		if b != nil {
+			body = bytes.NewReader(b)
+		}
+		req, err = http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		if c.apiKey != "" {
+			req.Header.Set("X-API-Key", c.apiKey)
+		}
+		if c.shareToken != "" {
+			req.Header.Set("X-Share-Token", c.shareToken)
+		}
+		if c.bearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+		}
+
+		var sent atomic.Bool
+		req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			WroteHeaders: func() { sent.Store(true) },
+		}))
+
+		var resp *http.Response
+		resp, err = c.http.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			err = fmt.Errorf("%s %s: %w", method, path, err)
+			if !c.mayRepeat(method) && sent.Load() {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		err = decodeError(resp)
+		resp.Body.Close()
+		if !retryable(resp.StatusCode) || errors.Is(err, ErrQuotaExceeded) {
+			return nil, err
+		}
+		if !c.mayRepeat(method) && !refused(resp.StatusCode) {
+			return nil, err
+		}
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return nil, err
+}
+
+// mayRepeat reports whether a request with method can be retried after the
+// server may have handled it.
+func (c *Client) mayRepeat(method string) bool {
+	return method == http.MethodGet || c.replays
+}
+
+// delay is the exponential backoff before a retry, with up to 20% jitter.
+func (c *Client) delay(attempt int) time.Duration {
+	d := c.backoff << (attempt - 1)
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// retryable reports whether a status code is worth retrying. A follower
+// answers 503 to mutations for as long as it's a follower, so those retries
+// fail again, but only after the backoff.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// refused reports whether a status code means the server turned the request
+// down without handling it: rate limits, and followers rejecting mutations.
+// Gateways may have passed the request on before failing, so their errors
+// aren't.
+func refused(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter returns how long a Retry-After header asks to wait, given
+// in seconds or as a date, and 0 if there's none.
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// decodeError reads a problem response, as defined by RFC 9457.
+func decodeError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	var body struct {
+		Code      string      `json:"code"`
+		Detail    string      `json:"detail"`
+		Errors    []Violation `json:"errors"`
+		Remaining *int        `json:"remaining"`
+		Requested *int        `json:"requested"`
+	}
+	err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		return e
+	}
+	e.Code = body.Code
+	e.Message = body.Detail
+	e.Violations = body.Errors
+	e.err = codeErrors[e.Code]
+	if body.Remaining != nil && body.Requested != nil {
+		e.err = &deck.InsufficientCardsError{Remaining: *body.Remaining, Requested: *body.Requested}
+	}
+	return e
+}
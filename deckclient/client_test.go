@@ -0,0 +1,308 @@
+package deckclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/web"
+)
+
+func TestClient(t *testing.T) {
+	// Test the client creates, gets and draws from decks.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	server := httptest.NewServer(web.NewMux(log, da))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL)
+
+	d, err := c.NewDeck(ctx, false, []deck.Card{{Code: "AS"}, {Code: "KH"}, {Code: "2C"}})
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	if d.Remaining != 3 {
+		t.Errorf("Expected 3 cards remaining, got %d", d.Remaining)
+	}
+
+	cards, err := c.Draw(ctx, d.DeckID, 2)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	expected := []deck.Card{{Code: "AS"}, {Code: "KH"}}
+	if len(cards) != 2 || cards[0] != expected[0] || cards[1] != expected[1] {
+		t.Errorf("Expected to draw %v, got %v", expected, cards)
+	}
+
+	got, err := c.GetDeck(ctx, d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if got.Remaining != 1 || len(got.Cards) != 0 {
+		t.Errorf("Expected 1 hidden card to remain, got %v", got)
+	}
+
+	at, err := c.GetDeckAt(ctx, d.DeckID, 1)
+	if err != nil {
+		t.Fatalf("Failed to get deck at 1: %v", err)
+	}
+	if at.Remaining != 3 {
+		t.Errorf("Expected 3 cards at the first event, got %d", at.Remaining)
+	}
+
+	events, err := c.History(ctx, d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("Expected 2 events, got %d", len(events))
+	}
+
+	// Test the client exports and imports decks.
+	var export bytes.Buffer
+	err = c.Export(ctx, &export)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	n, err := c.Import(ctx, &export)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 imported deck, got %d", n)
+	}
+
+	shuffled, err := c.Shuffle(ctx, d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to shuffle deck: %v", err)
+	}
+	if !shuffled.Shuffled || shuffled.Remaining != 1 {
+		t.Errorf("Expected a shuffled deck with 1 card, got %v", shuffled)
+	}
+
+	// Test errors wrap the deck sentinel errors.
+	_, err = c.Draw(ctx, d.DeckID, 2)
+	if !errors.Is(err, deck.ErrUnsufficientCards) {
+		t.Errorf("Expected %v, got %v", deck.ErrUnsufficientCards, err)
+	}
+	var insufficient *deck.InsufficientCardsError
+	if !errors.As(err, &insufficient) || insufficient.Remaining != 1 || insufficient.Requested != 2 {
+		t.Errorf("Expected 1 card remaining and 2 requested, got %v", insufficient)
+	}
+	_, err = c.GetDeck(ctx, uuid.New())
+	if !errors.Is(err, deck.ErrDeckNotFound) {
+		t.Errorf("Expected %v, got %v", deck.ErrDeckNotFound, err)
+	}
+	_, err = c.Promote(ctx)
+	if !errors.Is(err, deck.ErrNotFollower) {
+		t.Errorf("Expected %v, got %v", deck.ErrNotFollower, err)
+	}
+	var apiErr *Error
+	_, err = c.Draw(ctx, d.DeckID, 53)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "invalid_parameter" || len(apiErr.Violations) != 1 {
+		t.Errorf("Expected a 400 with one violation, got %v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	// Test idempotent requests are retried on transient errors.
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"deck_id":"14ca6cac-e933-4484-8e3f-e5acd505d11d","shuffled":false,"remaining":0,"cards":null}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL, WithRetries(2, time.Millisecond))
+
+	_, err := c.GetDeck(ctx, uuid.New())
+	if err != nil {
+		t.Errorf("Expected the third attempt to succeed, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+
+	// Test imports, which can't be read twice, aren't retried.
+	calls.Store(0)
+	_, err = c.Import(ctx, strings.NewReader("{}\n"))
+	if err == nil {
+		t.Errorf("Expected the import to fail")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected 1 attempt, got %d", calls.Load())
+	}
+
+	// Test mutations aren't retried after a gateway error, since the server
+	// may have handled them.
+	calls.Store(0)
+	_, err = c.Shuffle(ctx, uuid.New())
+	if err == nil || calls.Load() != 1 {
+		t.Errorf("Expected 1 failed attempt, got %d and %v", calls.Load(), err)
+	}
+
+	// Test negative retries send requests once.
+	calls.Store(0)
+	_, err = New(server.URL, WithRetries(-1, time.Millisecond)).GetDeck(ctx, uuid.New())
+	if err == nil || calls.Load() != 1 {
+		t.Errorf("Expected 1 failed attempt, got %d and %v", calls.Load(), err)
+	}
+
+	// Test retries stop when the context is done.
+	calls.Store(-100)
+	c = New(server.URL, WithRetries(5, time.Second))
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetDeck(ctx, uuid.New())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	// Test rate limited requests wait as long as Retry-After asks.
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n != 2 {
+			code := "rate_limited"
+			if n > 2 {
+				code = "quota_exceeded"
+			}
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, `{"type":"/problems/%[1]s","title":"Limited","status":429,"code":"%[1]s"}`, code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"deck_id":"14ca6cac-e933-4484-8e3f-e5acd505d11d","shuffled":false,"remaining":52}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL, WithRetries(2, time.Millisecond))
+
+	start := time.Now()
+	_, err := c.NewDeck(ctx, false, nil)
+	if err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("Expected the retry to wait for a second, took %v", time.Since(start))
+	}
+
+	// Test exceeded quotas aren't retried.
+	_, err = c.NewDeck(ctx, false, nil)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected %v, got %v", ErrQuotaExceeded, err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 1 attempt, got %d", calls.Load()-2)
+	}
+}
+
+func TestClientAPIKey(t *testing.T) {
+	// Test keys and share tokens are sent with every request.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	kr := auth.NewKeyring()
+	kr.Put(auth.Key{ID: "ann"}, "ann-secret")
+	server := httptest.NewServer(web.NewMux(log, da, web.WithKeyring(kr)))
+	defer server.Close()
+
+	ctx := context.Background()
+	_, err := New(server.URL).NewDeck(ctx, false, nil)
+	if !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Errorf("Expected %v, got %v", auth.ErrUnauthenticated, err)
+	}
+	d, err := New(server.URL, WithAPIKey("ann-secret")).NewDeck(ctx, false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	token, _, err := kr.Share(d.DeckID, auth.AccessRead, "ann")
+	if err != nil {
+		t.Fatalf("Failed to share deck: %v", err)
+	}
+	c := New(server.URL, WithShareToken(token))
+	_, err = c.GetDeck(ctx, d.DeckID)
+	if err != nil {
+		t.Errorf("Expected the share token to read the deck, got %v", err)
+	}
+	_, err = c.Draw(ctx, d.DeckID, 1)
+	if !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("Expected %v, got %v", auth.ErrForbidden, err)
+	}
+}
+
+// lossyTransport sends every request but loses the first response.
+type lossyTransport struct {
+	lost atomic.Bool
+}
+
+func (lt *lossyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || lt.lost.Swap(true) {
+		return resp, err
+	}
+	resp.Body.Close()
+	return nil, errors.New("connection reset")
+}
+
+func TestClientRetriesDraws(t *testing.T) {
+	// Test a draw whose response got lost is retried without drawing again.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	server := httptest.NewServer(web.NewMux(log, da))
+	defer server.Close()
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	// Test it isn't retried unless the client knows the server keeps
+	// responses.
+	ctx := context.Background()
+	c := New(server.URL, WithHTTPClient(&http.Client{Transport: &lossyTransport{}}), WithRetries(2, time.Millisecond))
+	_, err = c.Draw(ctx, d.DeckID, 1)
+	if err == nil {
+		t.Errorf("Expected the lost response to fail the draw")
+	}
+
+	c = New(server.URL, WithHTTPClient(&http.Client{Transport: &lossyTransport{}}), WithRetries(2, time.Millisecond), WithIdempotentRetries())
+	cards, err := c.Draw(ctx, d.DeckID, 2)
+	if err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if len(cards) != 2 || cards[0].Code != "3C" || cards[1].Code != "4C" {
+		t.Errorf("Expected to draw 3C and 4C, got %v", cards)
+	}
+	u, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if u.Remaining != 49 {
+		t.Errorf("Expected 49 cards to remain, got %d", u.Remaining)
+	}
+}
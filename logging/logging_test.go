@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var b strings.Builder
+	log := slog.New(NewHandler(slog.NewTextHandler(&b, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+
+	// Test records get the request ID of their context, if it has one.
+	ctx := WithRequestID(context.Background(), "abc")
+	log.InfoContext(ctx, "store", "query", "started")
+	log.With("deckID", "d1").InfoContext(ctx, "store", "query", "finished")
+	log.Info("store", "query", "started")
+
+	expected := `level=INFO msg=store query=started request_id=abc
+level=INFO msg=store deckID=d1 query=finished request_id=abc
+level=INFO msg=store query=started
+`
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+
+	id, ok := RequestID(ctx)
+	if !ok || id != "abc" {
+		t.Errorf("Expected the request ID abc, got %q", id)
+	}
+	_, ok = RequestID(context.Background())
+	if ok {
+		t.Errorf("Expected no request ID in an empty context")
+	}
+}
@@ -5,30 +5,119 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ardanlabs/conf/v3"
+	"google.golang.org/grpc"
 
+	"github.com/mtpereira/deck/auth"
 	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/logging"
+	"github.com/mtpereira/deck/rpc"
+	"github.com/mtpereira/deck/tracing"
 	"github.com/mtpereira/deck/web"
 )
 
 func main() {
 	ctx := context.Background()
-	log := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	err := run(ctx, log)
+	log := slog.New(logging.NewHandler(slog.NewTextHandler(os.Stdout, nil)))
+
+	var err error
+	switch cmd := command(); cmd {
+	case "serve":
+		dropCommand()
+		fallthrough
+	case "":
+		err = run(ctx, log)
+	case "new":
+		err = runNew(ctx, os.Stdout)
+	case "get":
+		err = runGet(ctx, os.Stdout)
+	case "draw":
+		err = runDraw(ctx, os.Stdout)
+	case "shuffle":
+		err = runShuffle(ctx, os.Stdout)
+	case "export":
+		err = runExport(ctx, os.Stdout)
+	case "import":
+		err = runImport(ctx, os.Stdin, os.Stdout)
+	case "promote":
+		err = runPromote(ctx, os.Stdout)
+	default:
+		err = fmt.Errorf("unknown command %q; use one of serve, new, get, draw, shuffle, export, import or promote", cmd)
+	}
 	if err != nil {
-		log.Error(error.Error(err))
+		slog.New(logging.NewHandler(slog.NewTextHandler(os.Stderr, nil))).Error(error.Error(err))
+		os.Exit(1)
 	}
 }
 
+// command returns the subcommand the binary was called with, if any. Flags
+// without a subcommand are for the server, as they were before there were
+// subcommands.
+func command() string {
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		return ""
+	}
+	return os.Args[1]
+}
+
 func run(ctx context.Context, log *slog.Logger) error {
 	cfg := struct {
-		APIHost string `conf:"default:127.0.0.1:9000"`
+		APIHost  string `conf:"default:127.0.0.1:9000"`
+		GRPCHost string `conf:"help:address the gRPC API listens on, such as 127.0.0.1:9090; disabled when empty"`
+		Store    struct {
+			MaxDecks int    `conf:"default:0,help:maximum number of decks kept in memory; 0 is unbounded"`
+			MaxCards int    `conf:"default:0,help:maximum number of cards kept in memory; 0 is unbounded"`
+			Policy   string `conf:"default:evict,help:evict or reject new decks when the store is full"`
+		}
+		Events struct {
+			Enabled bool `conf:"default:false,help:record every deck operation so decks can be rebuilt at any point"`
+		}
+		Replication struct {
+			Leader string `conf:"help:URL of the leader to follow; runs as the leader when empty"`
+			Key    string `conf:"mask,help:admin API key to follow the leader with when it has keys"`
+		}
+		Body struct {
+			MaxSize       int64 `conf:"default:1048576,help:largest request body in bytes; 0 is unbounded"`
+			MaxImportSize int64 `conf:"default:67108864,help:largest body of imports in bytes; 0 is unbounded"`
+		}
+		Metrics struct {
+			Public bool `conf:"default:false,help:serve /metrics without an admin key when clients must authenticate"`
+		}
+		Idempotency struct {
+			Window time.Duration `conf:"default:24h,help:how long responses to requests with an Idempotency-Key are replayed; 0 disables them"`
+		}
+		Auth struct {
+			Keys string `conf:"help:YAML file of the API keys clients must send; anyone can do anything when empty"`
+			JWT  struct {
+				Secret      string `conf:"mask,help:secret HS256 bearer tokens are signed with"`
+				PublicKey   string `conf:"help:PEM file of the RSA or Ed25519 key RS256 or EdDSA bearer tokens are signed with"`
+				JWKS        string `conf:"help:JWKS file of the keys bearer tokens are signed with"`
+				Issuer      string `conf:"help:iss claim bearer tokens must have"`
+				Audience    string `conf:"help:aud claim bearer tokens must have"`
+				PlayerClaim string `conf:"default:sub,help:claim holding the player of bearer tokens"`
+			}
+		}
+		RateLimit struct {
+			Rate       float64  `conf:"default:0,help:requests per second each client can make to the routes without limits of their own; 0 disables them"`
+			Burst      int      `conf:"default:100,help:requests each client can make at once"`
+			Routes     []string `conf:"help:rate limits of single routes as METHOD PATH RATE BURST separated by semicolons"`
+			DailyDecks int      `conf:"default:0,help:decks each client can create a day; 0 is unbounded"`
+			Logins     int      `conf:"default:10,help:failed logins each IP address can make a minute; 0 is unbounded"`
+		}
+		Tracing struct {
+			Endpoint    string  `conf:"help:host:port of the OTLP gRPC collector to export traces to; disabled when empty"`
+			Insecure    bool    `conf:"default:false,help:export traces to the OTLP collector without TLS"`
+			File        string  `conf:"help:file to write traces to as JSON, or - for stdout; disabled when empty"`
+			SampleRatio float64 `conf:"default:1,help:share of the traces started by the server that are exported"`
+		}
 	}{}
 	prefix := "DECK"
 	help, err := conf.Parse(prefix, &cfg)
@@ -50,21 +139,125 @@ func run(ctx context.Context, log *slog.Logger) error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
-	da := deck.NewAPI(log)
-	mux := web.NewMux(log, da)
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return fmt.Errorf("error setting up tracing: %w", err)
+	}
+	defer func() {
+		// Flush the spans of the last requests.
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		err := shutdownTracing(ctx)
+		if err != nil {
+			log.Error("shutdown", "status", "tracing not flushed", "error", err)
+		}
+	}()
+
+	policy, err := deck.ParsePolicy(cfg.Store.Policy)
+	if err != nil {
+		return fmt.Errorf("error parsing config: %w", err)
+	}
+	ds := deck.NewStore(log, deck.Limits{
+		MaxDecks: cfg.Store.MaxDecks,
+		MaxCards: cfg.Store.MaxCards,
+		Policy:   policy,
+	})
+	var opts []deck.Option
+	if cfg.Events.Enabled || cfg.Replication.Leader != "" {
+		opts = append(opts, deck.WithEventLog(deck.NewMemoryEventLog()))
+	}
+	da := deck.NewAPI(log, ds, opts...)
+	var kr *auth.Keyring
+	if cfg.Auth.Keys != "" {
+		kr, err = auth.LoadKeyring(cfg.Auth.Keys)
+		if err != nil {
+			return fmt.Errorf("error loading keys: %w", err)
+		}
+	}
+	v, err := newVerifier(cfg.Auth.JWT.Secret, cfg.Auth.JWT.PublicKey, cfg.Auth.JWT.JWKS,
+		auth.WithIssuer(cfg.Auth.JWT.Issuer),
+		auth.WithAudience(cfg.Auth.JWT.Audience),
+		auth.WithPlayerClaim(cfg.Auth.JWT.PlayerClaim),
+	)
+	if err != nil {
+		return fmt.Errorf("error loading JWT keys: %w", err)
+	}
+
+	// The HTTP and gRPC APIs share rate limits and quotas.
+	limiterStore := web.NewMemoryLimiterStore()
+	rateLimit := web.RateLimit{Rate: cfg.RateLimit.Rate, Burst: cfg.RateLimit.Burst}
+	webOpts := []web.Option{
+		web.WithLimiterStore(limiterStore),
+		web.WithKeyring(kr),
+		web.WithVerifier(v),
+		web.WithIdempotencyWindow(cfg.Idempotency.Window),
+		web.WithMaxBodySize(cfg.Body.MaxSize),
+		web.WithMaxImportSize(cfg.Body.MaxImportSize),
+		web.WithRateLimit(rateLimit),
+		web.WithDailyDeckQuota(cfg.RateLimit.DailyDecks),
+		web.WithLoginAttemptLimit(cfg.RateLimit.Logins),
+		web.WithPublicMetrics(cfg.Metrics.Public),
+	}
+	for _, route := range cfg.RateLimit.Routes {
+		pattern, limit, err := web.ParseRouteRateLimit(route)
+		if err != nil {
+			return fmt.Errorf("error parsing config: %w", err)
+		}
+		webOpts = append(webOpts, web.WithRouteRateLimit(pattern, limit))
+	}
+	mux := web.NewMux(log, da, webOpts...)
+
+	// Streaming handlers, like the replication log, only stop when their
+	// request context is done, which Shutdown doesn't do on its own.
+	baseCtx, cancelBase := context.WithCancel(ctx)
+	defer cancelBase()
 
 	api := http.Server{
-		Handler:  mux,
-		Addr:     cfg.APIHost,
-		ErrorLog: slog.NewLogLogger(slog.NewTextHandler(os.Stdout, nil), slog.LevelError),
+		Handler:     mux,
+		Addr:        cfg.APIHost,
+		ErrorLog:    slog.NewLogLogger(slog.NewTextHandler(os.Stdout, nil), slog.LevelError),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
+	api.RegisterOnShutdown(cancelBase)
 
-	serverErrors := make(chan error, 1)
+	// One slot per server goroutine, so none blocks once run returns.
+	serverErrors := make(chan error, 3)
+
+	if cfg.Replication.Leader != "" {
+		go func() {
+			err := web.Follow(baseCtx, log, da, cfg.Replication.Leader, cfg.Replication.Key)
+			if err != nil {
+				serverErrors <- fmt.Errorf("replication error: %w", err)
+			}
+		}()
+	}
 	go func() {
 		log.Info("startup", "status", "api listening", "host", api.Addr)
 		serverErrors <- api.ListenAndServe()
 	}()
 
+	var grpcServer *grpc.Server
+	if cfg.GRPCHost != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCHost)
+		if err != nil {
+			return fmt.Errorf("grpc listen error: %w", err)
+		}
+		grpcServer = rpc.NewServer(log, da,
+			rpc.WithKeyring(kr),
+			rpc.WithVerifier(v),
+			rpc.WithLimits(limiterStore, rateLimit, cfg.RateLimit.DailyDecks),
+		)
+		go func() {
+			log.Info("startup", "status", "grpc listening", "host", cfg.GRPCHost)
+			serverErrors <- grpcServer.Serve(lis)
+		}()
+	}
+
 	select {
 	case err := <-serverErrors:
 		return fmt.Errorf("server error: %w", err)
@@ -75,6 +268,23 @@ func run(ctx context.Context, log *slog.Logger) error {
 		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 		defer cancel()
 
+		if grpcServer != nil {
+			// Event streams only end when their clients go away, so stop
+			// waiting for them along with the HTTP server.
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			defer func() {
+				select {
+				case <-stopped:
+				case <-ctx.Done():
+					grpcServer.Stop()
+				}
+			}()
+		}
+
 		err := api.Shutdown(ctx)
 		if err != nil {
 			api.Close()
@@ -84,3 +294,31 @@ func run(ctx context.Context, log *slog.Logger) error {
 
 	return nil
 }
+
+// newVerifier returns a verifier of bearer tokens signed with any of the
+// configured keys: an HS256 secret, a PEM public key and the keys of a JWKS
+// file. It returns nil when none is configured.
+func newVerifier(secret, publicKey, jwks string, opts ...auth.VerifierOption) (*auth.Verifier, error) {
+	var keys []auth.JWK
+	if secret != "" {
+		keys = append(keys, auth.JWK{Alg: auth.AlgHS256, Key: []byte(secret)})
+	}
+	if publicKey != "" {
+		k, err := auth.LoadPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if jwks != "" {
+		ks, err := auth.LoadJWKS(jwks)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ks...)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return auth.NewVerifier(keys, opts...)
+}
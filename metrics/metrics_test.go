@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "route", "status")
+	latency := r.Histogram("latency_seconds", "Time to serve a request.", []float64{0.1, 1}, "route")
+	r.GaugeFunc("decks", "Decks in the store.", func() float64 { return 3 })
+
+	requests.Inc("GET /v1/decks/{deck_id}", "200")
+	requests.Add(2, "GET /v1/decks/{deck_id}", "200")
+	requests.Inc(`say "hi"`+"\n", "404")
+	latency.Observe(0.05, "a")
+	latency.Observe(0.1, "a")
+	latency.Observe(0.5, "a")
+	latency.Observe(3, "a")
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="GET /v1/decks/{deck_id}",status="200"} 3
+requests_total{route="say \"hi\"\n",status="404"} 1
+# HELP latency_seconds Time to serve a request.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="a",le="0.1"} 2
+latency_seconds_bucket{route="a",le="1"} 3
+latency_seconds_bucket{route="a",le="+Inf"} 4
+latency_seconds_sum{route="a"} 3.65
+latency_seconds_count{route="a"} 4
+# HELP decks Decks in the store.
+# TYPE decks gauge
+decks 3
+`
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, b.String())
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", b.Len(), n)
+	}
+
+	// Test series need a value for every label.
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for a missing label value")
+		}
+	}()
+	requests.Inc("GET /v1/decks/{deck_id}")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/deckclient"
+)
+
+// printer writes decks and cards in one of the output formats.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (printer, error) {
+	switch format {
+	case "table", "json", "glyph":
+		return printer{w: w, format: format}, nil
+	}
+	return printer{}, fmt.Errorf("unknown output format %q: %w", format, errUsage)
+}
+
+func (p printer) deck(d *deckclient.Deck) error {
+	switch p.format {
+	case "json":
+		return p.json(d)
+	case "glyph":
+		_, err := fmt.Fprintf(p.w, "%s shuffled=%t remaining=%d\n", d.DeckID, d.Shuffled, d.Remaining)
+		if err != nil || len(d.Cards) == 0 {
+			return err
+		}
+		return p.cards(d.Cards)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DECK ID\tSHUFFLED\tREMAINING")
+	fmt.Fprintf(tw, "%s\t%t\t%d\n", d.DeckID, d.Shuffled, d.Remaining)
+	err := tw.Flush()
+	if err != nil || len(d.Cards) == 0 {
+		return err
+	}
+	fmt.Fprintln(p.w)
+	return p.cards(d.Cards)
+}
+
+func (p printer) cards(cards []deck.Card) error {
+	switch p.format {
+	case "json":
+		return p.json(struct {
+			Cards []deck.Card `json:"cards"`
+		}{cards})
+	case "glyph":
+		glyphs := make([]string, len(cards))
+		for i, c := range cards {
+			glyphs[i] = c.Glyph()
+		}
+		_, err := fmt.Fprintln(p.w, strings.Join(glyphs, " "))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tCODE\tCARD")
+	for i, c := range cards {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", i+1, c.Code, c.Glyph())
+	}
+	return tw.Flush()
+}
+
+func (p printer) json(v any) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
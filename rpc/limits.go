@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/web"
+)
+
+// limits are the rate limit and deck quota of each client.
+type limits struct {
+	store      web.LimiterStore
+	rate       web.RateLimit
+	dailyDecks int
+}
+
+// WithLimits limits how often each client can call, and how many decks it
+// can create a UTC day, keeping count in store. Sharing store with the HTTP
+// API makes calls over both count against the same limits, those of the
+// routes without a limit of their own.
+func WithLimits(store web.LimiterStore, rate web.RateLimit, dailyDecks int) Option {
+	return func(s *server) {
+		s.limits = limits{store: store, rate: rate, dailyDecks: dailyDecks}
+	}
+}
+
+// clientKey identifies the client of a call the way the HTTP API does: by
+// its principal when it has authenticated, and by its IP address otherwise.
+func clientKey(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return p.ID()
+	}
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return "ip "
+	}
+	host, _, err := net.SplitHostPort(pr.Addr.String())
+	if err != nil {
+		return "ip " + pr.Addr.String()
+	}
+	return "ip " + host
+}
+
+// take takes a token from the bucket of the client of ctx, which it shares
+// with the HTTP routes without a limit of their own.
+func (l limits) take(ctx context.Context) error {
+	if l.store == nil || l.rate.Rate <= 0 {
+		return nil
+	}
+	_, ok := l.store.Take(clientKey(ctx)+" *", l.rate, time.Now())
+	if !ok {
+		return status.Error(codes.ResourceExhausted, "Too many requests, slow down")
+	}
+	return nil
+}
+
+// addDecks counts n new decks against the daily quota of the client of ctx.
+// A negative n gives them back.
+func (l limits) addDecks(ctx context.Context, n int) error {
+	if l.store == nil || l.dailyDecks <= 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	_, ok := l.store.Add(clientKey(ctx)+" decks "+now.Format(time.DateOnly), n, l.dailyDecks, tomorrow, now)
+	if !ok {
+		return status.Error(codes.ResourceExhausted, "Daily quota of new decks exceeded")
+	}
+	return nil
+}
+
+// decks returns how many decks a call to method creates.
+func decks(method string) int {
+	if strings.HasSuffix(method, "/CreateDeck") {
+		return 1
+	}
+	return 0
+}
+
+func newLimitInterceptor(l limits) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		err := l.take(ctx)
+		if err != nil {
+			return nil, err
+		}
+		n := decks(info.FullMethod)
+		if n == 0 {
+			return handler(ctx, req)
+		}
+		err = l.addDecks(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			l.addDecks(ctx, -n)
+		}
+		return resp, err
+	}
+}
+
+func newStreamLimitInterceptor(l limits) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := l.take(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,504 @@
+// Package rpc serves the deck operations over gRPC, next to the HTTP API.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/logging"
+	"github.com/mtpereira/deck/rpc/deckpb"
+	"github.com/mtpereira/deck/web"
+)
+
+type server struct {
+	deckpb.UnimplementedDeckServiceServer
+	da       *deck.DeckAPI
+	keyring  *auth.Keyring
+	verifier *auth.Verifier
+	limits   limits
+}
+
+// Option configures optional server features.
+type Option func(s *server)
+
+// WithKeyring makes clients authenticate with the API keys and share tokens
+// in kr, sent as x-api-key or x-share-token metadata, and limits each of them
+// to the decks it may use, like the HTTP API does.
+func WithKeyring(kr *auth.Keyring) Option {
+	return func(s *server) {
+		s.keyring = kr
+	}
+}
+
+// WithVerifier lets players authenticate with JWT bearer tokens verified by
+// v, sent as authorization metadata, and limits each of them to the scopes
+// and decks of their token, like the HTTP API does.
+func WithVerifier(v *auth.Verifier) Option {
+	return func(s *server) {
+		s.verifier = v
+	}
+}
+
+// NewServer returns a gRPC server with the deck service registered, backed
+// by the same DeckAPI as the HTTP handlers.
+func NewServer(log *slog.Logger, da *deck.DeckAPI, opts ...Option) *grpc.Server {
+	srv := &server{da: da}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(newTracingInterceptor(), newLoggerInterceptor(log), newAuthInterceptor(srv.keyring, srv.verifier), newLimitInterceptor(srv.limits)),
+		grpc.ChainStreamInterceptor(newStreamTracingInterceptor(), newStreamLoggerInterceptor(log), newStreamAuthInterceptor(srv.keyring, srv.verifier), newStreamLimitInterceptor(srv.limits)),
+	)
+	deckpb.RegisterDeckServiceServer(s, srv)
+	return s
+}
+
+// withRequestID returns a copy of ctx with the request ID in its
+// x-request-id metadata, or a new one.
+func withRequestID(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := uuid.NewString()
+	if vs := md.Get("x-request-id"); len(vs) > 0 && vs[0] != "" && len(vs[0]) <= 128 {
+		id = vs[0]
+	}
+	return logging.WithRequestID(ctx, id)
+}
+
+// recovered turns a panic of a call into an Internal error and logs it,
+// instead of taking the server down. It must be deferred.
+func recovered(ctx context.Context, log *slog.Logger, method string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	log.ErrorContext(ctx, "grpc", "request", method, "panic", v, "stack", string(debug.Stack()))
+	*err = status.Error(codes.Internal, "Internal error")
+}
+
+// newLoggerInterceptor logs every call, and recovers from its panics.
+func newLoggerInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		start := time.Now()
+		ctx = withRequestID(ctx)
+		defer func() {
+			log.InfoContext(ctx, "grpc", "request", info.FullMethod, "code", status.Code(err), "duration", time.Since(start))
+		}()
+		defer recovered(ctx, log, info.FullMethod, &err)
+		return handler(ctx, req)
+	}
+}
+
+func newStreamLoggerInterceptor(log *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		ctx := withRequestID(ss.Context())
+		defer func() {
+			log.InfoContext(ctx, "grpc", "request", info.FullMethod, "code", status.Code(err), "duration", time.Since(start))
+		}()
+		defer recovered(ctx, log, info.FullMethod, &err)
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate returns a copy of ctx with the principal of the API key, share
+// token or bearer token in its metadata. Without a keyring or a verifier ctx
+// is returned as it is.
+func authenticate(ctx context.Context, kr *auth.Keyring, v *auth.Verifier) (context.Context, error) {
+	if kr == nil && v == nil {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if vs := md.Get(key); len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+	bearer, _ := strings.CutPrefix(first("authorization"), "Bearer ")
+	p, err := auth.Authenticate(kr, v, first("x-api-key"), first("x-share-token"), bearer)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return auth.NewContext(ctx, p), nil
+}
+
+func newAuthInterceptor(kr *auth.Keyring, v *auth.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, kr, v)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func newStreamAuthInterceptor(kr *auth.Keyring, v *auth.Verifier) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), kr, v)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// contextStream is a stream with a context of its own, such as one with the
+// principal or the request ID in it.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize returns an error unless the principal in ctx has the scope for
+// need access, and need access to the deck. Without authentication every
+// access is granted.
+func (s *server) authorize(ctx context.Context, u uuid.UUID, need auth.Access) error {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	err := p.Need(need.Scope())
+	if err != nil {
+		return toStatus(err)
+	}
+	d, err := s.da.WithContext(ctx).Get(u)
+	if err != nil {
+		return toStatus(err)
+	}
+	err = p.Can(*d, need)
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// as returns the DeckAPI that records the principal in ctx as the actor of
+// the changes made with it, and logs with ctx.
+func (s *server) as(ctx context.Context) *deck.DeckAPI {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return s.da.WithContext(ctx)
+	}
+	return s.da.As(p.ID()).WithContext(ctx)
+}
+
+// grpcCodes maps the HTTP statuses of errors to the gRPC codes that mean the
+// same, so both APIs tell clients the same about an error.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusNotImplemented:      codes.Unimplemented,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusInsufficientStorage: codes.ResourceExhausted,
+}
+
+// toStatus maps errors to the gRPC code of the HTTP status they get.
+func toStatus(err error) error {
+	code, ok := grpcCodes[web.ErrorStatus(err)]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func parseDeckID(s string) (uuid.UUID, error) {
+	u, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, "Invalid deck ID")
+	}
+	return u, nil
+}
+
+func (s *server) CreateDeck(ctx context.Context, req *deckpb.CreateDeckRequest) (*deckpb.Deck, error) {
+	if len(req.Cards) > 52 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid cards")
+	}
+	var cards []deck.CardID
+	seen := make(map[deck.CardID]bool, len(req.Cards))
+	for _, code := range req.Cards {
+		c, err := deck.ParseCard(code)
+		if err != nil || seen[c] {
+			return nil, status.Error(codes.InvalidArgument, "Invalid cards")
+		}
+		seen[c] = true
+		cards = append(cards, c)
+	}
+
+	p, ok := auth.FromContext(ctx)
+	if ok {
+		err := p.CanCreate()
+		if err != nil {
+			return nil, toStatus(err)
+		}
+	}
+	d, err := s.as(ctx).NewOwned(p.Owner(), req.Shuffled, cards)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toDeck(d, viewer(ctx)), nil
+}
+
+func (s *server) GetDeck(ctx context.Context, req *deckpb.GetDeckRequest) (*deckpb.Deck, error) {
+	u, err := parseDeckID(req.DeckId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authorize(ctx, u, auth.AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	var d *deck.Deck
+	if req.At == 0 {
+		d, err = s.da.WithContext(ctx).Get(u)
+	} else {
+		d, err = s.da.WithContext(ctx).GetAt(u, req.At)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toDeck(d, viewer(ctx)), nil
+}
+
+func (s *server) DrawCards(ctx context.Context, req *deckpb.DrawCardsRequest) (*deckpb.DrawCardsResponse, error) {
+	u, err := parseDeckID(req.DeckId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authorize(ctx, u, auth.AccessDraw)
+	if err != nil {
+		return nil, err
+	}
+	if req.Count < 1 || req.Count > 52 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid number of cards to draw")
+	}
+	cards, err := s.as(ctx).Draw(u, int(req.Count))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &deckpb.DrawCardsResponse{Cards: toCards(deck.Expand(cards))}, nil
+}
+
+func (s *server) ShuffleDeck(ctx context.Context, req *deckpb.ShuffleDeckRequest) (*deckpb.Deck, error) {
+	u, err := parseDeckID(req.DeckId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authorize(ctx, u, auth.AccessOwner)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.as(ctx).Shuffle(u)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toDeck(d, viewer(ctx)), nil
+}
+
+func (s *server) DealCards(ctx context.Context, req *deckpb.DealCardsRequest) (*deckpb.Deck, error) {
+	u, err := parseDeckID(req.DeckId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authorize(ctx, u, auth.AccessOwner)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.as(ctx).Deal(u, req.Players, int(req.Count))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toDeck(d, viewer(ctx)), nil
+}
+
+func (s *server) PlayCard(ctx context.Context, req *deckpb.PlayCardRequest) (*deckpb.Deck, error) {
+	u, err := parseDeckID(req.DeckId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authorize(ctx, u, auth.AccessDraw)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := auth.FromContext(ctx); ok {
+		err = p.CanPlayAs(req.Player)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+	}
+	card, err := deck.ParseCard(req.Card)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	d, err := s.as(ctx).Play(u, req.Player, card, req.Pile)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toDeck(d, viewer(ctx)), nil
+}
+
+func (s *server) ListEvents(ctx context.Context, req *deckpb.ListEventsRequest) (*deckpb.ListEventsResponse, error) {
+	u, err := parseDeckID(req.DeckId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authorize(ctx, u, auth.AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.da.WithContext(ctx).History(u)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &deckpb.ListEventsResponse{}
+	v := viewer(ctx)
+	for _, e := range events {
+		resp.Events = append(resp.Events, toEvent(e, v))
+	}
+	return resp, nil
+}
+
+func (s *server) WatchEvents(req *deckpb.WatchEventsRequest, stream deckpb.DeckService_WatchEventsServer) error {
+	u, err := parseDeckID(req.DeckId)
+	if err != nil {
+		return err
+	}
+	_, err = s.da.WithContext(stream.Context()).Get(u)
+	if err != nil {
+		return toStatus(err)
+	}
+	err = s.authorize(stream.Context(), u, auth.AccessRead)
+	if err != nil {
+		return err
+	}
+	v := viewer(stream.Context())
+
+	// Subscribe before catching up, so no event falls in between.
+	events, unsubscribe := s.da.Subscribe(u)
+	defer unsubscribe()
+
+	last := req.AfterSeq
+	send := func(e deck.Event) error {
+		if e.Seq != 0 && e.Seq <= last {
+			return nil
+		}
+		if e.Seq != 0 {
+			last = e.Seq
+		}
+		return stream.Send(toEvent(e, v))
+	}
+
+	if req.AfterSeq > 0 {
+		missed, err := s.da.WithContext(stream.Context()).History(u)
+		if err != nil && !errors.Is(err, deck.ErrEventsDisabled) {
+			return toStatus(err)
+		}
+		for _, e := range missed {
+			err = send(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "Fell too far behind the deck events")
+			}
+			err = send(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// viewer returns who the responses to ctx show decks to: the player of the
+// principal, and everything to admins.
+func viewer(ctx context.Context) deck.Viewer {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return deck.Viewer{}
+	}
+	return deck.Viewer{Player: p.Player, All: p.Has(auth.ScopeAdmin)}
+}
+
+// toCards converts cards, leaving hidden ones without a code.
+func toCards(cards []deck.Card) []*deckpb.Card {
+	pb := make([]*deckpb.Card, len(cards))
+	for i, c := range cards {
+		pb[i] = &deckpb.Card{
+			Code:        c.Code,
+			Value:       c.Value,
+			Suit:        c.Suit,
+			Hidden:      c.Hidden,
+			Orientation: string(c.Orientation),
+		}
+	}
+	return pb
+}
+
+func toPiles(m map[string][]deck.Card) map[string]*deckpb.Cards {
+	if len(m) == 0 {
+		return nil
+	}
+	piles := make(map[string]*deckpb.Cards, len(m))
+	for name, cards := range m {
+		piles[name] = &deckpb.Cards{Cards: toCards(cards)}
+	}
+	return piles
+}
+
+func toDeck(d *deck.Deck, v deck.Viewer) *deckpb.Deck {
+	return &deckpb.Deck{
+		DeckId:    d.DeckID.String(),
+		Shuffled:  d.Shuffled,
+		Remaining: int32(d.Remaining),
+		Cards:     toCards(v.Cards(*d)),
+		Hands:     toPiles(v.Hands(*d)),
+		Piles:     toPiles(v.Piles(*d)),
+	}
+}
+
+// toEvent converts e as v sees it.
+func toEvent(e deck.Event, v deck.Viewer) *deckpb.Event {
+	e = v.Event(e)
+	return &deckpb.Event{
+		Seq:      e.Seq,
+		Time:     timestamppb.New(e.Time),
+		Type:     string(e.Type),
+		DeckId:   e.DeckID.String(),
+		Shuffled: e.Shuffled,
+		Count:    int32(e.Count),
+		Cards:    toCards(deck.Expand(e.Cards)),
+		Players:  e.Players,
+		Player:   e.Player,
+		Pile:     e.Pile,
+	}
+}
@@ -0,0 +1,291 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/rpc/deckpb"
+	"github.com/mtpereira/deck/web"
+)
+
+func TestServer(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(log, da)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	c := deckpb.NewDeckServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Test decks created over gRPC are shared with the DeckAPI.
+	d, err := c.CreateDeck(ctx, &deckpb.CreateDeckRequest{Cards: []string{"AS", "KH", "10D"}})
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	if d.Remaining != 3 || len(d.Cards) != 0 {
+		t.Errorf("Expected a deck of 3 hidden cards, got %v", d)
+	}
+	_, err = da.Draw(mustParse(t, d.DeckId), 1)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+
+	// Test events are streamed, missed ones first.
+	stream, err := c.WatchEvents(ctx, &deckpb.WatchEventsRequest{DeckId: d.DeckId, AfterSeq: 1})
+	if err != nil {
+		t.Fatalf("Failed to watch events: %v", err)
+	}
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive event: %v", err)
+	}
+	if e.Seq != 2 || e.Type != string(deck.EventCardsDrawn) {
+		t.Errorf("Expected the missed draw, got %v", e)
+	}
+	drawn, err := c.DrawCards(ctx, &deckpb.DrawCardsRequest{DeckId: d.DeckId, Count: 1})
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	if len(drawn.Cards) != 1 || drawn.Cards[0].Code != "KH" {
+		t.Errorf("Expected to draw KH, got %v", drawn.Cards)
+	}
+	e, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive event: %v", err)
+	}
+	if e.Seq != 3 || e.Count != 1 || len(e.Cards) != 0 {
+		t.Errorf("Expected the draw of 1 hidden card, got %v", e)
+	}
+
+	// Test deck errors map to gRPC codes.
+	_, err = c.DrawCards(ctx, &deckpb.DrawCardsRequest{DeckId: d.DeckId, Count: 2})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected %v, got %v", codes.InvalidArgument, err)
+	}
+	_, err = c.GetDeck(ctx, &deckpb.GetDeckRequest{DeckId: "not-a-uuid"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected %v, got %v", codes.InvalidArgument, err)
+	}
+	_, err = c.ShuffleDeck(ctx, &deckpb.ShuffleDeckRequest{DeckId: "14ca6cac-e933-4484-8e3f-e5acd505d11d"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected %v, got %v", codes.NotFound, err)
+	}
+
+	// Test cards in hands lie face down, hidden from others, and are face up
+	// once played.
+	dealt, err := c.DealCards(ctx, &deckpb.DealCardsRequest{DeckId: d.DeckId, Players: []string{"ann"}, Count: 1})
+	if err != nil {
+		t.Fatalf("Failed to deal cards: %v", err)
+	}
+	hand := dealt.Hands["ann"].GetCards()
+	if len(hand) != 1 || !hand[0].Hidden || hand[0].Code != "" || hand[0].Orientation != "face_down" {
+		t.Errorf("Expected a hidden card face down, got %v", hand)
+	}
+	played, err := c.PlayCard(ctx, &deckpb.PlayCardRequest{DeckId: d.DeckId, Player: "ann", Card: "10D", Pile: "table"})
+	if err != nil {
+		t.Fatalf("Failed to play card: %v", err)
+	}
+	pile := played.Piles["table"].GetCards()
+	if len(pile) != 1 || pile[0].Hidden || pile[0].Code != "10D" || pile[0].Orientation != "face_up" {
+		t.Errorf("Expected 10D face up, got %v", pile)
+	}
+
+	// Test the deck can be read back as it was.
+	at, err := c.GetDeck(ctx, &deckpb.GetDeckRequest{DeckId: d.DeckId, At: 1})
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if at.Remaining != 3 {
+		t.Errorf("Expected 3 cards at the first event, got %d", at.Remaining)
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	// Test errors get the gRPC code of their HTTP status.
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{deck.ErrDeckNotFound, codes.NotFound},
+		{deck.ErrUnsufficientCards, codes.InvalidArgument},
+		{deck.ErrCardNotInHand, codes.FailedPrecondition},
+		{deck.ErrCardNotInPlay, codes.FailedPrecondition},
+		{deck.ErrPileNotFound, codes.NotFound},
+		{deck.ErrHandNotFound, codes.NotFound},
+		{deck.ErrCardInDeck, codes.FailedPrecondition},
+		{deck.ErrInvalidDeck, codes.InvalidArgument},
+		{deck.ErrInvalidName, codes.InvalidArgument},
+		{deck.ErrStoreFull, codes.ResourceExhausted},
+		{deck.ErrDeckTooLarge, codes.ResourceExhausted},
+		{deck.ErrReadOnly, codes.Unavailable},
+		{deck.ErrEventsDisabled, codes.Unimplemented},
+		{deck.ErrNotFollower, codes.FailedPrecondition},
+		{auth.ErrUnauthenticated, codes.Unauthenticated},
+		{auth.ErrForbidden, codes.PermissionDenied},
+		{fmt.Errorf("deck 2: %w", deck.ErrInvalidDeck), codes.InvalidArgument},
+		{errors.New("Something broke"), codes.Internal},
+	}
+	for _, tt := range tests {
+		err := toStatus(tt.err)
+		if status.Code(err) != tt.code || status.Convert(err).Message() != tt.err.Error() {
+			t.Errorf("%v: expected %v, got %v", tt.err, tt.code, err)
+		}
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	kr := auth.NewKeyring()
+	kr.Put(auth.Key{ID: "ann"}, "ann-secret")
+	kr.Put(auth.Key{ID: "bob"}, "bob-secret")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(log, da, WithKeyring(kr))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	c := deckpb.NewDeckServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	as := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+	}
+
+	// Test clients need a key.
+	_, err = c.CreateDeck(ctx, &deckpb.CreateDeckRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected %v, got %v", codes.Unauthenticated, err)
+	}
+
+	// Test decks belong to the key that created them.
+	d, err := c.CreateDeck(as("ann-secret"), &deckpb.CreateDeckRequest{})
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	if owned, _ := da.Get(mustParse(t, d.DeckId)); owned.Owner != "ann" {
+		t.Errorf("Expected the deck to belong to ann, got %q", owned.Owner)
+	}
+	_, err = c.DrawCards(as("ann-secret"), &deckpb.DrawCardsRequest{DeckId: d.DeckId, Count: 1})
+	if err != nil {
+		t.Errorf("Expected the owner to draw, got %v", err)
+	}
+	_, err = c.GetDeck(as("bob-secret"), &deckpb.GetDeckRequest{DeckId: d.DeckId})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected %v, got %v", codes.PermissionDenied, err)
+	}
+
+	// Test share tokens grant only their access.
+	token, _, err := kr.Share(mustParse(t, d.DeckId), auth.AccessRead, "ann")
+	if err != nil {
+		t.Fatalf("Failed to share deck: %v", err)
+	}
+	shared := metadata.AppendToOutgoingContext(ctx, "x-share-token", token)
+	_, err = c.GetDeck(shared, &deckpb.GetDeckRequest{DeckId: d.DeckId})
+	if err != nil {
+		t.Errorf("Expected the share token to read, got %v", err)
+	}
+	_, err = c.DrawCards(shared, &deckpb.DrawCardsRequest{DeckId: d.DeckId, Count: 1})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected %v, got %v", codes.PermissionDenied, err)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	store := web.NewMemoryLimiterStore()
+	limit := web.RateLimit{Rate: 0.001, Burst: 3}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := NewServer(log, da, WithLimits(store, limit, 1))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	c := deckpb.NewDeckServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Test the daily quota of decks is kept.
+	d, err := c.CreateDeck(ctx, &deckpb.CreateDeckRequest{})
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = c.CreateDeck(ctx, &deckpb.CreateDeckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected %v over quota, got %v", codes.ResourceExhausted, err)
+	}
+
+	// Test the rate limit is shared with the HTTP API, which takes the last
+	// token of the client's address here.
+	if _, ok := store.Take("ip 127.0.0.1 *", limit, time.Now()); !ok {
+		t.Fatalf("Expected a token left for the HTTP API")
+	}
+	_, err = c.GetDeck(ctx, &deckpb.GetDeckRequest{DeckId: d.DeckId})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected %v once limited, got %v", codes.ResourceExhausted, err)
+	}
+}
+
+func TestLoggerInterceptorRecovers(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	intercept := newLoggerInterceptor(log)
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/deck.v1.DeckService/GetDeck"},
+		func(ctx context.Context, req any) (any, error) {
+			panic("boom")
+		})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected %v, got %v", codes.Internal, err)
+	}
+}
+
+func mustParse(t *testing.T, s string) uuid.UUID {
+	u, err := parseDeckID(s)
+	if err != nil {
+		t.Fatalf("Failed to parse deck ID: %v", err)
+	}
+	return u
+}
@@ -0,0 +1,95 @@
+// Package tracing sets up OpenTelemetry tracing: the W3C trace context
+// propagation and the exporters spans are sent to.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ServiceName is the service spans are reported by.
+const ServiceName = "deck"
+
+// Config says where spans are exported to. Spans aren't recorded when it has
+// no exporter, though trace context is still propagated.
+type Config struct {
+	// Endpoint is the host and port of an OTLP gRPC collector.
+	Endpoint string
+	// Insecure exports to Endpoint without TLS.
+	Insecure bool
+	// File is written the spans as JSON, one after the other, or to stdout
+	// when it's "-".
+	File string
+	// SampleRatio is the share of traces started here that are recorded.
+	// Traces started by callers are recorded if they were sampled.
+	SampleRatio float64
+}
+
+// Setup installs the W3C trace context propagator and, if cfg has an
+// exporter, a tracer provider exporting to it. The shutdown function it
+// returns flushes the spans that weren't exported yet.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Endpoint == "" && cfg.File == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("describe service: %w", err)
+	}
+	opts = append(opts, sdktrace.WithResource(res))
+
+	if cfg.Endpoint != "" {
+		grpcOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		}
+		exp, err := otlptracegrpc.New(ctx, grpcOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+
+	var file io.Closer
+	if cfg.File != "" {
+		var w io.Writer = os.Stdout
+		if cfg.File != "-" {
+			f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("open trace file: %w", err)
+			}
+			w, file = f, f
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, fmt.Errorf("create file exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+
+	// Test nothing is exported without an exporter.
+	shutdown, err := Setup(ctx, Config{})
+	if err != nil {
+		t.Fatalf("Failed to set up tracing: %v", err)
+	}
+	_, span := otel.Tracer("test").Start(ctx, "dropped")
+	if span.SpanContext().IsValid() {
+		t.Errorf("Expected spans not to be recorded without an exporter")
+	}
+	span.End()
+	err = shutdown(ctx)
+	if err != nil {
+		t.Errorf("Failed to shut tracing down: %v", err)
+	}
+
+	// Test spans are written to the file, with the service they come from,
+	// once tracing is shut down.
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err = Setup(ctx, Config{File: path, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("Failed to set up tracing: %v", err)
+	}
+	_, span = otel.Tracer("test").Start(ctx, "DeckAPI.Draw")
+	span.End()
+	err = shutdown(ctx)
+	if err != nil {
+		t.Errorf("Failed to shut tracing down: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read traces: %v", err)
+	}
+	for _, s := range []string{`"Name":"DeckAPI.Draw"`, `"Value":"deck"`} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("Expected %s in the traces, got %s", s, b)
+		}
+	}
+}
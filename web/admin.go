@@ -0,0 +1,76 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+func handleGetUsage(da *deck.DeckAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodeJSON(w, http.StatusOK, da.Usage())
+	})
+}
+
+// handleGetExport streams every deck in the store as newline delimited JSON.
+// Once the first deck is written the status can't change anymore, so an
+// error halfway through only truncates the stream.
+func handleGetExport(da *deck.DeckAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		da.Export(func(d deck.Deck) error {
+			err := enc.Encode(d)
+			if err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return r.Context().Err()
+		})
+	})
+}
+
+// handlePostImport loads a newline delimited JSON stream, as produced by
+// handleGetExport, into the store. Nothing is imported unless every deck in
+// the stream is valid.
+func handlePostImport(da *deck.DeckAPI) http.Handler {
+	type importResponse struct {
+		Imported int `json:"imported"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var decks []deck.Deck
+		dec := json.NewDecoder(r.Body)
+		for {
+			var d deck.Deck
+			err := dec.Decode(&d)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				respondProblem(w, r, invalidRequest(fmt.Sprintf("/body/%d", len(decks)), fmt.Sprintf("Invalid deck %d: %v", len(decks)+1, err)))
+				return
+			}
+			decks = append(decks, d)
+		}
+
+		n, err := forRequest(r.Context(), da).Import(decks)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+
+		encodeJSON(w, http.StatusOK, importResponse{Imported: n})
+	})
+}
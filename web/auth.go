@@ -0,0 +1,307 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+const (
+	apiKeyHeader     = "X-API-Key"
+	shareTokenHeader = "X-Share-Token"
+)
+
+var errAuthDisabled = errors.New("API keys are not enabled")
+
+// WithKeyring makes clients authenticate with the API keys and share tokens
+// in kr, and limits each of them to the decks it may use. Without a keyring
+// or a verifier every client can do anything.
+func WithKeyring(kr *auth.Keyring) Option {
+	return func(cfg *config) {
+		cfg.keyring = kr
+	}
+}
+
+// WithVerifier lets players authenticate with JWT bearer tokens verified by
+// v, and limits each of them to the scopes and decks of their token.
+func WithVerifier(v *auth.Verifier) Option {
+	return func(cfg *config) {
+		cfg.verifier = v
+	}
+}
+
+// newAuthMiddleware answers 401 to requests without a valid API key, share
+// token or bearer token, and gives the handler the principal of the others.
+// Addresses that fail too often get 429 for up to a minute, whatever they
+// send. Without a keyring or a verifier requests go straight to the
+// handler.
+func newAuthMiddleware(log *slog.Logger, cfg config) func(h http.Handler) http.Handler {
+	kr, v := cfg.keyring, cfg.verifier
+	logins := loginLimiter{store: cfg.limiterStore, attempts: cfg.loginAttempts}
+	return func(h http.Handler) http.Handler {
+		if kr == nil && v == nil {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !logins.allow(r) {
+				log.InfoContext(r.Context(), "api", "auth", "limited", "client", remoteHost(r))
+				w.Header().Set("Retry-After", "60")
+				respondProblem(w, r, errLoginsLimited)
+				return
+			}
+			bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			p, err := auth.Authenticate(kr, v, r.Header.Get(apiKeyHeader), r.Header.Get(shareTokenHeader), bearer)
+			if err != nil {
+				logins.fail(r)
+				log.InfoContext(r.Context(), "api", "auth", "unauthenticated", "request", r.URL.Path)
+				respondProblem(w, r, err)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), p)))
+		})
+	}
+}
+
+// newAccessMiddleware answers 403 to requests whose principal check rejects.
+// Without authentication every request goes straight to the handler.
+func newAccessMiddleware(check func(r *http.Request, p auth.Principal) error) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := auth.FromContext(r.Context())
+			if ok {
+				err := check(r, p)
+				if err != nil {
+					respondProblem(w, r, err)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// deckAccess checks the principal has the scope for need access, and need
+// access to the deck in the path. Invalid and missing decks are left for the
+// handler to report.
+func deckAccess(da *deck.DeckAPI, need auth.Access) func(r *http.Request, p auth.Principal) error {
+	return func(r *http.Request, p auth.Principal) error {
+		err := p.Need(need.Scope())
+		if err != nil {
+			return err
+		}
+		deckID, err := uuid.Parse(r.PathValue("deck_id"))
+		if err != nil {
+			return nil
+		}
+		d, err := da.WithContext(r.Context()).Get(deckID)
+		if err != nil {
+			return nil
+		}
+		return p.Can(*d, need)
+	}
+}
+
+// createAccess checks the principal may create decks.
+func createAccess(r *http.Request, p auth.Principal) error {
+	return p.CanCreate()
+}
+
+// adminAccess checks the principal has the deck:admin scope.
+func adminAccess(r *http.Request, p auth.Principal) error {
+	return p.CanAdmin()
+}
+
+// authorize returns ErrForbidden unless the principal in ctx has need access
+// to d. Without authentication every access is granted.
+func authorize(ctx context.Context, d *deck.Deck, need auth.Access) error {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return p.Can(*d, need)
+}
+
+// playAs returns ErrForbidden unless the principal in ctx may hold and play
+// the cards of player.
+func playAs(ctx context.Context, player string) error {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return p.CanPlayAs(player)
+}
+
+// viewer returns who the response to r shows d to: the player of the
+// principal, or the one in the player parameter if the principal owns d, since
+// players only see their own hand. Admins see every card. Without
+// authentication anyone can take any seat, but sees no more than its player.
+// d is nil for responses about several decks.
+func viewer(r *http.Request, d *deck.Deck) (deck.Viewer, error) {
+	player := r.URL.Query().Get("player")
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		return deck.Viewer{Player: player}, nil
+	}
+	v := deck.Viewer{Player: p.Player, All: p.Has(auth.ScopeAdmin)}
+	if player != "" && player != p.Player {
+		if d == nil || p.Access(*d) < auth.AccessOwner {
+			return deck.Viewer{}, auth.ErrForbidden
+		}
+		v.Player = player
+	}
+	return v, nil
+}
+
+// owner returns the key or player new decks created with ctx belong to.
+func owner(ctx context.Context) string {
+	p, _ := auth.FromContext(ctx)
+	return p.Owner()
+}
+
+// actor returns who the events of the changes made with ctx are recorded as
+// made by, empty without authentication.
+func actor(ctx context.Context) string {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return p.ID()
+}
+
+// forRequest returns a DeckAPI that records the actor of ctx in the events of
+// its changes and passes ctx on to the store, so it logs the request ID.
+func forRequest(ctx context.Context, da *deck.DeckAPI) *deck.DeckAPI {
+	return da.As(actor(ctx)).WithContext(ctx)
+}
+
+type keyResponse struct {
+	ID    string `json:"id"`
+	Admin bool   `json:"admin"`
+	// Key is the secret of the key, only returned when it's created.
+	Key string `json:"key,omitempty"`
+}
+
+func handlePostKey(kr *auth.Keyring) http.Handler {
+	type keyRequest struct {
+		ID    string `json:"id"`
+		Admin bool   `json:"admin"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if kr == nil {
+			respondProblem(w, r, errAuthDisabled)
+			return
+		}
+		var req keyRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			respondProblem(w, r, invalidRequest("/body", "Invalid key"))
+			return
+		}
+		k := auth.Key{ID: req.ID, Admin: req.Admin}
+		secret, err := kr.Add(k)
+		if errors.Is(err, auth.ErrInvalidKeyID) {
+			respondProblem(w, r, invalidRequest("/body/id", err.Error()))
+			return
+		}
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		encodeJSON(w, http.StatusCreated, keyResponse{ID: k.ID, Admin: k.Admin, Key: secret})
+	})
+}
+
+func handleGetKeys(kr *auth.Keyring) http.Handler {
+	type keysResponse struct {
+		Keys []keyResponse `json:"keys"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if kr == nil {
+			respondProblem(w, r, errAuthDisabled)
+			return
+		}
+		keys := []keyResponse{}
+		for _, k := range kr.Keys() {
+			keys = append(keys, keyResponse{ID: k.ID, Admin: k.Admin})
+		}
+		encodeJSON(w, http.StatusOK, keysResponse{Keys: keys})
+	})
+}
+
+func handleDeleteKey(kr *auth.Keyring) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if kr == nil {
+			respondProblem(w, r, errAuthDisabled)
+			return
+		}
+		err := kr.Delete(r.PathValue("key_id"))
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func handlePostShare(da *deck.DeckAPI, kr *auth.Keyring) http.Handler {
+	type shareRequest struct {
+		Access string `json:"access"`
+	}
+	type shareResponse struct {
+		DeckID uuid.UUID `json:"deck_id"`
+		Access string    `json:"access"`
+		Token  string    `json:"token"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if kr == nil {
+			respondProblem(w, r, errAuthDisabled)
+			return
+		}
+		deckID, err := uuid.Parse(r.PathValue("deck_id"))
+		if err != nil {
+			respondProblem(w, r, invalidParameter("/path/deck_id", "Invalid deck ID"))
+			return
+		}
+		var req shareRequest
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			respondProblem(w, r, invalidRequest("/body", "Invalid share"))
+			return
+		}
+		access, err := auth.ParseShareAccess(req.Access)
+		if err != nil {
+			respondProblem(w, r, invalidRequest("/body/access", "Access must be read or draw"))
+			return
+		}
+
+		d, err := da.WithContext(r.Context()).Get(deckID)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		// Share tokens go away with the key that made them, so players
+		// can't make any.
+		p, _ := auth.FromContext(r.Context())
+		if p.KeyID == "" {
+			respondProblem(w, r, auth.ErrForbidden)
+			return
+		}
+		token, _, err := kr.Share(d.DeckID, access, p.KeyID)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		encodeJSON(w, http.StatusCreated, shareResponse{DeckID: d.DeckID, Access: access.String(), Token: token})
+	})
+}
@@ -0,0 +1,344 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestAuth(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	kr := auth.NewKeyring()
+	kr.Put(auth.Key{ID: "ops", Admin: true}, "ops-secret")
+	kr.Put(auth.Key{ID: "ann"}, "ann-secret")
+	kr.Put(auth.Key{ID: "bob"}, "bob-secret")
+	mux := NewMux(log, da, WithKeyring(kr))
+
+	send := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	code := func(rr *httptest.ResponseRecorder) string {
+		var p problem
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		return p.Code
+	}
+
+	// Test requests without a valid key are rejected.
+	for _, header := range [][]string{nil, {apiKeyHeader, "nope"}, {shareTokenHeader, "nope"}} {
+		rr := send("POST", "/v1/decks", "", header...)
+		if rr.Code != http.StatusUnauthorized || code(rr) != "unauthenticated" {
+			t.Errorf("%v: expected 401 unauthenticated, got %d: %s", header, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Test decks belong to the key that created them.
+	rr := send("POST", "/v1/decks", "", apiKeyHeader, "ann-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var d struct {
+		DeckID string `json:"deck_id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &d)
+	deckPath := "/v1/decks/" + d.DeckID
+	for _, c := range []struct {
+		key    string
+		status int
+	}{
+		{"ann-secret", http.StatusOK},
+		{"bob-secret", http.StatusForbidden},
+		{"ops-secret", http.StatusOK},
+	} {
+		rr := send("GET", deckPath, "", apiKeyHeader, c.key)
+		if rr.Code != c.status {
+			t.Errorf("%s: expected %d, got %d", c.key, c.status, rr.Code)
+		}
+	}
+	if rr := send("POST", deckPath+"/cards/1", "", apiKeyHeader, "bob-secret"); code(rr) != "forbidden" {
+		t.Errorf("Expected another key's draw to be forbidden, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Test batches can't reach into other keys' decks.
+	batch := fmt.Sprintf(`{"operations":[{"op":"create"},{"op":"shuffle","deck_id":"%s"}]}`, d.DeckID)
+	if rr := send("POST", "/v1/batch", batch, apiKeyHeader, "bob-secret", "Content-Type", "application/json"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected the batch to be forbidden, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Test share tokens grant read or draw access to their deck only.
+	tokens := map[string]string{}
+	for _, access := range []string{"read", "draw"} {
+		rr := send("POST", deckPath+"/shares", fmt.Sprintf(`{"access":%q}`, access), apiKeyHeader, "ann-secret", "Content-Type", "application/json")
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var s struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &s)
+		tokens[access] = s.Token
+	}
+	if rr := send("POST", deckPath+"/shares", `{"access":"read"}`, apiKeyHeader, "bob-secret", "Content-Type", "application/json"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected only the owner to share, got %d", rr.Code)
+	}
+	for _, c := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", deckPath, "read", http.StatusOK},
+		{"GET", deckPath + "/events", "read", http.StatusNotImplemented},
+		{"POST", deckPath + "/cards/1", "read", http.StatusForbidden},
+		{"POST", deckPath + "/cards/1", "draw", http.StatusOK},
+		{"POST", deckPath + "/shuffle", "draw", http.StatusForbidden},
+		{"POST", deckPath + "/shares", "draw", http.StatusForbidden},
+		{"POST", "/v1/decks", "draw", http.StatusForbidden},
+		{"GET", "/v1/admin/usage", "draw", http.StatusForbidden},
+	} {
+		body := ""
+		if strings.HasSuffix(c.path, "/shares") {
+			body = `{"access":"read"}`
+		}
+		rr := send(c.method, c.path, body, shareTokenHeader, tokens[c.token], "Content-Type", "application/json")
+		if rr.Code != c.status {
+			t.Errorf("%s %s with a %s token: expected %d, got %d", c.method, c.path, c.token, c.status, rr.Code)
+		}
+	}
+
+	// Test only admins manage keys, and new keys work right away.
+	if rr := send("GET", "/v1/admin/keys", "", apiKeyHeader, "ann-secret"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rr.Code)
+	}
+	rr = send("POST", "/v1/admin/keys", `{"id":"cat"}`, apiKeyHeader, "ops-secret", "Content-Type", "application/json")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var k keyResponse
+	json.Unmarshal(rr.Body.Bytes(), &k)
+	if rr := send("POST", "/v1/decks", "", apiKeyHeader, k.Key); rr.Code != http.StatusOK {
+		t.Errorf("Expected the new key to create a deck, got %d", rr.Code)
+	}
+
+	// Test deleting a key revokes its share tokens.
+	if rr := send("DELETE", "/v1/admin/keys/ann", "", apiKeyHeader, "ops-secret"); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rr.Code)
+	}
+	if rr := send("GET", deckPath, "", shareTokenHeader, tokens["read"]); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rr.Code)
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	// Test everything is open without a keyring, but keys can't be managed.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da)
+
+	req := httptest.NewRequest("GET", "/v1/admin/usage", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rr.Code)
+	}
+	req = httptest.NewRequest("GET", "/v1/admin/keys", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501, got %d", rr.Code)
+	}
+}
+
+func TestAuthBearer(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	secret := []byte("the lobby secret of thirty-two bytes")
+	v, err := auth.NewVerifier([]auth.JWK{{Alg: auth.AlgHS256, Key: secret}})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	mux := NewMux(log, da, WithVerifier(v))
+
+	token := func(player, scope string, decks ...string) string {
+		return bearerToken(secret, player, scope, decks...)
+	}
+	send := func(method, path, body, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	ann := token("ann", "deck:read deck:draw")
+	rr := send("POST", "/v1/decks", "", ann)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var d struct {
+		DeckID string `json:"deck_id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &d)
+	deckPath := "/v1/decks/" + d.DeckID
+
+	// Test every route enforces the scopes of the token, and players only
+	// reach their own decks and the ones their token lists.
+	for _, c := range []struct {
+		name, method, path, body, token string
+		status                          int
+	}{
+		{"no token", "GET", deckPath, "", "", http.StatusUnauthorized},
+		{"invalid token", "GET", deckPath, "", "nope", http.StatusUnauthorized},
+		{"owner", "POST", deckPath + "/shuffle", "", ann, http.StatusOK},
+		{"read scope", "GET", deckPath, "", token("ann", "deck:read"), http.StatusOK},
+		{"read scope drawing", "POST", deckPath + "/cards/1", "", token("ann", "deck:read"), http.StatusForbidden},
+		{"read scope creating", "POST", "/v1/decks", "", token("ann", "deck:read"), http.StatusForbidden},
+		{"draw scope reading", "GET", deckPath, "", token("ann", "deck:draw"), http.StatusForbidden},
+		{"other player", "GET", deckPath, "", token("bob", "deck:read deck:draw"), http.StatusForbidden},
+		{"listed deck", "POST", deckPath + "/cards/1", "", token("bob", "deck:read deck:draw", d.DeckID), http.StatusOK},
+		{"listed deck shuffling", "POST", deckPath + "/shuffle", "", token("bob", "deck:read deck:draw", d.DeckID), http.StatusForbidden},
+		{"missing deck without scope", "GET", "/v1/decks/" + uuid.NewString(), "", token("ann", "deck:draw"), http.StatusForbidden},
+		{"admin route", "GET", "/v1/admin/usage", "", ann, http.StatusForbidden},
+		{"admin scope", "GET", "/v1/admin/usage", "", token("ops", "deck:admin"), http.StatusOK},
+		{"admin scope on any deck", "GET", deckPath, "", token("ops", "deck:admin"), http.StatusOK},
+		{"sharing", "POST", deckPath + "/shares", `{"access":"read"}`, ann, http.StatusNotImplemented},
+		{"playing as someone else", "POST", "/v1/batch", fmt.Sprintf(`{"operations":[
+			{"op":"deal","deck_id":%q,"players":["ann","bob"],"count":1},
+			{"op":"play","deck_id":%[1]q,"player":"bob","card":"AS","pile":"discard"}]}`, d.DeckID), ann, http.StatusForbidden},
+	} {
+		rr := send(c.method, c.path, c.body, c.token)
+		if rr.Code != c.status {
+			t.Errorf("%s: expected %d, got %d: %s", c.name, c.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Test events record the player who made each change.
+	rr = send("GET", deckPath+"/events", "", ann)
+	var events struct {
+		Events []deck.Event `json:"events"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &events)
+	actors := []string{}
+	for _, e := range events.Events {
+		actors = append(actors, e.Actor)
+	}
+	if !slices.Equal(actors, []string{"player ann", "player ann", "player bob"}) {
+		t.Errorf("Expected the events to be made by ann, ann and bob, got %v", actors)
+	}
+}
+
+func TestDeckViews(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	kr := auth.NewKeyring()
+	kr.Put(auth.Key{ID: "ops", Admin: true}, "ops-secret")
+	kr.Put(auth.Key{ID: "lobby"}, "lobby-secret")
+	secret := []byte("the lobby secret of thirty-two bytes")
+	v, _ := auth.NewVerifier([]auth.JWK{{Alg: auth.AlgHS256, Key: secret}})
+	mux := NewMux(log, da, WithKeyring(kr), WithVerifier(v))
+
+	d, _ := da.NewOwned("lobby", true, nil)
+	da.Deal(d.DeckID, []string{"ann", "bob"}, 2)
+	token, _, _ := kr.Share(d.DeckID, auth.AccessRead, "lobby")
+	deckPath := "/v1/decks/" + d.DeckID.String()
+	ann := bearerToken(secret, "ann", "deck:read deck:draw", d.DeckID.String())
+
+	get := func(path string, header ...string) (int, deckView) {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		var view deckView
+		json.Unmarshal(rr.Body.Bytes(), &view)
+		return rr.Code, view
+	}
+	hidden := func(cards []deck.Card) bool {
+		return len(cards) == 2 && cards[0].Hidden && cards[0].Code == "" && cards[1].Hidden
+	}
+
+	// Test players only see their own hand, and only admins see the deck.
+	for _, c := range []struct {
+		name             string
+		path             string
+		header           []string
+		status           int
+		cards            int
+		annSeen, bobSeen bool
+	}{
+		{"player", deckPath, []string{"Authorization", "Bearer " + ann}, http.StatusOK, 0, true, false},
+		{"player as another", deckPath + "?player=bob", []string{"Authorization", "Bearer " + ann}, http.StatusForbidden, 0, false, false},
+		{"owner", deckPath, []string{apiKeyHeader, "lobby-secret"}, http.StatusOK, 0, false, false},
+		{"owner as a player", deckPath + "?player=bob", []string{apiKeyHeader, "lobby-secret"}, http.StatusOK, 0, false, true},
+		{"share token", deckPath, []string{shareTokenHeader, token}, http.StatusOK, 0, false, false},
+		{"share token as a player", deckPath + "?player=ann", []string{shareTokenHeader, token}, http.StatusForbidden, 0, false, false},
+		{"admin", deckPath, []string{apiKeyHeader, "ops-secret"}, http.StatusOK, 48, true, true},
+	} {
+		status, view := get(c.path, c.header...)
+		if status != c.status {
+			t.Errorf("%s: expected %d, got %d", c.name, c.status, status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		if view.Remaining != 48 || len(view.Cards) != c.cards {
+			t.Errorf("%s: expected 48 cards left, %d of them shown, got %d and %v", c.name, c.cards, view.Remaining, view.Cards)
+		}
+		if hidden(view.Hands["ann"]) == c.annSeen || hidden(view.Hands["bob"]) == c.bobSeen {
+			t.Errorf("%s: expected ann's hand seen %t and bob's %t, got %v", c.name, c.annSeen, c.bobSeen, view.Hands)
+		}
+	}
+
+	// Test events only count the cards the viewer can't see.
+	req := httptest.NewRequest("GET", deckPath+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+ann)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	var events struct {
+		Events []deck.Event `json:"events"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &events)
+	if len(events.Events) != 2 || events.Events[0].Cards != nil || events.Events[0].Count != 52 || events.Events[1].Cards != nil {
+		t.Errorf("Expected the created and dealt cards to be hidden, got %s", rr.Body.String())
+	}
+}
+
+// bearerToken returns a JWT for player with scope, signed with secret.
+func bearerToken(secret []byte, player, scope string, decks ...string) string {
+	claims, _ := json.Marshal(map[string]any{
+		"sub":   player,
+		"scope": scope,
+		"decks": decks,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	input := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
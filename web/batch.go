@@ -0,0 +1,356 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+var errInvalidReference = errors.New("Invalid reference")
+var errInvalidOperation = errors.New("Invalid operation")
+
+// batchOperation is one operation of a batch. String fields can reference the
+// results of earlier operations as "$<index>.<path>", such as "$0.deck_id" or
+// "$1.cards.0.code".
+type batchOperation struct {
+	Op       string   `json:"op"`
+	DeckID   string   `json:"deck_id"`
+	Shuffled bool     `json:"shuffled"`
+	Cards    []string `json:"cards"`
+	Count    int      `json:"count"`
+	Players  []string `json:"players"`
+	Player   string   `json:"player"`
+	Card     string   `json:"card"`
+	Pile     string   `json:"pile"`
+}
+
+// batchError is the error of the operation at index.
+type batchError struct {
+	index int
+	err   error
+}
+
+func (e batchError) Error() string {
+	return e.err.Error()
+}
+
+func (e batchError) Unwrap() error {
+	return e.err
+}
+
+func handlePostBatch(da *deck.DeckAPI) http.Handler {
+	type batchRequest struct {
+		Operations []batchOperation `json:"operations"`
+	}
+	type batchResponse struct {
+		Results []any `json:"results"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req batchRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || len(req.Operations) == 0 {
+			respondProblem(w, r, invalidRequest("/body", "Invalid batch"))
+			return
+		}
+
+		v, err := viewer(r, nil)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+
+		var results []any
+		err = forRequest(r.Context(), da).Batch(func(tx *deck.DeckAPI) error {
+			// Earlier results are referenced through their JSON form, so
+			// operations can't reference cards the viewer can't see.
+			var refs []any
+			for i, op := range req.Operations {
+				result, err := runBatchOperation(r.Context(), tx, op, refs, v)
+				if err != nil {
+					return batchError{index: i, err: err}
+				}
+				results = append(results, result)
+
+				b, err := json.Marshal(result)
+				if err != nil {
+					return batchError{index: i, err: err}
+				}
+				var ref any
+				err = json.Unmarshal(b, &ref)
+				if err != nil {
+					return batchError{index: i, err: err}
+				}
+				refs = append(refs, ref)
+			}
+			return nil
+		})
+		if err != nil {
+			var be batchError
+			if errors.As(err, &be) {
+				err = &requestError{
+					err:        be.err,
+					detail:     fmt.Sprintf("Operation %d failed: %s", be.index, be.err),
+					violations: []violation{{fmt.Sprintf("/body/operations/%d", be.index), be.err.Error()}},
+				}
+			}
+			respondProblem(w, r, err)
+			return
+		}
+		encodeJSON(w, http.StatusOK, batchResponse{Results: results})
+	})
+}
+
+// runBatchOperation runs op against tx, resolving its references to the
+// results of the operations before it, if the principal in ctx may. The
+// result shows the decks to v.
+func runBatchOperation(ctx context.Context, tx *deck.DeckAPI, op batchOperation, refs []any, v deck.Viewer) (any, error) {
+	type cardsResponse struct {
+		Cards []deck.Card `json:"cards"`
+	}
+
+	var d *deck.Deck
+	switch op.Op {
+	case "create":
+		var cards []deck.CardID
+		var err error
+		if op.Cards != nil {
+			cards, err = resolveCards(op.Cards, refs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		d, err = tx.NewOwned(owner(ctx), op.Shuffled, cards)
+		if err != nil {
+			return nil, err
+		}
+		return newDeckView(d, v), nil
+	case "draw":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessDraw)
+		if err != nil {
+			return nil, err
+		}
+		if op.Count < 1 || op.Count > 52 {
+			return nil, fmt.Errorf("%w: invalid number of cards to draw", errInvalidOperation)
+		}
+		cards, err := tx.Draw(deckID, op.Count)
+		if err != nil {
+			return nil, err
+		}
+		return cardsResponse{Cards: deck.Expand(cards)}, nil
+	case "deal":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessOwner)
+		if err != nil {
+			return nil, err
+		}
+		players := make([]string, len(op.Players))
+		for i, p := range op.Players {
+			players[i], err = resolve(p, refs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if op.Count < 1 || op.Count > 52 {
+			return nil, fmt.Errorf("%w: invalid number of cards to deal", errInvalidOperation)
+		}
+		d, err = tx.Deal(deckID, players, op.Count)
+		if err != nil {
+			return nil, err
+		}
+	case "play":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessDraw)
+		if err != nil {
+			return nil, err
+		}
+		cards, err := resolveCards([]string{op.Card}, refs)
+		if err != nil {
+			return nil, err
+		}
+		player, err := resolve(op.Player, refs)
+		if err != nil {
+			return nil, err
+		}
+		err = playAs(ctx, player)
+		if err != nil {
+			return nil, err
+		}
+		pile, err := resolve(op.Pile, refs)
+		if err != nil {
+			return nil, err
+		}
+		d, err = tx.Play(deckID, player, cards[0], pile)
+		if err != nil {
+			return nil, err
+		}
+	case "return":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessOwner)
+		if err != nil {
+			return nil, err
+		}
+		cards, err := resolveCards(op.Cards, refs)
+		if err != nil {
+			return nil, err
+		}
+		d, err = tx.Return(deckID, cards)
+		if err != nil {
+			return nil, err
+		}
+	case "shuffle":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessOwner)
+		if err != nil {
+			return nil, err
+		}
+		d, err = tx.Shuffle(deckID)
+		if err != nil {
+			return nil, err
+		}
+	case "flip":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessDraw)
+		if err != nil {
+			return nil, err
+		}
+		pile, err := resolve(op.Pile, refs)
+		if err != nil {
+			return nil, err
+		}
+		if op.Card == "" {
+			d, err = tx.FlipTop(deckID, pile)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		cards, err := resolveCards([]string{op.Card}, refs)
+		if err != nil {
+			return nil, err
+		}
+		// Players only name the cards in their own hand, and the ones lying
+		// face up in piles.
+		player, err := resolve(op.Player, refs)
+		if err != nil {
+			return nil, err
+		}
+		err = playAs(ctx, player)
+		if err != nil {
+			return nil, err
+		}
+		d, err = tx.FlipCardAs(deckID, player, cards[0])
+		if err != nil {
+			return nil, err
+		}
+	case "flip_pile":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessDraw)
+		if err != nil {
+			return nil, err
+		}
+		pile, err := resolve(op.Pile, refs)
+		if err != nil {
+			return nil, err
+		}
+		d, err = tx.FlipPile(deckID, pile)
+		if err != nil {
+			return nil, err
+		}
+	case "reveal":
+		deckID, err := resolveDeckID(ctx, tx, op.DeckID, refs, auth.AccessDraw)
+		if err != nil {
+			return nil, err
+		}
+		player, err := resolve(op.Player, refs)
+		if err != nil {
+			return nil, err
+		}
+		err = playAs(ctx, player)
+		if err != nil {
+			return nil, err
+		}
+		d, err = tx.RevealHand(deckID, player)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", errInvalidOperation, op.Op)
+	}
+	return newDeckView(d, v), nil
+}
+
+// resolveDeckID resolves the ID of a deck the principal in ctx needs access
+// to.
+func resolveDeckID(ctx context.Context, tx *deck.DeckAPI, s string, refs []any, need auth.Access) (uuid.UUID, error) {
+	s, err := resolve(s, refs)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	deckID, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: invalid deck ID", errInvalidOperation)
+	}
+	d, err := tx.Get(deckID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	err = authorize(ctx, d, need)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return deckID, nil
+}
+
+func resolveCards(codes []string, refs []any) ([]deck.CardID, error) {
+	cards := make([]deck.CardID, len(codes))
+	for i, code := range codes {
+		code, err := resolve(code, refs)
+		if err != nil {
+			return nil, err
+		}
+		cards[i], err = deck.ParseCard(code)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cards, nil
+}
+
+// resolve returns s, or the string it references if it starts with "$".
+func resolve(s string, refs []any) (string, error) {
+	if !strings.HasPrefix(s, "$") {
+		return s, nil
+	}
+	path := strings.Split(s[1:], ".")
+	i, err := strconv.Atoi(path[0])
+	if err != nil || i < 0 || i >= len(refs) {
+		return "", fmt.Errorf("%w %q: no earlier operation %s", errInvalidReference, s, path[0])
+	}
+
+	v := refs[i]
+	for _, name := range path[1:] {
+		switch o := v.(type) {
+		case map[string]any:
+			v = o[name]
+		case []any:
+			n, err := strconv.Atoi(name)
+			if err != nil || n < 0 || n >= len(o) {
+				return "", fmt.Errorf("%w %q: no item %s", errInvalidReference, s, name)
+			}
+			v = o[n]
+		default:
+			v = nil
+		}
+		if v == nil {
+			return "", fmt.Errorf("%w %q: no %s", errInvalidReference, s, name)
+		}
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w %q: not a string", errInvalidReference, s)
+	}
+	return str, nil
+}
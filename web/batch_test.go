@@ -0,0 +1,164 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestBatch(t *testing.T) {
+	// Test operations can use the results of earlier ones.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da)
+
+	batch := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/v1/batch?player=ann", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := batch(`{"operations":[
+		{"op":"create"},
+		{"op":"deal","deck_id":"$0.deck_id","players":["ann","bob"],"count":2},
+		{"op":"play","deck_id":"$0.deck_id","player":"ann","card":"$1.hands.ann.0.code","pile":"discard"},
+		{"op":"draw","deck_id":"$0.deck_id","count":3},
+		{"op":"return","deck_id":"$0.deck_id","cards":["$3.cards.0.code","$2.piles.discard.0.code"]}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Results []struct {
+			deckView
+			Cards []deck.Card `json:"cards"`
+		} `json:"results"`
+	}
+	err := json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(resp.Results))
+	}
+	last := resp.Results[4]
+	if last.Remaining != 47 || len(last.Piles) != 0 || len(last.Hands["ann"]) != 1 || len(last.Hands["bob"]) != 2 {
+		t.Errorf("Expected 47 cards, no piles, and hands of 1 and 2 cards, got %+v", last)
+	}
+	if len(last.Cards) != 0 || last.Hands["ann"][0].Hidden || !last.Hands["bob"][0].Hidden {
+		t.Errorf("Expected ann to only see her own hand, got %+v", last)
+	}
+	final, err := da.Get(last.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	returned := []deck.Card{resp.Results[3].Cards[0], resp.Results[2].Piles["discard"][0]}
+	returned[1].Orientation = ""
+	if bottom := deck.Expand(final.Cards[45:]); !reflect.DeepEqual(bottom, returned) {
+		t.Errorf("Expected %v at the bottom of the deck, got %v", returned, bottom)
+	}
+
+	// Test a failed operation rolls back the batch and is pointed at.
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	rr = batch(`{"operations":[
+		{"op":"draw","deck_id":"` + d.DeckID.String() + `","count":50},
+		{"op":"create"},
+		{"op":"draw","deck_id":"$1.deck_id","count":1},
+		{"op":"draw","deck_id":"` + d.DeckID.String() + `","count":3}
+	]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var errResp problem
+	err = json.NewDecoder(rr.Body).Decode(&errResp)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(errResp.Errors) != 1 || errResp.Errors[0].Pointer != "/body/operations/3" {
+		t.Errorf("Expected the error to point at /body/operations/3, got %v", errResp.Errors)
+	}
+	if errResp.Code != "insufficient_cards" || *errResp.Remaining != 2 || *errResp.Requested != 3 {
+		t.Errorf("Expected insufficient_cards with 2 remaining and 3 requested, got %s", rr.Body.String())
+	}
+	u, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if u.Remaining != 52 || da.Usage().Decks != 2 {
+		t.Errorf("Expected 52 cards and 2 decks after the rollback, got %d and %d", u.Remaining, da.Usage().Decks)
+	}
+
+	// Test cards can be turned over, even those no one can name.
+	rr = batch(`{"operations":[
+		{"op":"deal","deck_id":"` + d.DeckID.String() + `","players":["ann","bob"],"count":2},
+		{"op":"play","deck_id":"` + d.DeckID.String() + `","player":"ann","card":"2C","pile":"stock"},
+		{"op":"flip_pile","deck_id":"` + d.DeckID.String() + `","pile":"stock"},
+		{"op":"reveal","deck_id":"` + d.DeckID.String() + `","player":"bob"},
+		{"op":"flip","deck_id":"` + d.DeckID.String() + `","player":"bob","card":"$3.hands.bob.0.code"},
+		{"op":"flip","deck_id":"` + d.DeckID.String() + `","pile":"stock"}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+	resp.Results = nil
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if pile := resp.Results[2].Piles["stock"]; len(pile) != 1 || !pile[0].Hidden {
+		t.Errorf("Expected the stock face down, got %v", pile)
+	}
+	bob := resp.Results[4].Hands["bob"]
+	if len(bob) != 2 || !bob[0].Hidden || bob[0].Orientation != deck.FaceDown || bob[1].Code != "5C" || bob[1].Orientation != deck.FaceUp {
+		t.Errorf("Expected bob's 3C hidden from ann again and 5C face up, got %v", bob)
+	}
+	if pile := resp.Results[5].Piles["stock"]; len(pile) != 1 || pile[0].Code != "2C" || pile[0].Orientation != deck.FaceUp {
+		t.Errorf("Expected the 2C face up, got %v", pile)
+	}
+
+	// Test players can't tell where the cards they can't flip are: in
+	// another hand, in the deck or face down in a pile.
+	d2, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	rr = batch(`{"operations":[
+		{"op":"deal","deck_id":"` + d2.DeckID.String() + `","players":["ann","bob"],"count":2},
+		{"op":"play","deck_id":"` + d2.DeckID.String() + `","player":"ann","card":"2C","pile":"stock"},
+		{"op":"flip_pile","deck_id":"` + d2.DeckID.String() + `","pile":"stock"}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var bodies []string
+	for _, card := range []string{"4C", "AS", "2C"} {
+		rr = batch(`{"operations":[{"op":"flip","deck_id":"` + d2.DeckID.String() + `","player":"bob","card":"` + card + `"}]}`)
+		if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), `"code":"card_not_in_hand"`) {
+			t.Errorf("%s: expected a card_not_in_hand problem, got %d: %s", card, rr.Code, rr.Body.String())
+		}
+		bodies = append(bodies, rr.Body.String())
+	}
+	if bodies[0] != bodies[1] || bodies[1] != bodies[2] {
+		t.Errorf("Expected the same problem for every card, got %v", bodies)
+	}
+
+	// Test references must point at earlier operations.
+	rr = batch(`{"operations":[{"op":"shuffle","deck_id":"$0.deck_id"}]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+)
+
+const (
+	defaultMaxBodySize   = 1 << 20
+	defaultMaxImportSize = 64 << 20
+)
+
+var errBodyTooLarge = errors.New("Request body is too large")
+
+// WithMaxBodySize sets the largest request body, in bytes, of every route
+// but imports. Zero doesn't limit them.
+func WithMaxBodySize(n int64) Option {
+	return func(cfg *config) {
+		cfg.maxBodySize = n
+	}
+}
+
+// WithMaxImportSize sets the largest body, in bytes, of imports. Zero
+// doesn't limit them.
+func WithMaxImportSize(n int64) Option {
+	return func(cfg *config) {
+		cfg.maxImportSize = n
+	}
+}
+
+// newBodyLimitMiddleware stops reading request bodies after limit bytes, so
+// the middlewares that buffer them, and the handlers, can't be made to use
+// up the server's memory. Reading past it fails with errBodyTooLarge
+// through readBody.
+func newBodyLimitMiddleware(limit int64) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if limit <= 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readBody reads the whole body of r, leaving it for the next reader.
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, errBodyTooLarge
+		}
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
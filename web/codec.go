@@ -0,0 +1,708 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+// Responses are written as JSON by the handlers and transcoded into the
+// negotiated format, going through a tree of nil, bool, json.Number, string,
+// []any and object values. Objects keep the order of their members, so every
+// format lists them in the same order as JSON.
+
+// object is a JSON object with its members in order.
+type object []member
+
+type member struct {
+	key   string
+	value any
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// codec reads and writes trees in a media type.
+type codec struct {
+	contentType string
+	encode      func(w io.Writer, v any) error
+	decode      func(r io.Reader) (any, error)
+}
+
+var errMalformed = errors.New("malformed body")
+
+var jsonCodec = codec{
+	contentType: "application/json",
+	encode: func(w io.Writer, v any) error {
+		return json.NewEncoder(w).Encode(v)
+	},
+	decode: func(r io.Reader) (any, error) {
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return decodeJSONValue(dec)
+	},
+}
+
+func decodeJSONValue(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		o := object{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, member{k.(string), v})
+		}
+		_, err = dec.Token()
+		return o, err
+	case json.Delim('['):
+		a := []any{}
+		for dec.More() {
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err = dec.Token()
+		return a, err
+	}
+	return t, nil
+}
+
+// cborCodec encodes trees as CBOR, defined by RFC 8949. Byte strings and
+// maps with keys other than text can't be decoded, since JSON has no such
+// values.
+var cborCodec = codec{
+	contentType: "application/cbor",
+	encode: func(w io.Writer, v any) error {
+		var buf bytes.Buffer
+		err := encodeCBOR(&buf, v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	},
+	decode: func(r io.Reader) (any, error) {
+		return decodeCBOR(bufio.NewReader(r), 0)
+	},
+}
+
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(major | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func encodeCBOR(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n < 0 {
+				cborHead(buf, 1, uint64(-1-n))
+			} else {
+				cborHead(buf, 0, uint64(n))
+			}
+			return nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			cborHead(buf, 0, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xfb)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	case string:
+		cborHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case []any:
+		cborHead(buf, 4, uint64(len(v)))
+		for _, item := range v {
+			err := encodeCBOR(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+	case object:
+		cborHead(buf, 5, uint64(len(v)))
+		for _, m := range v {
+			encodeCBOR(buf, m.key)
+			err := encodeCBOR(buf, m.value)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can't encode %T", v)
+	}
+	return nil
+}
+
+// cborBreak ends the items of an indefinite length value.
+type cborBreak struct{}
+
+func decodeCBOR(r *bufio.Reader, depth int) (any, error) {
+	v, err := decodeCBORItem(r, depth)
+	if _, ok := v.(cborBreak); ok {
+		return nil, errMalformed
+	}
+	return v, err
+}
+
+func decodeCBORItem(r *bufio.Reader, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: too deeply nested", errMalformed)
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	major, info := b>>5, b&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			n, err := readUint(r, 2)
+			return floatNumber(halfToFloat(uint16(n)), err)
+		case 26:
+			n, err := readUint(r, 4)
+			return floatNumber(float64(math.Float32frombits(uint32(n))), err)
+		case 27:
+			n, err := readUint(r, 8)
+			return floatNumber(math.Float64frombits(n), err)
+		case 31:
+			return cborBreak{}, nil
+		}
+		return nil, errMalformed
+	}
+
+	indefinite := info == 31
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		n, err = readUint(r, 1<<(info-24))
+		if err != nil {
+			return nil, err
+		}
+	case indefinite && major >= 2 && major <= 5:
+	default:
+		return nil, errMalformed
+	}
+
+	switch major {
+	case 0:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return floatNumber(-1-float64(n), nil)
+		}
+		return json.Number(strconv.FormatInt(-1-int64(n), 10)), nil
+	case 2:
+		return nil, fmt.Errorf("%w: byte strings aren't supported", errMalformed)
+	case 3:
+		if !indefinite {
+			return readString(r, n)
+		}
+		var sb strings.Builder
+		for {
+			chunk, err := decodeCBORItem(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := chunk.(cborBreak); ok {
+				return sb.String(), nil
+			}
+			s, ok := chunk.(string)
+			if !ok {
+				return nil, errMalformed
+			}
+			sb.WriteString(s)
+		}
+	case 4:
+		a := []any{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			item, err := decodeCBORItem(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := item.(cborBreak); ok {
+				if !indefinite {
+					return nil, errMalformed
+				}
+				break
+			}
+			a = append(a, item)
+		}
+		return a, nil
+	case 5:
+		o := object{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			k, err := decodeCBORItem(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := k.(cborBreak); ok && indefinite {
+				break
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: map keys must be text", errMalformed)
+			}
+			v, err := decodeCBOR(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, member{key, v})
+		}
+		return o, nil
+	}
+	// Tags only annotate the item that follows them.
+	return decodeCBOR(r, depth+1)
+}
+
+// halfToFloat converts an IEEE 754 half precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// msgpackCodec encodes trees as MessagePack. Binary and extension values
+// can't be decoded, since JSON has no such values.
+var msgpackCodec = codec{
+	contentType: "application/msgpack",
+	encode: func(w io.Writer, v any) error {
+		var buf bytes.Buffer
+		err := encodeMsgpack(&buf, v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	},
+	decode: func(r io.Reader) (any, error) {
+		return decodeMsgpack(bufio.NewReader(r), 0)
+	},
+}
+
+// msgpackHead writes the header of a string, array or map of length n, given
+// its fixed size prefix and the prefixes of its 8, 16 and 32 bit lengths.
+// Arrays and maps have no 8 bit length, and pass 0 for it.
+func msgpackHead(buf *bytes.Buffer, fix byte, fixMax int, p8, p16, p32 byte, n int) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case p8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{p8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(p16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(p32)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func encodeMsgpack(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			switch {
+			case n >= 0 && n <= 0x7f, n < 0 && n >= -32:
+				buf.WriteByte(byte(n))
+			case n >= 0 && n <= math.MaxUint8:
+				buf.Write([]byte{0xcc, byte(n)})
+			case n >= 0 && n <= math.MaxUint16:
+				buf.WriteByte(0xcd)
+				buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+			case n >= 0 && n <= math.MaxUint32:
+				buf.WriteByte(0xce)
+				buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+			default:
+				buf.WriteByte(0xd3)
+				buf.Write(binary.BigEndian.AppendUint64(nil, uint64(n)))
+			}
+			return nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			buf.Write(binary.BigEndian.AppendUint64(nil, n))
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	case string:
+		msgpackHead(buf, 0xa0, 31, 0xd9, 0xda, 0xdb, len(v))
+		buf.WriteString(v)
+	case []any:
+		msgpackHead(buf, 0x90, 15, 0, 0xdc, 0xdd, len(v))
+		for _, item := range v {
+			err := encodeMsgpack(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+	case object:
+		msgpackHead(buf, 0x80, 15, 0, 0xde, 0xdf, len(v))
+		for _, m := range v {
+			encodeMsgpack(buf, m.key)
+			err := encodeMsgpack(buf, m.value)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can't encode %T", v)
+	}
+	return nil
+}
+
+func decodeMsgpack(r *bufio.Reader, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: too deeply nested", errMalformed)
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return json.Number(strconv.Itoa(int(b))), nil
+	case b >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(b)))), nil
+	case b >= 0xa0 && b <= 0xbf:
+		return readString(r, uint64(b&0x1f))
+	case b >= 0x90 && b <= 0x9f:
+		return decodeMsgpackArray(r, uint64(b&0x0f), depth)
+	case b >= 0x80 && b <= 0x8f:
+		return decodeMsgpackMap(r, uint64(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		n, err := readUint(r, 4)
+		return floatNumber(float64(math.Float32frombits(uint32(n))), err)
+	case 0xcb:
+		n, err := readUint(r, 8)
+		return floatNumber(math.Float64frombits(n), err)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readUint(r, 1<<(b-0xcc))
+		return json.Number(strconv.FormatUint(n, 10)), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := readUint(r, size)
+		// Sign extend from the size of the value.
+		shift := 64 - 8*size
+		return json.Number(strconv.FormatInt(int64(n<<shift)>>shift, 10)), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := readUint(r, 1<<(b-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, n, depth)
+	case 0xde, 0xdf:
+		n, err := readUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, n, depth)
+	}
+	return nil, fmt.Errorf("%w: binary and extension values aren't supported", errMalformed)
+}
+
+func decodeMsgpackArray(r *bufio.Reader, n uint64, depth int) (any, error) {
+	a := []any{}
+	for i := uint64(0); i < n; i++ {
+		item, err := decodeMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, item)
+	}
+	return a, nil
+}
+
+func decodeMsgpackMap(r *bufio.Reader, n uint64, depth int) (any, error) {
+	o := object{}
+	for i := uint64(0); i < n; i++ {
+		k, err := decodeMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: map keys must be strings", errMalformed)
+		}
+		v, err := decodeMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		o = append(o, member{key, v})
+	}
+	return o, nil
+}
+
+func readUint(r io.Reader, size int) (uint64, error) {
+	b := make([]byte, 8)
+	_, err := io.ReadFull(r, b[8-size:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// maxDepth bounds how deeply values of a body can be nested.
+const maxDepth = 64
+
+// maxStringLength bounds the strings a body can claim to have, so a short
+// body can't make the server allocate a lot of memory.
+const maxStringLength = 1 << 20
+
+func readString(r io.Reader, n uint64) (any, error) {
+	if n > maxStringLength {
+		return nil, fmt.Errorf("%w: string is too long", errMalformed)
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func floatNumber(f float64, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%w: %v isn't a JSON number", errMalformed, f)
+	}
+	if f == 0 {
+		// Negative zero would be encoded back as the integer 0.
+		f = 0
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// textCodec renders trees for people to read, with cards as glyphs such as
+// "A♠ K♥ 10♦". It can't decode.
+var textCodec = codec{
+	contentType: "text/plain; charset=utf-8",
+	encode: func(w io.Writer, v any) error {
+		var buf bytes.Buffer
+		writeText(&buf, v, "")
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	},
+}
+
+// writeText writes v with its lines after the first indented. Responses with
+// a single member, such as {"cards": [...]}, are written as that member.
+func writeText(buf *bytes.Buffer, v any, indent string) {
+	if o, ok := v.(object); ok && len(o) == 1 && indent == "" {
+		v = o[0].value
+	}
+	if s, ok := inlineText(v); ok {
+		buf.WriteString(s)
+		return
+	}
+
+	switch v := v.(type) {
+	case object:
+		for i, m := range v {
+			if i > 0 {
+				buf.WriteString("\n" + indent)
+			}
+			buf.WriteString(m.key + ":")
+			if s, ok := inlineText(m.value); ok {
+				if s != "" {
+					buf.WriteString(" " + s)
+				}
+				continue
+			}
+			buf.WriteString("\n" + indent + "  ")
+			writeText(buf, m.value, indent+"  ")
+		}
+	case []any:
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteString("\n" + indent)
+			}
+			buf.WriteString("- ")
+			writeText(buf, item, indent+"  ")
+		}
+	}
+}
+
+// inlineText returns v as a single line, if it fits in one: scalars, cards
+// and lists of them.
+func inlineText(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case bool:
+		return strconv.FormatBool(v), true
+	case json.Number:
+		return v.String(), true
+	case string:
+		return v, true
+	case object:
+		return cardGlyph(v)
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, ok := item.([]any); ok {
+				return "", false
+			}
+			s, ok := inlineText(item)
+			if !ok {
+				return "", false
+			}
+			items[i] = s
+		}
+		return strings.Join(items, " "), true
+	}
+	return "", false
+}
+
+// cardBack is the glyph of a card the client can't see.
+const cardBack = "🂠"
+
+// cardGlyph returns the glyph of a card: an object with a code and nothing
+// but its value, suit and orientation, or one that is only hidden.
+func cardGlyph(o object) (string, bool) {
+	var code string
+	var hidden bool
+	for _, m := range o {
+		switch m.key {
+		case "code":
+			code, _ = m.value.(string)
+		case "hidden":
+			hidden, _ = m.value.(bool)
+		case "value", "suit", "orientation":
+		default:
+			return "", false
+		}
+	}
+	switch {
+	case code != "":
+		return deck.Card{Code: code}.Glyph(), true
+	case hidden:
+		return cardBack, true
+	}
+	return "", false
+}
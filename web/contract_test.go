@@ -0,0 +1,262 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+// routeRecorder keeps track of every pattern registered by addRoutes.
+type routeRecorder struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (rr *routeRecorder) Handle(pattern string, handler http.Handler) {
+	rr.patterns = append(rr.patterns, pattern)
+	rr.ServeMux.Handle(pattern, handler)
+}
+
+func TestContract(t *testing.T) {
+	spec, err := loadSpec()
+	if err != nil {
+		t.Fatalf("Failed to load the API spec: %v", err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	kr := auth.NewKeyring()
+	err = kr.Put(auth.Key{ID: "admin", Admin: true}, "secret")
+	if err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	mux := &routeRecorder{ServeMux: http.NewServeMux()}
+	addRoutes(mux, log, da, WithKeyring(kr))
+
+	// Test every route is described by the spec.
+	specMux := http.NewServeMux()
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			pattern := strings.ToUpper(method) + " " + path
+			specMux.HandleFunc(pattern, func(http.ResponseWriter, *http.Request) {})
+			if !slices.Contains(mux.patterns, pattern) {
+				t.Errorf("Spec describes %s, but there's no route for it", pattern)
+			}
+		}
+	}
+	for _, pattern := range mux.patterns {
+		method, path, _ := strings.Cut(pattern, " ")
+		if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("Route %s isn't described by the spec", pattern)
+		}
+	}
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	var export bytes.Buffer
+	err = da.Export(func(d deck.Deck) error {
+		return json.NewEncoder(&export).Encode(d)
+	})
+	if err != nil {
+		t.Fatalf("Failed to export decks: %v", err)
+	}
+	missing := uuid.New()
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		key    string
+		status int
+	}{
+		{"POST", "/v1/decks", "", "", http.StatusOK},
+		{"POST", "/v1/decks?shuffled=true", `[{"code":"AS"},{"code":"10H"}]`, "", http.StatusOK},
+		{"POST", "/v1/decks?shuffled=maybe", "", "", http.StatusBadRequest},
+		{"POST", "/v1/decks", `[{"code":"AS"},{"code":"AS"}]`, "", http.StatusBadRequest},
+		{"GET", fmt.Sprintf("/v1/decks/%s", d.DeckID), "", "", http.StatusOK},
+		{"GET", fmt.Sprintf("/v1/decks/%s?at=1", d.DeckID), "", "", http.StatusOK},
+		{"GET", "/v1/decks/not-a-uuid", "", "", http.StatusBadRequest},
+		{"GET", fmt.Sprintf("/v1/decks/%s", missing), "", "", http.StatusNotFound},
+		{"GET", fmt.Sprintf("/v1/decks/%s/events", d.DeckID), "", "", http.StatusOK},
+		{"GET", fmt.Sprintf("/v1/decks/%s/events", missing), "", "", http.StatusNotFound},
+		{"GET", fmt.Sprintf("/v1/decks/%s/events/stream", d.DeckID), "", "", http.StatusOK},
+		{"GET", fmt.Sprintf("/v1/decks/%s/events/stream", missing), "", "", http.StatusNotFound},
+		// The table channel is tested over a WebSocket in table_test.go.
+		{"GET", fmt.Sprintf("/v1/decks/%s/table", d.DeckID), "", "", http.StatusBadRequest},
+		{"POST", fmt.Sprintf("/v1/decks/%s/cards/2", d.DeckID), "", "", http.StatusOK},
+		{"POST", fmt.Sprintf("/v1/decks/%s/cards/53", d.DeckID), "", "", http.StatusBadRequest},
+		{"POST", fmt.Sprintf("/v1/decks/%s/cards/0", d.DeckID), "", "", http.StatusBadRequest},
+		{"POST", fmt.Sprintf("/v1/decks/%s/cards/1", missing), "", "", http.StatusNotFound},
+		{"POST", fmt.Sprintf("/v1/decks/%s/draw/1", d.DeckID), "", "", http.StatusOK},
+		{"POST", fmt.Sprintf("/v1/decks/%s/cards/1", d.DeckID), "", "retry", http.StatusOK},
+		{"POST", fmt.Sprintf("/v1/decks/%s/cards/1", d.DeckID), "", "retry", http.StatusOK},
+		{"POST", fmt.Sprintf("/v1/decks/%s/cards/2", d.DeckID), "", "retry", http.StatusUnprocessableEntity},
+		{"POST", fmt.Sprintf("/v1/decks/%s/shuffle", d.DeckID), "", "", http.StatusOK},
+		{"POST", "/v1/decks/not-a-uuid/shuffle", "", "", http.StatusBadRequest},
+		{"POST", fmt.Sprintf("/v1/decks/%s/shuffle", missing), "", "", http.StatusNotFound},
+		{"POST", fmt.Sprintf("/v1/decks/%s/shares", d.DeckID), `{"access":"read"}`, "", http.StatusCreated},
+		{"POST", fmt.Sprintf("/v1/decks/%s/shares", d.DeckID), `{"access":"owner"}`, "", http.StatusBadRequest},
+		{"POST", fmt.Sprintf("/v1/decks/%s/shares", missing), `{"access":"draw"}`, "", http.StatusNotFound},
+		{"POST", "/v1/batch", `{"operations":[{"op":"create"},{"op":"draw","deck_id":"$0.deck_id","count":2},{"op":"return","deck_id":"$0.deck_id","cards":["$1.cards.0.code"]}]}`, "", http.StatusOK},
+		{"POST", "/v1/batch", `{"operations":[{"op":"cheat"}]}`, "", http.StatusBadRequest},
+		{"POST", "/v1/batch", fmt.Sprintf(`{"operations":[{"op":"shuffle","deck_id":"%s"}]}`, missing), "", http.StatusNotFound},
+		{"GET", "/v1/admin/usage", "", "", http.StatusOK},
+		{"GET", "/v1/admin/export", "", "", http.StatusOK},
+		{"POST", "/v1/admin/import", export.String(), "", http.StatusOK},
+		{"POST", "/v1/admin/import", `{"deck_id":"not-a-uuid"}`, "", http.StatusBadRequest},
+		{"POST", "/v1/admin/keys", `{"id":"lobby"}`, "", http.StatusCreated},
+		{"POST", "/v1/admin/keys", `{"id":"lobby"}`, "", http.StatusConflict},
+		{"POST", "/v1/admin/keys", `{"id":"no spaces"}`, "", http.StatusBadRequest},
+		{"GET", "/v1/admin/keys", "", "", http.StatusOK},
+		{"DELETE", "/v1/admin/keys/lobby", "", "", http.StatusNoContent},
+		{"DELETE", "/v1/admin/keys/lobby", "", "", http.StatusNotFound},
+		{"GET", "/v1/replication/log?since=0", "", "", http.StatusOK},
+		{"GET", "/v1/replication/status", "", "", http.StatusOK},
+		{"POST", "/v1/replication/promote", "", "", http.StatusConflict},
+		{"GET", "/openapi.yaml", "", "", http.StatusOK},
+		{"GET", "/openapi.json", "", "", http.StatusOK},
+		{"GET", "/docs", "", "", http.StatusOK},
+		{"GET", "/metrics", "", "", http.StatusOK},
+		{"GET", "/problems/deck_not_found", "", "", http.StatusOK},
+		{"GET", "/problems/nope", "", "", http.StatusNotFound},
+	}
+
+	covered := map[string]bool{}
+	for _, c := range cases {
+		name := c.method + " " + c.path
+		// The replication log and event streams run until the client goes
+		// away.
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		req, err := http.NewRequestWithContext(ctx, c.method, c.path, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if c.key != "" {
+			req.Header.Set("Idempotency-Key", c.key)
+		}
+		req.Header.Set("X-API-Key", "secret")
+		_, pattern := specMux.Handler(req)
+		method, path, _ := strings.Cut(pattern, " ")
+		op, ok := spec.Paths[path][strings.ToLower(method)]
+		if !ok {
+			t.Errorf("%s: no operation in the spec", name)
+			cancel()
+			continue
+		}
+		covered[pattern] = true
+
+		// Test the request matches the spec, unless it's meant to be invalid.
+		var violations []violation
+		for _, p := range op.Parameters {
+			var raw string
+			switch p.In {
+			case "path":
+				raw = pathValue(path, req.URL.Path, p.Name)
+			case "query":
+				raw = req.URL.Query().Get(p.Name)
+			case "header":
+				raw = req.Header.Get(p.Name)
+			}
+			if raw == "" {
+				if p.Required {
+					violations = append(violations, violation{fmt.Sprintf("/%s/%s", p.In, p.Name), "is required"})
+				}
+				continue
+			}
+			violations = append(violations, spec.validateParam(p, raw)...)
+		}
+		if c.body != "" {
+			if op.RequestBody == nil {
+				t.Errorf("%s: spec doesn't take a request body", name)
+			} else {
+				for contentType, mt := range op.RequestBody.Content {
+					violations = append(violations, spec.validateBody(contentType, mt, []byte(c.body))...)
+				}
+			}
+		}
+		if c.status == http.StatusBadRequest && len(violations) == 0 {
+			t.Errorf("%s: expected the request to violate the spec", name)
+		}
+		if c.status != http.StatusBadRequest && len(violations) > 0 {
+			t.Errorf("%s: request violates the spec: %v", name, violations)
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		cancel()
+
+		if rr.Code != c.status {
+			t.Errorf("%s: expected %d, got %d: %s", name, c.status, rr.Code, rr.Body.String())
+			continue
+		}
+
+		// Test the response matches the spec.
+		resp, ok := op.Responses[strconv.Itoa(rr.Code)]
+		if !ok {
+			t.Errorf("%s: spec doesn't describe a %d response", name, rr.Code)
+			continue
+		}
+		for header := range resp.Headers {
+			if rr.Header().Get(header) == "" {
+				t.Errorf("%s: response is missing the %s header", name, header)
+			}
+		}
+		if rr.Body.Len() == 0 && len(resp.Content) == 0 {
+			continue
+		}
+		contentType, _, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+		mt, ok := resp.Content[contentType]
+		if !ok {
+			t.Errorf("%s: spec doesn't describe %s responses", name, contentType)
+			continue
+		}
+		body := rr.Body.Bytes()
+		if contentType == contentTypeNDJSON && rr.Body.Len() == 0 {
+			continue
+		}
+		for _, v := range spec.validateBody(contentType, mt, body) {
+			t.Errorf("%s: response violates the spec: %s", name, v)
+		}
+	}
+
+	// Test every operation in the spec was checked.
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			pattern := strings.ToUpper(method) + " " + path
+			if !covered[pattern] {
+				t.Errorf("%s isn't covered by the contract test", pattern)
+			}
+		}
+	}
+}
+
+// pathValue extracts a path parameter by matching a path against the spec
+// path template it belongs to.
+func pathValue(template, path, name string) string {
+	segments := strings.Split(template, "/")
+	values := strings.Split(path, "/")
+	for i, s := range segments {
+		if s == "{"+name+"}" && i < len(values) {
+			return values[i]
+		}
+	}
+	return ""
+}
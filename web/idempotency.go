@@ -0,0 +1,255 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mtpereira/deck/auth"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentResponse is the first response to a request with an idempotency
+// key, replayed to every retry of it.
+type idempotentResponse struct {
+	client      string
+	fingerprint [sha256.Size]byte
+	done        bool
+	expires     time.Time
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// idempotencyStore keeps the responses to requests with an idempotency key
+// for a window, scoped to the client and the deck they were sent to.
+type idempotencyStore struct {
+	window time.Duration
+	// max is the most responses kept, and maxPerClient the most kept for one
+	// client. Once there are as many, the client's responses closest to
+	// expiring make room for its new ones, or those of the client with the
+	// most responses when the whole store is full, so a client sending many
+	// keys only forgets its own responses.
+	max          int
+	maxPerClient int
+	responses    map[string]*idempotentResponse
+	clients      map[string]int
+	swept        time.Time
+	mu           sync.Mutex
+}
+
+// defaultMaxIdempotentResponses bounds the memory idempotency keys can take,
+// since any client can send as many as it likes.
+const defaultMaxIdempotentResponses = 10000
+
+// defaultMaxIdempotentResponsesPerClient bounds the share of the store one
+// client can take.
+const defaultMaxIdempotentResponsesPerClient = 1000
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		window:       window,
+		max:          defaultMaxIdempotentResponses,
+		maxPerClient: defaultMaxIdempotentResponsesPerClient,
+		responses:    make(map[string]*idempotentResponse),
+		clients:      make(map[string]int),
+	}
+}
+
+// start returns the response stored for a client's key, or reserves the key
+// for a new request and returns nil.
+func (s *idempotencyStore) start(client, key string, fingerprint [sha256.Size]byte, now time.Time) *idempotentResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.swept) > time.Minute || len(s.responses) >= s.max || s.clients[client] >= s.maxPerClient {
+		for k, resp := range s.responses {
+			if resp.done && now.After(resp.expires) {
+				s.delete(k)
+			}
+		}
+		s.swept = now
+	}
+
+	scoped := client + " " + key
+	resp, ok := s.responses[scoped]
+	if ok && (!resp.done || now.Before(resp.expires)) {
+		return resp
+	}
+	if !ok {
+		switch {
+		case s.clients[client] >= s.maxPerClient:
+			s.evict(client)
+		case len(s.responses) >= s.max:
+			s.evict(s.largestClient())
+		}
+		s.clients[client]++
+	}
+	s.responses[scoped] = &idempotentResponse{client: client, fingerprint: fingerprint}
+	return nil
+}
+
+// evict drops the stored response of a client closest to expiring. Requests
+// still in progress are kept, as they're bounded by the requests being
+// served.
+func (s *idempotencyStore) evict(client string) {
+	var oldest string
+	for k, resp := range s.responses {
+		if resp.client == client && resp.done && (oldest == "" || resp.expires.Before(s.responses[oldest].expires)) {
+			oldest = k
+		}
+	}
+	if oldest != "" {
+		s.delete(oldest)
+	}
+}
+
+// largestClient returns the client with the most stored responses.
+func (s *idempotencyStore) largestClient() string {
+	var largest string
+	for client, n := range s.clients {
+		if n > s.clients[largest] {
+			largest = client
+		}
+	}
+	return largest
+}
+
+// delete drops a stored response.
+func (s *idempotencyStore) delete(scoped string) {
+	resp, ok := s.responses[scoped]
+	if !ok {
+		return
+	}
+	delete(s.responses, scoped)
+	s.clients[resp.client]--
+	if s.clients[resp.client] == 0 {
+		delete(s.clients, resp.client)
+	}
+}
+
+// finish stores the response to a request started with start. Server errors,
+// quota and rate limit errors and requests that never got a response aren't
+// stored, so retries get another chance.
+func (s *idempotencyStore) finish(client, key string, rec *idempotencyRecorder, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scoped := client + " " + key
+	if rec.status == 0 || rec.status == http.StatusTooManyRequests || rec.status >= http.StatusInternalServerError {
+		s.delete(scoped)
+		return
+	}
+	resp := s.responses[scoped]
+	resp.done = true
+	resp.expires = now.Add(s.window)
+	resp.status = rec.status
+	resp.header = rec.header
+	resp.body = rec.body.Bytes()
+}
+
+// idempotencyRecorder copies a response as it's written.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.header = rec.Header().Clone()
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// newIdempotencyMiddleware replays the first response to a request with an
+// Idempotency-Key header to every retry of it within the store window, so
+// retrying a draw doesn't draw more cards. Keys are scoped to the client and
+// the deck in the path, and reusing one with a different request is rejected
+// with a 422.
+// Requests without the header go straight to the handler.
+func newIdempotencyMiddleware(log *slog.Logger, store *idempotencyStore) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if store.window <= 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			var body []byte
+			if r.Body != nil {
+				var err error
+				body, err = readBody(r)
+				if errors.Is(err, errBodyTooLarge) {
+					respondProblem(w, r, err)
+					return
+				}
+				if err != nil {
+					respondProblem(w, r, invalidRequest("/body", "Couldn't read the request"))
+					return
+				}
+			}
+			h256 := sha256.New()
+			io.WriteString(h256, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+			h256.Write(body)
+			var fingerprint [sha256.Size]byte
+			h256.Sum(fingerprint[:0])
+
+			client := idempotencyClient(r)
+			scoped := r.PathValue("deck_id") + " " + key
+			resp := store.start(client, scoped, fingerprint, time.Now())
+			switch {
+			case resp == nil:
+				rec := &idempotencyRecorder{ResponseWriter: w}
+				defer func() {
+					store.finish(client, scoped, rec, time.Now())
+				}()
+				h.ServeHTTP(rec, r)
+			case resp.fingerprint != fingerprint:
+				respondProblem(w, r, errIdempotencyKeyReused)
+			case !resp.done:
+				respondProblem(w, r, errIdempotencyKeyInUse)
+			default:
+				log.InfoContext(r.Context(), "api", "idempotency", "replayed", "key", key)
+				for k, v := range resp.header {
+					// The retry keeps its own request ID.
+					if k == http.CanonicalHeaderKey(requestIDHeader) {
+						continue
+					}
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(resp.status)
+				w.Write(resp.body)
+			}
+		})
+	}
+}
+
+// idempotencyClient identifies who a key belongs to: the principal when
+// authentication is on, and the IP address of the client otherwise, so
+// clients can't replay each other's responses by guessing their keys.
+func idempotencyClient(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return p.ID()
+	}
+	return "ip " + remoteHost(r)
+}
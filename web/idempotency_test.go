@@ -0,0 +1,152 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	// Test a retried draw replays the first response instead of drawing again.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da)
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	drawFrom := func(addr string, deckID fmt.Stringer, count int, key string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/cards/%d", deckID, count), nil)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		req.RemoteAddr = addr
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	draw := func(deckID fmt.Stringer, count int, key string) *httptest.ResponseRecorder {
+		return drawFrom("192.0.2.1:1234", deckID, count, key)
+	}
+
+	first := draw(d.DeckID, 2, "a")
+	retry := draw(d.DeckID, 2, "a")
+	if first.Code != http.StatusOK || retry.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK twice, got %d and %d", first.Code, retry.Code)
+	}
+	if first.Body.String() != retry.Body.String() {
+		t.Errorf("Expected the retry to replay %s, got %s", first.Body.String(), retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the retry to be marked as replayed")
+	}
+	u, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if u.Remaining != 50 {
+		t.Errorf("Expected 50 cards to remain, got %d", u.Remaining)
+	}
+
+	// Test a key reused with different parameters is rejected.
+	rr := draw(d.DeckID, 3, "a")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", rr.Code)
+	}
+
+	// Test keys are scoped to a deck.
+	other, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	rr = draw(other.DeckID, 2, "a")
+	if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected a fresh draw from another deck, got %d", rr.Code)
+	}
+
+	// Test anonymous clients don't get each other's responses.
+	rr = drawFrom("192.0.2.2:1234", d.DeckID, 2, "a")
+	if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" || rr.Body.String() == first.Body.String() {
+		t.Errorf("Expected a fresh draw for another client, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Test errors other than server errors are replayed too.
+	rr = draw(d.DeckID, 52, "b")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", rr.Code)
+	}
+	rr = draw(d.DeckID, 52, "b")
+	if rr.Code != http.StatusBadRequest || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the 400 to be replayed, got %d", rr.Code)
+	}
+
+	// Test responses are forgotten after the window.
+	mux = NewMux(log, da, WithIdempotencyWindow(time.Millisecond))
+	draw(d.DeckID, 1, "c")
+	time.Sleep(5 * time.Millisecond)
+	rr = draw(d.DeckID, 1, "c")
+	if rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected the response to have expired")
+	}
+	u, err = da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if u.Remaining != 46 {
+		t.Errorf("Expected 46 cards to remain, got %d", u.Remaining)
+	}
+
+	// Test a retry while the first request is running is rejected.
+	s := newIdempotencyStore(time.Minute)
+	s.start("ann", "key", [32]byte{}, time.Now())
+	if resp := s.start("ann", "key", [32]byte{}, time.Now()); resp == nil || resp.done {
+		t.Errorf("Expected the key to be in progress, got %v", resp)
+	}
+
+	// Test a full store makes room by forgetting the response closest to
+	// expiring.
+	s = newIdempotencyStore(time.Minute)
+	s.max = 2
+	now := time.Now()
+	for i, key := range []string{"x", "y", "z"} {
+		s.start("ann", key, [32]byte{}, now)
+		s.finish("ann", key, &idempotencyRecorder{status: http.StatusOK}, now.Add(time.Duration(i)*time.Second))
+	}
+	if _, ok := s.responses["ann x"]; ok || len(s.responses) != 2 {
+		t.Errorf("Expected x to be forgotten, got %v", s.responses)
+	}
+
+	// Test a client sending many keys only forgets its own responses, both
+	// past its share of the store and once the store is full.
+	s = newIdempotencyStore(time.Minute)
+	s.max = 4
+	s.maxPerClient = 3
+	s.start("bob", "kept", [32]byte{}, now)
+	s.finish("bob", "kept", &idempotencyRecorder{status: http.StatusOK}, now)
+	for i := range 10 {
+		key := fmt.Sprint(i)
+		s.start("eve", key, [32]byte{}, now)
+		s.finish("eve", key, &idempotencyRecorder{status: http.StatusOK}, now.Add(time.Duration(i+1)*time.Second))
+	}
+	if resp := s.start("bob", "kept", [32]byte{}, now); resp == nil || !resp.done {
+		t.Errorf("Expected bob's response to be kept, got %v", resp)
+	}
+	if n := s.clients["eve"]; n != 3 {
+		t.Errorf("Expected eve to keep 3 responses, got %d", n)
+	}
+	s.start("bob", "new", [32]byte{}, now)
+	if resp := s.start("bob", "kept", [32]byte{}, now); resp == nil || !resp.done {
+		t.Errorf("Expected a full store to forget eve's responses first, got %v", resp)
+	}
+	if n := s.clients["eve"]; n != 2 {
+		t.Errorf("Expected eve to keep 2 responses, got %d", n)
+	}
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/metrics"
+)
+
+// statusWriter records the status and size of a response. It flushes and
+// hijacks like the writer it wraps, so it can wrap streams and WebSockets.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response can't be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status of the response, 200 if the handler wrote
+// nothing.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// newMetricsMiddleware counts the requests to a route, the pattern it's
+// registered with, and how long they take, by status.
+func newMetricsMiddleware(reg *metrics.Registry) func(pattern string, h http.Handler) http.Handler {
+	requests := reg.Counter("deck_http_requests_total", "HTTP requests served, by route and status.", "method", "route", "status")
+	durations := reg.Histogram("deck_http_request_duration_seconds", "Time taken to serve HTTP requests, by route and status.", metrics.DefaultBuckets, "method", "route", "status")
+
+	return func(pattern string, h http.Handler) http.Handler {
+		method, route, _ := strings.Cut(pattern, " ")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			h.ServeHTTP(sw, r)
+			status := strconv.Itoa(sw.Status())
+			requests.Inc(method, route, status)
+			durations.Observe(time.Since(start).Seconds(), method, route, status)
+		})
+	}
+}
+
+// registerDeckMetrics registers the metrics of the decks of da, read when
+// they're scraped.
+func registerDeckMetrics(reg *metrics.Registry, da *deck.DeckAPI) {
+	reg.CounterFunc("deck_decks_created_total", "Decks created.", func() float64 {
+		return float64(da.Stats().DecksCreated)
+	})
+	reg.CounterFunc("deck_cards_drawn_total", "Cards drawn or dealt from decks.", func() float64 {
+		return float64(da.Stats().CardsDrawn)
+	})
+	reg.CounterFunc("deck_insufficient_cards_total", "Draws and deals of more cards than a deck had left.", func() float64 {
+		return float64(da.Stats().InsufficientCards)
+	})
+	reg.GaugeFunc("deck_decks_active", "Decks in the store.", func() float64 {
+		return float64(da.Usage().Decks)
+	})
+	reg.GaugeFunc("deck_store_cards", "Cards of the decks in the store.", func() float64 {
+		return float64(da.Usage().Cards)
+	})
+	reg.GaugeFunc("deck_store_bytes", "Estimated memory used by the decks in the store.", func() float64 {
+		return float64(da.Usage().Bytes)
+	})
+}
+
+// WithPublicMetrics serves /metrics to anyone, for Prometheus servers that
+// can't send an API key. Otherwise it needs an admin key once clients have to
+// authenticate.
+func WithPublicMetrics(public bool) Option {
+	return func(cfg *config) {
+		cfg.publicMetrics = public
+	}
+}
+
+// handleGetMetrics serves the metrics of reg for Prometheus to scrape.
+func handleGetMetrics(reg *metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		w.WriteHeader(http.StatusOK)
+		reg.WriteTo(w)
+	})
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	serve("POST", "/v1/decks/"+d.DeckID.String()+"/cards/2", "")
+	serve("POST", "/v1/decks/"+d.DeckID.String()+"/cards/51", "")
+	serve("GET", "/v1/decks/14ca6cac-e933-4484-8e3f-e5acd505d11d", "")
+	serve("POST", "/v1/batch", `{"operations":[{"op":"create"},{"op":"deal","deck_id":"$0.deck_id","players":["ann"],"count":3}]}`)
+
+	// Test requests are counted by route and status, along with the decks.
+	rr := serve("GET", "/metrics", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != metrics.ContentType {
+		t.Fatalf("Expected metrics, got %d with %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	for _, line := range []string{
+		`deck_http_requests_total{method="POST",route="/v1/decks/{deck_id}/cards/{count}",status="200"} 1`,
+		`deck_http_requests_total{method="POST",route="/v1/decks/{deck_id}/cards/{count}",status="400"} 1`,
+		`deck_http_requests_total{method="GET",route="/v1/decks/{deck_id}",status="404"} 1`,
+		`deck_http_request_duration_seconds_count{method="POST",route="/v1/batch",status="200"} 1`,
+		"deck_decks_created_total 2",
+		"deck_decks_active 2",
+		"deck_cards_drawn_total 5",
+		"deck_insufficient_cards_total 1",
+		"deck_store_cards 102",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %s in\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsAuth(t *testing.T) {
+	// Test metrics need an admin key once clients authenticate, unless they're
+	// public.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	kr := auth.NewKeyring()
+	kr.Put(auth.Key{ID: "ops", Admin: true}, "ops-secret")
+	kr.Put(auth.Key{ID: "ann"}, "ann-secret")
+
+	cases := []struct {
+		public bool
+		key    string
+		status int
+	}{
+		{false, "", http.StatusUnauthorized},
+		{false, "ann-secret", http.StatusForbidden},
+		{false, "ops-secret", http.StatusOK},
+		{true, "", http.StatusOK},
+	}
+	for _, c := range cases {
+		mux := NewMux(log, da, WithKeyring(kr), WithPublicMetrics(c.public))
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if c.key != "" {
+			req.Header.Set(apiKeyHeader, c.key)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != c.status {
+			t.Errorf("public %t with key %q: expected %d, got %d", c.public, c.key, c.status, rr.Code)
+		}
+	}
+}
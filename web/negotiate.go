@@ -0,0 +1,219 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// codecs are the media types responses can be negotiated into, in order of
+// preference, by the names clients can ask for them with.
+var codecs = []struct {
+	names []string
+	codec codec
+}{
+	{[]string{"application/json"}, jsonCodec},
+	{[]string{"application/cbor"}, cborCodec},
+	{[]string{"application/msgpack", "application/vnd.msgpack", "application/x-msgpack"}, msgpackCodec},
+	{[]string{"text/plain"}, textCodec},
+}
+
+// negotiate returns the codec that best matches an Accept header, as defined
+// by RFC 9110, and false if none does. Ties go to the codec matched by the
+// most specific range, and then to the one listed first.
+func negotiate(accept string) (codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return jsonCodec, true
+	}
+
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+	var ranges []mediaRange
+	for _, s := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qs, 64)
+			if err != nil {
+				continue
+			}
+		}
+		typ, subtype, _ := strings.Cut(mt, "/")
+		ranges = append(ranges, mediaRange{typ, subtype, q})
+	}
+
+	best, bestQ, bestSpecificity := codec{}, 0.0, -1
+	for _, c := range codecs {
+		for _, name := range c.names {
+			typ, subtype, _ := strings.Cut(name, "/")
+			// The most specific range that matches sets the quality.
+			q, specificity := 0.0, -1
+			for _, r := range ranges {
+				var s int
+				switch {
+				case r.typ == typ && r.subtype == subtype:
+					s = 2
+				case r.typ == typ && r.subtype == "*":
+					s = 1
+				case r.typ == "*" && r.subtype == "*":
+					s = 0
+				default:
+					continue
+				}
+				if s > specificity {
+					q, specificity = r.q, s
+				}
+			}
+			if q > bestQ || (q > 0 && q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = c.codec, q, specificity
+				if specificity == 2 && !strings.HasPrefix(c.codec.contentType, name) {
+					// Answer with the alias that was asked for.
+					best.contentType = name
+				}
+			}
+		}
+	}
+	return best, bestQ > 0
+}
+
+// newNegotiationMiddleware writes the JSON responses of a route in the format
+// the request's Accept header asks for, answering 406 when there's none it
+// can have. Request bodies in CBOR or MessagePack are turned into JSON before
+// the route gets them. Responses in other media types, such as streams, are
+// left alone.
+func newNegotiationMiddleware() func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			c, ok := negotiate(r.Header.Get("Accept"))
+			if !ok {
+				respondProblem(w, r, errNotAcceptable)
+				return
+			}
+
+			err := decodeBody(r)
+			if errors.Is(err, errBodyTooLarge) {
+				respondProblem(w, r, err)
+				return
+			}
+			if err != nil {
+				respondProblem(w, r, invalidRequest("/body", "Couldn't read the request: "+err.Error()))
+				return
+			}
+
+			if c.contentType == jsonCodec.contentType {
+				h.ServeHTTP(w, r)
+				return
+			}
+			nw := &negotiatedWriter{ResponseWriter: w, codec: c}
+			h.ServeHTTP(nw, r)
+			nw.flush()
+		})
+	}
+}
+
+// decodeBody turns CBOR and MessagePack request bodies into JSON.
+func decodeBody(r *http.Request) error {
+	if r.Body == nil {
+		return nil
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var c codec
+	for _, cs := range codecs {
+		for _, name := range cs.names {
+			if name == contentType && cs.codec.decode != nil {
+				c = cs.codec
+			}
+		}
+	}
+	if c.decode == nil || contentType == jsonCodec.contentType {
+		return nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if len(body) > 0 {
+		v, err := c.decode(bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		b, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	r.Header.Set("Content-Type", jsonCodec.contentType)
+	return nil
+}
+
+// negotiatedWriter holds back JSON responses to write them with its codec
+// once the handler is done. Other responses go through as they are.
+type negotiatedWriter struct {
+	http.ResponseWriter
+	codec       codec
+	wroteHeader bool
+	transcode   bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (w *negotiatedWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	contentType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if contentType == jsonCodec.contentType || contentType == contentTypeProblem {
+		w.transcode = true
+		w.status = statusCode
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *negotiatedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.transcode {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// flush writes the held back JSON response with the codec. Responses that
+// aren't valid JSON are written as they are.
+func (w *negotiatedWriter) flush() {
+	if !w.transcode {
+		return
+	}
+	var out bytes.Buffer
+	v, err := jsonCodec.decode(bytes.NewReader(w.body.Bytes()))
+	if err == nil {
+		err = w.codec.encode(&out, v)
+	}
+	if err != nil {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", w.codec.contentType)
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(out.Bytes())
+}
@@ -0,0 +1,231 @@
+package web
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept      string
+		contentType string
+		ok          bool
+	}{
+		{"", "application/json", true},
+		{"*/*", "application/json", true},
+		{"application/cbor", "application/cbor", true},
+		{"application/x-msgpack", "application/x-msgpack", true},
+		{"text/*", "text/plain; charset=utf-8", true},
+		{"application/json;q=0.5, application/msgpack", "application/msgpack", true},
+		{"text/plain, */*", "text/plain; charset=utf-8", true},
+		{"application/json;q=0, */*;q=0.1", "application/cbor", true},
+		{"image/png", "", false},
+	}
+	for _, c := range cases {
+		codec, ok := negotiate(c.accept)
+		if ok != c.ok || codec.contentType != c.contentType {
+			t.Errorf("%q: expected %q and %v, got %q and %v", c.accept, c.contentType, c.ok, codec.contentType, ok)
+		}
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	// Test the encodings match the examples of RFC 8949 and the MessagePack
+	// spec, and decode back into the same tree.
+	tree := object{
+		{"a", json.Number("1")},
+		{"b", []any{json.Number("-2"), json.Number("1000"), json.Number("1.5")}},
+		{"c", nil},
+		{"d", true},
+	}
+	cases := []struct {
+		codec codec
+		hex   string
+	}{
+		{cborCodec, "a4 6161 01 6162 83 21 1903e8 fb3ff8000000000000 6163 f6 6164 f5"},
+		{msgpackCodec, "84 a161 01 a162 93 fe cd03e8 cb3ff8000000000000 a163 c0 a164 c3"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		err := c.codec.encode(&buf, tree)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", c.codec.contentType, err)
+		}
+		expected := strings.ReplaceAll(c.hex, " ", "")
+		if hex.EncodeToString(buf.Bytes()) != expected {
+			t.Errorf("%s: expected %s, got %x", c.codec.contentType, expected, buf.Bytes())
+		}
+		v, err := c.codec.decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", c.codec.contentType, err)
+		}
+		if !reflect.DeepEqual(v, tree) {
+			t.Errorf("%s: expected %v, got %v", c.codec.contentType, tree, v)
+		}
+	}
+
+	// Test indefinite lengths and half floats, which aren't encoded but can
+	// be sent.
+	b, _ := hex.DecodeString(strings.ReplaceAll("bf 6161 9f 01 f93e00 ff ff", " ", ""))
+	v, err := cborCodec.decode(bytes.NewReader(b))
+	expected := object{{"a", []any{json.Number("1"), json.Number("1.5")}}}
+	if err != nil || !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expected %v, got %v and %v", expected, v, err)
+	}
+}
+
+// fuzzDecode checks a codec never panics on a body, and that what it decodes
+// is encoded back into the same tree.
+func fuzzDecode(f *testing.F, c codec, seeds ...string) {
+	for _, seed := range seeds {
+		b, err := hex.DecodeString(strings.ReplaceAll(seed, " ", ""))
+		if err != nil {
+			f.Fatalf("Invalid seed %q: %v", seed, err)
+		}
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, err := c.decode(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		err = c.encode(&buf, v)
+		if err != nil {
+			t.Fatalf("Failed to encode %v: %v", v, err)
+		}
+		again, err := c.decode(&buf)
+		if err != nil {
+			t.Fatalf("Failed to decode %x: %v", buf.Bytes(), err)
+		}
+		if !reflect.DeepEqual(again, v) {
+			t.Errorf("Expected %v, got %v", v, again)
+		}
+	})
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	fuzzDecode(f, cborCodec,
+		"a4 6161 01 6162 83 21 1903e8 fb3ff8000000000000 6163 f6 6164 f5",
+		"bf 6161 9f 01 f93e00 ff ff",
+		"7f 6161 6162 ff",
+		"fa 7fc00000",
+	)
+}
+
+func FuzzDecodeMsgpack(f *testing.F) {
+	fuzzDecode(f, msgpackCodec,
+		"84 a161 01 a162 93 fe cd03e8 cb3ff8000000000000 a163 c0 a164 c3",
+		"dc0002 d0ff ca3fc00000",
+		"de0001 d90161 cf0000000100000000",
+	)
+}
+
+func TestNegotiationMiddleware(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da)
+
+	send := func(method, path, accept, contentType string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		req.Header.Set("Accept", accept)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test a deck is created from a MessagePack body and returned as CBOR.
+	var body bytes.Buffer
+	encodeMsgpack(&body, []any{object{{"code", "AS"}}, object{{"code", "KH"}}, object{{"code", "10D"}}})
+	rr := send("POST", "/v1/decks", "application/cbor", "application/msgpack", body.Bytes())
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/cbor" {
+		t.Fatalf("Expected a 200 CBOR response, got %d %s: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	v, err := cborCodec.decode(rr.Body)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	created, _ := v.(object)
+	if len(created) != 3 || created[0].key != "deck_id" || created[2].value != json.Number("3") {
+		t.Fatalf("Expected a deck with 3 cards, got %v", v)
+	}
+	deckID := created[0].value.(string)
+
+	// Test cards are written as glyphs for people.
+	rr = send("POST", "/v1/decks/"+deckID+"/cards/3", "text/plain", "", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "A♠ K♥ 10♦\n" {
+		t.Errorf("Expected 200 and A♠ K♥ 10♦, got %d and %q", rr.Code, rr.Body.String())
+	}
+	rr = send("GET", "/v1/decks/"+deckID, "text/plain", "", nil)
+	expected := "deck_id: " + deckID + "\nshuffled: false\nremaining: 0\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, rr.Body.String())
+	}
+
+	// Test cards in hands and piles are written as glyphs too, whichever way
+	// up they lie.
+	table, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	dealt, err := da.Deal(table.DeckID, []string{"ann", "bob"}, 2)
+	if err != nil {
+		t.Fatalf("Failed to deal: %v", err)
+	}
+	for _, c := range dealt.Hands["ann"] {
+		_, err = da.Play(table.DeckID, "ann", c, "discard")
+		if err != nil {
+			t.Fatalf("Failed to play: %v", err)
+		}
+	}
+	_, err = da.FlipTop(table.DeckID, "discard")
+	if err != nil {
+		t.Fatalf("Failed to flip: %v", err)
+	}
+	rr = send("GET", "/v1/decks/"+table.DeckID.String(), "text/plain", "", nil)
+	expected = "deck_id: " + table.DeckID.String() + "\nshuffled: false\nremaining: 48\nhands:\n  bob: 🂠 🂠\npiles:\n  discard: 2♣ 🂠\n"
+	if rr.Body.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, rr.Body.String())
+	}
+
+	// Test errors are negotiated too.
+	rr = send("POST", "/v1/decks/"+deckID+"/cards/1", "application/msgpack", "", nil)
+	v, err = msgpackCodec.decode(rr.Body)
+	if rr.Code != http.StatusBadRequest || err != nil {
+		t.Fatalf("Expected a 400 MessagePack response, got %d and %v", rr.Code, err)
+	}
+	if v.(object)[5] != (member{"code", "insufficient_cards"}) {
+		t.Errorf("Expected an insufficient_cards problem, got %v", v)
+	}
+
+	// Test unsupported media types get a 406.
+	rr = send("GET", "/v1/decks/"+deckID, "image/png", "", nil)
+	if rr.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected 406, got %d", rr.Code)
+	}
+
+	// Test CBOR and MessagePack bodies are limited before they're decoded.
+	mux = NewMux(log, da, WithMaxBodySize(16))
+	body.Reset()
+	encodeMsgpack(&body, []any{object{{"code", "AS"}}, object{{"code", "KH"}}, object{{"code", "10D"}}})
+	rr = send("POST", "/v1/decks", "application/json", "application/msgpack", body.Bytes())
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
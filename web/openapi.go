@@ -0,0 +1,144 @@
+package web
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed static
+var staticFS embed.FS
+
+// loadSpecNode parses the embedded API spec once.
+var loadSpecNode = sync.OnceValues(func() (*yaml.Node, error) {
+	b, err := staticFS.ReadFile("static/api-spec.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("read api spec: %w", err)
+	}
+	var doc yaml.Node
+	err = yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("parse api spec: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parse api spec: not a mapping")
+	}
+	return &doc, nil
+})
+
+// specWithServer returns a copy of the API spec whose servers list only holds
+// the given base URL, placed right after the info section.
+func specWithServer(baseURL string) (*yaml.Node, error) {
+	doc, err := loadSpecNode()
+	if err != nil {
+		return nil, err
+	}
+
+	root := doc.Content[0]
+	servers := []*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: "servers"},
+		{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{{
+			Kind: yaml.MappingNode,
+			Tag:  "!!map",
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: "url"},
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: baseURL},
+			},
+		}}},
+	}
+
+	var content []*yaml.Node
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value == "servers" {
+			continue
+		}
+		content = append(content, key, value)
+		if key.Value == "info" {
+			content = append(content, servers...)
+			servers = nil
+		}
+	}
+	content = append(content, servers...)
+
+	rootCopy := *root
+	rootCopy.Content = content
+	docCopy := *doc
+	docCopy.Content = []*yaml.Node{&rootCopy}
+	return &docCopy, nil
+}
+
+// baseURL is the URL clients used to reach the server, honouring the headers
+// set by reverse proxies.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	return scheme + "://" + host
+}
+
+func handleGetOpenAPIYAML() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := specWithServer(baseURL(r))
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		var b bytes.Buffer
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		err = enc.Encode(doc)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b.Bytes())
+	})
+}
+
+func handleGetOpenAPIJSON() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := specWithServer(baseURL(r))
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		var v map[string]any
+		err = doc.Decode(&v)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		encodeJSON(w, http.StatusOK, v)
+	})
+}
+
+// handleGetDocs serves the API explorer, a single page that reads
+// /openapi.json and sends requests to the server it was loaded from.
+func handleGetDocs() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := staticFS.ReadFile("static/docs.html")
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func Test_handleGetOpenAPI(t *testing.T) {
+	// Test the YAML spec has the server URL filled in.
+	req, err := http.NewRequest("GET", "http://decks.internal:9000/openapi.yaml", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(handleGetOpenAPIYAML())
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %v", rr.Code)
+	}
+	var doc struct {
+		Servers []struct {
+			URL string `yaml:"url" json:"url"`
+		} `yaml:"servers" json:"servers"`
+		Paths map[string]any `yaml:"paths" json:"paths"`
+	}
+	err = yaml.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("Expected a YAML document, got %v", err)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "http://decks.internal:9000" {
+		t.Errorf("Expected the server URL to be filled in, got %v", doc.Servers)
+	}
+	if _, ok := doc.Paths["/v1/decks"]; !ok {
+		t.Errorf("Expected the spec paths, got %v", doc.Paths)
+	}
+
+	// Test the JSON spec honours the reverse proxy headers.
+	req, err = http.NewRequest("GET", "http://127.0.0.1:9000/openapi.json", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "decks.example.com")
+
+	rr = httptest.NewRecorder()
+	handler = http.Handler(handleGetOpenAPIJSON())
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %v", rr.Code)
+	}
+	err = json.NewDecoder(rr.Body).Decode(&doc)
+	if err != nil {
+		t.Fatalf("Expected a JSON document, got %v", err)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "https://decks.example.com" {
+		t.Errorf("Expected the proxied server URL, got %v", doc.Servers)
+	}
+
+	// Test the explorer is served.
+	req, err = http.NewRequest("GET", "/docs", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr = httptest.NewRecorder()
+	handler = http.Handler(handleGetDocs())
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %v", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "/openapi.json") {
+		t.Errorf("Expected the explorer to load the spec")
+	}
+}
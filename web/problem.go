@@ -0,0 +1,211 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+const contentTypeProblem = "application/problem+json"
+
+// problemTypeBase is where problem type URIs live. They're relative to the
+// server, as RFC 9457 allows, which describes them there.
+const problemTypeBase = "/problems/"
+
+var errInvalidParameter = errors.New("Invalid parameter")
+var errInvalidRequest = errors.New("Invalid request")
+var errNotAcceptable = errors.New("None of the accepted media types can be served, try application/json, application/cbor, application/msgpack or text/plain")
+var errIdempotencyKeyReused = errors.New("Idempotency key was already used for a different request")
+var errIdempotencyKeyInUse = errors.New("A request with this idempotency key is still in progress")
+var errPanicked = errors.New("The server failed to serve the request")
+var errProblemNotFound = errors.New("Problem type not found")
+
+// problemType is a kind of error, with a code that stays the same for as long
+// as the API does, unlike error messages.
+type problemType struct {
+	code   string
+	status int
+	title  string
+}
+
+var problemInternal = problemType{"internal_error", http.StatusInternalServerError, "Internal server error"}
+
+// problemTypes maps errors to their problem type. Errors get the type of the
+// first error they match with errors.Is, and problemInternal if none.
+var problemTypes = []struct {
+	err error
+	typ problemType
+}{
+	{deck.ErrDeckNotFound, problemType{"deck_not_found", http.StatusNotFound, "Deck not found"}},
+	{deck.ErrUnsufficientCards, problemType{"insufficient_cards", http.StatusBadRequest, "Not enough cards in the deck"}},
+	{deck.ErrCardNotInHand, problemType{"card_not_in_hand", http.StatusConflict, "Card isn't in the hand"}},
+	{deck.ErrCardNotInPlay, problemType{"card_not_in_play", http.StatusConflict, "Card isn't in a hand or pile"}},
+	{deck.ErrPileNotFound, problemType{"pile_not_found", http.StatusNotFound, "Pile not found"}},
+	{deck.ErrHandNotFound, problemType{"hand_not_found", http.StatusNotFound, "Hand not found"}},
+	{deck.ErrCardInDeck, problemType{"card_in_deck", http.StatusConflict, "Card is already in the deck"}},
+	{deck.ErrInvalidDeck, problemType{"invalid_deck", http.StatusBadRequest, "Invalid deck"}},
+	{deck.ErrInvalidName, problemType{"invalid_name", http.StatusBadRequest, "Invalid player or pile name"}},
+	{deck.ErrStoreFull, problemType{"store_full", http.StatusInsufficientStorage, "Store is full"}},
+	{deck.ErrDeckTooLarge, problemType{"deck_too_large", http.StatusInsufficientStorage, "Deck is too large"}},
+	{deck.ErrReadOnly, problemType{"read_only", http.StatusServiceUnavailable, "Server is read-only"}},
+	{deck.ErrEventsDisabled, problemType{"events_disabled", http.StatusNotImplemented, "Event sourcing is disabled"}},
+	{deck.ErrNotFollower, problemType{"not_follower", http.StatusConflict, "Server isn't a follower"}},
+	{errInvalidParameter, problemType{"invalid_parameter", http.StatusBadRequest, "Invalid parameter"}},
+	{errInvalidRequest, problemType{"invalid_request", http.StatusBadRequest, "Invalid request"}},
+	{errInvalidOperation, problemType{"invalid_request", http.StatusBadRequest, "Invalid request"}},
+	{errInvalidReference, problemType{"invalid_request", http.StatusBadRequest, "Invalid request"}},
+	{errInvalidCommand, problemType{"invalid_command", http.StatusBadRequest, "Invalid command"}},
+	{errInvalidCount, problemType{"invalid_command", http.StatusBadRequest, "Invalid command"}},
+	{errUnknownCommand, problemType{"invalid_command", http.StatusBadRequest, "Invalid command"}},
+	{errNoPlayer, problemType{"invalid_command", http.StatusBadRequest, "Invalid command"}},
+	{errNotAcceptable, problemType{"not_acceptable", http.StatusNotAcceptable, "Not acceptable"}},
+	{errBodyTooLarge, problemType{"body_too_large", http.StatusRequestEntityTooLarge, "Request body too large"}},
+	{errIdempotencyKeyInUse, problemType{"idempotency_key_in_use", http.StatusConflict, "Idempotency key in use"}},
+	{errIdempotencyKeyReused, problemType{"idempotency_key_reused", http.StatusUnprocessableEntity, "Idempotency key reused"}},
+	{auth.ErrUnauthenticated, problemType{"unauthenticated", http.StatusUnauthorized, "Unauthenticated"}},
+	{auth.ErrForbidden, problemType{"forbidden", http.StatusForbidden, "Forbidden"}},
+	{auth.ErrKeyExists, problemType{"key_exists", http.StatusConflict, "API key already exists"}},
+	{auth.ErrKeyNotFound, problemType{"key_not_found", http.StatusNotFound, "API key not found"}},
+	{errAuthDisabled, problemType{"auth_disabled", http.StatusNotImplemented, "Authentication is disabled"}},
+	{errRateLimited, problemType{"rate_limited", http.StatusTooManyRequests, "Rate limited"}},
+	{errLoginsLimited, problemType{"rate_limited", http.StatusTooManyRequests, "Rate limited"}},
+	{errTooManyCommands, problemType{"rate_limited", http.StatusTooManyRequests, "Rate limited"}},
+	{errQuotaExceeded, problemType{"quota_exceeded", http.StatusTooManyRequests, "Quota exceeded"}},
+	{errProblemNotFound, problemType{"problem_not_found", http.StatusNotFound, "Problem type not found"}},
+}
+
+func problemTypeOf(err error) problemType {
+	for _, pt := range problemTypes {
+		if errors.Is(err, pt.err) {
+			return pt.typ
+		}
+	}
+	return problemInternal
+}
+
+// ErrorStatus is the HTTP status the API answers an error with, 500 for the
+// errors it doesn't know.
+func ErrorStatus(err error) int {
+	return problemTypeOf(err).status
+}
+
+// problem is an error response, as defined by RFC 9457, with the code of its
+// type and the details of the error as extension members.
+type problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     string      `json:"code"`
+	Errors   []violation `json:"errors,omitempty"`
+	// Remaining and Requested are the cards left in a deck and the cards
+	// asked for, for insufficient_cards.
+	Remaining *int `json:"remaining,omitempty"`
+	Requested *int `json:"requested,omitempty"`
+}
+
+// requestError is an error in the parts of a request its violations point
+// at.
+type requestError struct {
+	err        error
+	detail     string
+	violations []violation
+}
+
+func (e *requestError) Error() string {
+	return e.detail
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// invalidParameter is the error of a parameter, pointed at as in violations,
+// such as "/path/deck_id".
+func invalidParameter(pointer, detail string) error {
+	return &requestError{err: errInvalidParameter, detail: detail, violations: []violation{{pointer, detail}}}
+}
+
+// invalidRequest is the error of a request body, or part of it.
+func invalidRequest(pointer, detail string) error {
+	return &requestError{err: errInvalidRequest, detail: detail, violations: []violation{{pointer, detail}}}
+}
+
+// newProblem describes err, which happened at instance, the path of the
+// request.
+func newProblem(err error, instance string) problem {
+	pt := problemTypeOf(err)
+	p := problem{
+		Type:     problemTypeBase + pt.code,
+		Title:    pt.title,
+		Status:   pt.status,
+		Detail:   err.Error(),
+		Instance: instance,
+		Code:     pt.code,
+	}
+
+	var re *requestError
+	if errors.As(err, &re) {
+		p.Errors = re.violations
+	}
+	var ice *deck.InsufficientCardsError
+	if errors.As(err, &ice) {
+		p.Remaining = &ice.Remaining
+		p.Requested = &ice.Requested
+	}
+	return p
+}
+
+// respondProblem writes err as a problem response.
+func respondProblem(w http.ResponseWriter, r *http.Request, err error) error {
+	p := newProblem(err, r.URL.Path)
+	w.Header().Set("Content-Type", contentTypeProblem)
+	w.WriteHeader(p.Status)
+	err = json.NewEncoder(w).Encode(p)
+	if err != nil {
+		return fmt.Errorf("encode problem: %w", err)
+	}
+	return nil
+}
+
+var problemPage = template.Must(template.New("problem").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Problems of this type have the code <code>{{.Code}}</code> and the HTTP status {{.Status}}.
+Their detail says what went wrong each time. The <a href="/docs">API docs</a> list every code.</p>
+</body>
+</html>
+`))
+
+// handleGetProblem describes the problem type of a type URI, for people who
+// follow it.
+func handleGetProblem() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := r.PathValue("code")
+		pt := problemInternal
+		for _, t := range problemTypes {
+			if t.typ.code == code {
+				pt = t.typ
+				break
+			}
+		}
+		if pt.code != code {
+			respondProblem(w, r, errProblemNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		problemPage.Execute(w, struct {
+			Title, Code string
+			Status      int
+		}{pt.title, pt.code, pt.status})
+	})
+}
@@ -0,0 +1,371 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mtpereira/deck/auth"
+)
+
+var errRateLimited = errors.New("Too many requests, slow down")
+var errQuotaExceeded = errors.New("Daily quota of new decks exceeded")
+var errLoginsLimited = errors.New("Too many failed logins, try again later")
+
+// defaultLoginAttempts is how many failed logins each address can make a
+// minute unless configured otherwise.
+const defaultLoginAttempts = 10
+
+// RateLimit is a token bucket that holds up to Burst requests and refills at
+// Rate requests per second. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRouteRateLimit parses the rate limit of a route, given as the route
+// pattern, the rate and the burst, such as "POST /v1/decks 1 10".
+func ParseRouteRateLimit(s string) (string, RateLimit, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 || !strings.HasPrefix(fields[1], "/") {
+		return "", RateLimit{}, fmt.Errorf("rate limit %q isn't METHOD PATH RATE BURST", s)
+	}
+	rate, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || rate < 0 {
+		return "", RateLimit{}, fmt.Errorf("rate limit %q has an invalid rate", s)
+	}
+	burst, err := strconv.Atoi(fields[3])
+	if err != nil || burst < 1 {
+		return "", RateLimit{}, fmt.Errorf("rate limit %q has an invalid burst", s)
+	}
+	return fields[0] + " " + fields[1], RateLimit{Rate: rate, Burst: burst}, nil
+}
+
+// LimiterStore keeps the token buckets and quota counters of clients. The
+// default one is in memory; others can share limits between servers.
+type LimiterStore interface {
+	// Take takes a token from the bucket of key, if it has one, and returns
+	// the tokens left in it.
+	Take(key string, limit RateLimit, now time.Time) (tokens float64, ok bool)
+	// Add adds n to the counter of key, unless that takes it over quota, and
+	// returns the count. A negative n gives back what was added. Counters
+	// start from 0 again once they expire.
+	Add(key string, n, quota int, expires, now time.Time) (count int, ok bool)
+}
+
+// WithRateLimit limits how often each client can call the routes that have no
+// limit of their own, all together.
+func WithRateLimit(limit RateLimit) Option {
+	return func(cfg *config) {
+		cfg.rateLimit = limit
+	}
+}
+
+// WithRouteRateLimit limits how often each client can call a route, given
+// by its pattern such as "POST /v1/decks", instead of WithRateLimit.
+func WithRouteRateLimit(pattern string, limit RateLimit) Option {
+	return func(cfg *config) {
+		if cfg.routeRateLimits == nil {
+			cfg.routeRateLimits = make(map[string]RateLimit)
+		}
+		cfg.routeRateLimits[pattern] = limit
+	}
+}
+
+// WithDailyDeckQuota limits how many decks each client can create a day, in
+// UTC. Zero means no limit.
+func WithDailyDeckQuota(decks int) Option {
+	return func(cfg *config) {
+		cfg.dailyDecks = decks
+	}
+}
+
+// WithLoginAttemptLimit limits how many requests with missing or wrong
+// credentials each IP address can make a minute, so keys can't be guessed.
+// Zero means no limit.
+func WithLoginAttemptLimit(attempts int) Option {
+	return func(cfg *config) {
+		cfg.loginAttempts = attempts
+	}
+}
+
+// WithLimiterStore keeps rate limits and quotas in store instead of memory.
+func WithLimiterStore(store LimiterStore) Option {
+	return func(cfg *config) {
+		cfg.limiterStore = store
+	}
+}
+
+// clientKey identifies the client of a request for rate limits and quotas:
+// by its principal when it has authenticated, and by its IP address
+// otherwise. Credentials that haven't been checked are never used, or
+// clients could make up a new one for every request.
+func clientKey(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return p.ID()
+	}
+	return "ip " + remoteHost(r)
+}
+
+// remoteHost returns the IP address the request came from.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newRateLimitMiddleware answers 429 to clients that call a route more often
+// than its limit allows, and tells every client how much of it they have left
+// with RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// Routes without a limit go straight to the handler.
+func newRateLimitMiddleware(log *slog.Logger, cfg config) func(h http.Handler) http.Handler {
+	routes := http.NewServeMux()
+	for pattern := range cfg.routeRateLimits {
+		routes.Handle(pattern, http.NotFoundHandler())
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := cfg.rateLimit
+			_, pattern := routes.Handler(r)
+			if l, ok := cfg.routeRateLimits[pattern]; ok {
+				limit = l
+			} else {
+				pattern = "*"
+			}
+			if limit.Rate <= 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			key := clientKey(r)
+			tokens, ok := cfg.limiterStore.Take(key+" "+pattern, limit, time.Now())
+			reset := (float64(limit.Burst) - tokens) / limit.Rate
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset))))
+			if !ok {
+				log.InfoContext(r.Context(), "api", "ratelimit", "limited", "client", key, "route", pattern)
+				retry := (1 - tokens) / limit.Rate
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry))))
+				respondProblem(w, r, errRateLimited)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// loginLimiter counts the failed logins of each IP address for a minute from
+// the first one.
+type loginLimiter struct {
+	store    LimiterStore
+	attempts int
+}
+
+// allow returns whether the address of r can try to log in.
+func (l loginLimiter) allow(r *http.Request) bool {
+	if l.attempts <= 0 {
+		return true
+	}
+	now := time.Now()
+	count, _ := l.store.Add(loginKey(r), 0, l.attempts, now.Add(time.Minute), now)
+	return count < l.attempts
+}
+
+// fail counts a failed login from the address of r.
+func (l loginLimiter) fail(r *http.Request) {
+	if l.attempts <= 0 {
+		return
+	}
+	now := time.Now()
+	l.store.Add(loginKey(r), 1, l.attempts, now.Add(time.Minute), now)
+}
+
+func loginKey(r *http.Request) string {
+	return "ip " + remoteHost(r) + " logins"
+}
+
+// newDeckQuotaMiddleware answers 429 to clients that have created as many
+// decks as they can today. Decks are counted up front by decks, and given
+// back if the request fails.
+func newDeckQuotaMiddleware(log *slog.Logger, cfg config, decks func(r *http.Request) int) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if cfg.dailyDecks <= 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := decks(r)
+			if n == 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now().UTC()
+			tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+			key := clientKey(r) + " decks " + now.Format(time.DateOnly)
+			_, ok := cfg.limiterStore.Add(key, n, cfg.dailyDecks, tomorrow, now)
+			if !ok {
+				log.InfoContext(r.Context(), "api", "quota", "exceeded", "client", clientKey(r))
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tomorrow.Sub(now).Seconds()))))
+				respondProblem(w, r, errQuotaExceeded)
+				return
+			}
+
+			rec := &statusRecorder{ResponseWriter: w}
+			h.ServeHTTP(rec, r)
+			if rec.status >= http.StatusBadRequest {
+				cfg.limiterStore.Add(key, -n, cfg.dailyDecks, tomorrow, time.Now())
+			}
+		})
+	}
+}
+
+// batchCreates counts the decks a batch request creates. The body is left
+// for the handler to read.
+func batchCreates(r *http.Request) int {
+	if r.Body == nil {
+		return 0
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return 0
+	}
+	var req struct {
+		Operations []struct {
+			Op string `json:"op"`
+		} `json:"operations"`
+	}
+	json.Unmarshal(body, &req)
+	n := 0
+	for _, op := range req.Operations {
+		if op.Op == "create" {
+			n++
+		}
+	}
+	return n
+}
+
+// statusRecorder keeps the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(statusCode int) {
+	if rec.status == 0 {
+		rec.status = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// memoryLimiterStore is a LimiterStore in memory.
+type memoryLimiterStore struct {
+	buckets  map[string]*bucket
+	counters map[string]*counter
+	swept    time.Time
+	mu       sync.Mutex
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+type counter struct {
+	count   int
+	expires time.Time
+}
+
+// NewMemoryLimiterStore returns a LimiterStore in memory, which the HTTP and
+// gRPC APIs of a server can share.
+func NewMemoryLimiterStore() LimiterStore {
+	return newMemoryLimiterStore()
+}
+
+func newMemoryLimiterStore() *memoryLimiterStore {
+	return &memoryLimiterStore{
+		buckets:  make(map[string]*bucket),
+		counters: make(map[string]*counter),
+	}
+}
+
+// refill returns the tokens in b at now.
+func (b *bucket) refill(now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*b.limit.Rate
+	return math.Min(tokens, float64(b.limit.Burst))
+}
+
+func (s *memoryLimiterStore) Take(key string, limit RateLimit, now time.Time) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = b.refill(now)
+	b.updated = now
+	if b.tokens < 1 {
+		return b.tokens, false
+	}
+	b.tokens--
+	return b.tokens, true
+}
+
+func (s *memoryLimiterStore) Add(key string, n, quota int, expires, now time.Time) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = &counter{expires: expires}
+		s.counters[key] = c
+	}
+	if n > 0 && c.count+n > quota {
+		return c.count, false
+	}
+	c.count = max(c.count+n, 0)
+	return c.count, true
+}
+
+// sweep forgets full buckets and expired counters once a minute, since they
+// are the same as new ones. It must be called with s.mu held.
+func (s *memoryLimiterStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	for k, b := range s.buckets {
+		if b.refill(now) >= float64(b.limit.Burst) {
+			delete(s.buckets, k)
+		}
+	}
+	for k, c := range s.counters {
+		if !now.Before(c.expires) {
+			delete(s.counters, k)
+		}
+	}
+	s.swept = now
+}
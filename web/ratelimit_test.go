@@ -0,0 +1,207 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da,
+		WithRateLimit(RateLimit{Rate: 0.001, Burst: 2}),
+		WithRouteRateLimit("POST /v1/decks", RateLimit{Rate: 0.001, Burst: 1}),
+	)
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	get := func(apiKey, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/decks/"+d.DeckID.String(), nil)
+		req.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test the burst is let through and the headers count it down.
+	for i, remaining := range []string{"1", "0"} {
+		rr := get("", "192.0.2.1:1234")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i, rr.Code)
+		}
+		if rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != remaining {
+			t.Errorf("Request %d: expected limit 2 and %s remaining, got %s and %s", i, remaining,
+				rr.Header().Get("RateLimit-Limit"), rr.Header().Get("RateLimit-Remaining"))
+		}
+	}
+
+	// Test the request after the burst is limited, from any port.
+	rr := get("", "192.0.2.1:5678")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"code":"rate_limited"`) {
+		t.Errorf("Expected a rate_limited problem, got %s", rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" || rr.Header().Get("RateLimit-Reset") == "" {
+		t.Errorf("Expected Retry-After and RateLimit-Reset headers")
+	}
+
+	// Test clients are told apart by address, and not by API keys that
+	// haven't been checked.
+	if rr := get("", "192.0.2.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected another address to get 200, got %d", rr.Code)
+	}
+	if rr := get("a", "192.0.2.1:1234"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected an unchecked API key to get 429, got %d", rr.Code)
+	}
+
+	// Test routes with a limit of their own use it.
+	for i, status := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("POST", "/v1/decks", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != status {
+			t.Errorf("Create %d: expected %d, got %d", i, status, rr.Code)
+		}
+	}
+}
+
+func TestLoginAttemptLimit(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	kr := auth.NewKeyring()
+	kr.Put(auth.Key{ID: "ann"}, "ann-secret")
+	mux := NewMux(log, da, WithKeyring(kr), WithLoginAttemptLimit(2))
+
+	create := func(apiKey, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/decks", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test an address that fails too often is locked out, even with the
+	// right key, and others aren't.
+	for i, status := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if rr := create("nope", "192.0.2.1:1234"); rr.Code != status {
+			t.Errorf("Attempt %d: expected %d, got %d", i, status, rr.Code)
+		}
+	}
+	rr := create("ann-secret", "192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d", rr.Code)
+	}
+	if rr := create("ann-secret", "192.0.2.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected another address to get 200, got %d", rr.Code)
+	}
+}
+
+func TestDeckQuotaMiddleware(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da, WithDailyDeckQuota(3))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "a")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Test failed creations don't count.
+	if rr := send("POST", "/v1/decks", `["AS","AS"]`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", rr.Code)
+	}
+	if rr := send("POST", "/v1/decks", ""); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+
+	// Test every deck a batch creates counts.
+	batch := `{"operations":[{"op":"create"},{"op":"create"},{"op":"draw","deck_id":"$0.deck_id","count":1}]}`
+	if rr := send("POST", "/v1/batch", batch); rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := send("POST", "/v1/decks", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"code":"quota_exceeded"`) || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a quota_exceeded problem with Retry-After, got %s", rr.Body.String())
+	}
+
+	// Test other routes aren't counted.
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	if rr := send("POST", fmt.Sprintf("/v1/decks/%s/shuffle", d.DeckID), ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected shuffle to get 200, got %d", rr.Code)
+	}
+}
+
+func TestMemoryLimiterStore(t *testing.T) {
+	s := newMemoryLimiterStore()
+	now := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	limit := RateLimit{Rate: 1, Burst: 2}
+
+	// Test buckets refill at their rate, up to their burst.
+	for i, want := range []bool{true, true, false} {
+		if _, ok := s.Take("a", limit, now); ok != want {
+			t.Errorf("Take %d: expected %t", i, want)
+		}
+	}
+	if tokens, ok := s.Take("a", limit, now.Add(1500*time.Millisecond)); !ok || tokens != 0.5 {
+		t.Errorf("Expected a token after 1.5s with 0.5 left, got %t and %v", ok, tokens)
+	}
+	if tokens, _ := s.Take("a", limit, now.Add(time.Hour)); tokens != 1 {
+		t.Errorf("Expected a full bucket after an hour, got %v tokens left", tokens)
+	}
+
+	// Test counters stop at their quota, give back and expire.
+	expires := now.Add(time.Hour)
+	if n, ok := s.Add("b", 2, 3, expires, now); !ok || n != 2 {
+		t.Errorf("Expected 2, got %d and %t", n, ok)
+	}
+	if n, ok := s.Add("b", 2, 3, expires, now); ok || n != 2 {
+		t.Errorf("Expected the quota to stop at 2, got %d and %t", n, ok)
+	}
+	if n, _ := s.Add("b", -2, 3, expires, now); n != 0 {
+		t.Errorf("Expected 0 after giving back, got %d", n)
+	}
+	s.Add("b", 3, 3, expires, now)
+	if n, ok := s.Add("b", 1, 3, now.Add(2*time.Hour), expires); !ok || n != 1 {
+		t.Errorf("Expected the counter to expire, got %d and %t", n, ok)
+	}
+}
+
+func TestParseRouteRateLimit(t *testing.T) {
+	pattern, limit, err := ParseRouteRateLimit("POST /v1/decks 0.5 10")
+	if err != nil || pattern != "POST /v1/decks" || limit != (RateLimit{Rate: 0.5, Burst: 10}) {
+		t.Errorf("Expected POST /v1/decks at 0.5 with 10, got %q %v %v", pattern, limit, err)
+	}
+	for _, s := range []string{"", "POST /v1/decks 1", "POST v1 1 1", "POST /v1/decks x 1", "POST /v1/decks 1 0"} {
+		if _, _, err := ParseRouteRateLimit(s); err == nil {
+			t.Errorf("Expected %q to be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+const (
+	replicationPoll      = 100 * time.Millisecond
+	replicationHeartbeat = time.Second
+	replicationRetry     = time.Second
+	// replicationIdle is how long a follower waits for a line from its
+	// leader, which sends a heartbeat every replicationHeartbeat, before it
+	// drops the connection and reconnects.
+	replicationIdle = 10 * replicationHeartbeat
+)
+
+// replicationClient streams the replication logs of leaders. It has no
+// timeout, since the streams don't end; follow drops the ones that go quiet.
+var replicationClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+
+var errLeaderIdle = errors.New("leader stopped sending heartbeats")
+
+// replicationMessage is a line of the replication stream. Head is the leader's
+// latest sequence number; messages without an event are heartbeats.
+type replicationMessage struct {
+	Head  uint64      `json:"head"`
+	Event *deck.Event `json:"event,omitempty"`
+}
+
+// handleGetReplicationLog streams every event after the since parameter as
+// newline delimited JSON, and then keeps streaming new events as they're
+// recorded until the follower disconnects.
+func handleGetReplicationLog(da *deck.DeckAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var since uint64
+		sinceParam := r.URL.Query().Get("since")
+		if sinceParam != "" {
+			var err error
+			since, err = strconv.ParseUint(sinceParam, 10, 64)
+			if err != nil {
+				respondProblem(w, r, invalidParameter("/query/since", "Invalid since parameter"))
+				return
+			}
+		}
+
+		_, err := da.Head()
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		poll := time.NewTicker(replicationPoll)
+		defer poll.Stop()
+		lastWrite := time.Time{}
+		for {
+			events, err := da.EventsSince(since)
+			if err != nil {
+				return
+			}
+			head := since + uint64(len(events))
+			for _, e := range events {
+				err = enc.Encode(replicationMessage{Head: head, Event: &e})
+				if err != nil {
+					return
+				}
+			}
+			if len(events) > 0 || time.Since(lastWrite) >= replicationHeartbeat {
+				if len(events) == 0 {
+					err = enc.Encode(replicationMessage{Head: head})
+					if err != nil {
+						return
+					}
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+				lastWrite = time.Now()
+			}
+			since = head
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-poll.C:
+			}
+		}
+	})
+}
+
+func handleGetReplicationStatus(da *deck.DeckAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodeJSON(w, http.StatusOK, da.ReplicationStatus())
+	})
+}
+
+func handlePostReplicationPromote(da *deck.DeckAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := forRequest(r.Context(), da).Promote()
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		encodeJSON(w, http.StatusOK, da.ReplicationStatus())
+	})
+}
+
+// Follow streams the replication log of the leader at the given URL into da
+// until ctx is done or da is promoted. Dropped connections are retried. The
+// leader is sent apiKey, which needs to be an admin key if it has any keys.
+func Follow(ctx context.Context, log *slog.Logger, da *deck.DeckAPI, leader, apiKey string) error {
+	leader = strings.TrimRight(leader, "/")
+	err := da.Follow(leader)
+	if err != nil {
+		return err
+	}
+
+	for {
+		err := follow(ctx, da, leader, apiKey, replicationIdle)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !da.Following() {
+			break
+		}
+		if err != nil {
+			log.Error("replication", "status", "disconnected", "leader", leader, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(replicationRetry):
+		}
+	}
+	log.Info("replication", "status", "stopped following", "leader", leader)
+	return nil
+}
+
+// follow streams the replication log of the leader into da until the stream
+// breaks, or nothing comes through it for idle.
+func follow(ctx context.Context, da *deck.DeckAPI, leader, apiKey string, idle time.Duration) (err error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var quiet atomic.Bool
+	timer := time.AfterFunc(idle, func() {
+		quiet.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+	defer func() {
+		if quiet.Load() && parent.Err() == nil {
+			err = fmt.Errorf("%w for %s", errLeaderIdle, idle)
+		}
+	}()
+
+	head, err := da.Head()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/replication/log?since=%d", leader, head), nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set(apiKeyHeader, apiKey)
+	}
+	resp, err := replicationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader responded %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		timer.Reset(idle)
+		var m replicationMessage
+		err := json.Unmarshal(scanner.Bytes(), &m)
+		if err != nil {
+			return fmt.Errorf("decode replication message: %w", err)
+		}
+		if m.Event != nil {
+			err = da.Replicate(*m.Event)
+			if errors.Is(err, deck.ErrNotFollower) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		da.ObserveLeader(m.Head)
+		if !da.Following() {
+			return nil
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	return errors.New("leader closed the stream")
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestFollow(t *testing.T) {
+	// Test a follower replicates the decks of its leader.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	leader := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	server := httptest.NewServer(NewMux(log, leader))
+	defer server.Close()
+
+	d, err := leader.New(true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	follower := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Follow(ctx, log, follower, server.URL, "")
+	}()
+
+	_, err = leader.Draw(d.DeckID, 5)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for follower.ReplicationStatus().AppliedSeq < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r, err := follower.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Deck wasn't replicated: %v", err)
+	}
+	if r.Remaining != 47 {
+		t.Errorf("Expected 47 cards remaining on the follower, got %d", r.Remaining)
+	}
+	s := follower.ReplicationStatus()
+	if s.Role != deck.RoleFollower || s.LagEvents != 0 {
+		t.Errorf("Expected a follower without lag, got %+v", s)
+	}
+
+	// Test a follower rejects mutations.
+	_, err = follower.Draw(d.DeckID, 1)
+	if !errors.Is(err, deck.ErrReadOnly) {
+		t.Errorf("Expected %v, got %v", deck.ErrReadOnly, err)
+	}
+
+	// Test a promoted follower stops following and accepts mutations.
+	req, err := http.NewRequest("POST", "/v1/replication/promote", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(handlePostReplicationPromote(follower))
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %v", rr.Code)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected follower to stop cleanly, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Follower didn't stop after being promoted")
+	}
+
+	_, err = follower.Draw(d.DeckID, 1)
+	if err != nil {
+		t.Errorf("Expected promoted follower to accept draws, got %v", err)
+	}
+
+	// Test a follower drops a leader that goes quiet, whether it stops before
+	// or after responding, so it can reconnect.
+	quiet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("since") != "0" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	}))
+	defer quiet.Close()
+	for _, head := range []bool{true, false} {
+		other := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+		if head {
+			_, err = other.New(false, nil)
+			if err != nil {
+				t.Fatalf("Failed to create deck: %v", err)
+			}
+		}
+		start := time.Now()
+		err = follow(context.Background(), other, quiet.URL, "", 100*time.Millisecond)
+		if !errors.Is(err, errLeaderIdle) || time.Since(start) > time.Second {
+			t.Errorf("Expected %v soon, got %v after %v", errLeaderIdle, err, time.Since(start))
+		}
+	}
+
+	// Test heartbeats keep a follower connected.
+	other := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	err = other.Follow(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to follow: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 2*replicationHeartbeat)
+	defer cancel()
+	err = follow(ctx, other, server.URL, "", replicationHeartbeat+replicationHeartbeat/2)
+	if errors.Is(err, errLeaderIdle) || ctx.Err() == nil {
+		t.Errorf("Expected heartbeats to keep the follower connected, got %v", err)
+	}
+}
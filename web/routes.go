@@ -1,15 +1,88 @@
 package web
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/mtpereira/deck/auth"
 	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/metrics"
 )
 
-func addRoutes(mux *http.ServeMux, log *slog.Logger, da *deck.DeckAPI) {
+// drawDeprecated is when the draw route was renamed to match the API spec.
+var drawDeprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// defaultIdempotencyWindow is how long idempotent responses are kept unless
+// configured otherwise.
+const defaultIdempotencyWindow = 24 * time.Hour
+
+func addRoutes(mux router, log *slog.Logger, da *deck.DeckAPI, opts ...Option) {
+	cfg := config{
+		idempotencyWindow: defaultIdempotencyWindow,
+		maxBodySize:       defaultMaxBodySize,
+		maxImportSize:     defaultMaxImportSize,
+		loginAttempts:     defaultLoginAttempts,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.limiterStore == nil {
+		cfg.limiterStore = newMemoryLimiterStore()
+	}
+
 	logRequests := newLoggerMiddleware(log)
-	mux.Handle("POST /v1/decks", logRequests(handlePostDeck(da)))
-	mux.Handle("GET /v1/decks/{deck_id}", logRequests(handleGetDeck(da)))
-	mux.Handle("POST /v1/decks/{deck_id}/draw/{number}", logRequests(handlePostDeckDraw(da)))
+	limitBodies := newBodyLimitMiddleware(cfg.maxBodySize)
+	limitImports := newBodyLimitMiddleware(cfg.maxImportSize)
+	validate := newValidationMiddleware(log)
+	negotiated := newNegotiationMiddleware()
+	idempotent := newIdempotencyMiddleware(log, newIdempotencyStore(cfg.idempotencyWindow))
+	limited := newRateLimitMiddleware(log, cfg)
+	deckQuota := newDeckQuotaMiddleware(log, cfg, func(*http.Request) int { return 1 })
+	batchQuota := newDeckQuotaMiddleware(log, cfg, batchCreates)
+	authenticated := newAuthMiddleware(log, cfg)
+	canRead := newAccessMiddleware(deckAccess(da, auth.AccessRead))
+	canDraw := newAccessMiddleware(deckAccess(da, auth.AccessDraw))
+	canOwn := newAccessMiddleware(deckAccess(da, auth.AccessOwner))
+	canCreate := newAccessMiddleware(createAccess)
+	isAdmin := newAccessMiddleware(adminAccess)
+	deprecatedDraw := newDeprecatedMiddleware(drawDeprecated, func(r *http.Request) string {
+		return fmt.Sprintf("/v1/decks/%s/cards/%s", r.PathValue("deck_id"), r.PathValue("count"))
+	})
+	reg := metrics.NewRegistry()
+	registerDeckMetrics(reg, da)
+	measured := newMetricsMiddleware(reg)
+	traced := newTracingMiddleware()
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, traced(pattern, measured(pattern, h)))
+	}
+	handle("POST /v1/decks", logRequests(limitBodies(negotiated(authenticated(limited(validate(canCreate(idempotent(deckQuota(handlePostDeck(da)))))))))))
+	handle("GET /v1/decks/{deck_id}", logRequests(limitBodies(negotiated(authenticated(limited(validate(canRead(handleGetDeck(da)))))))))
+	handle("POST /v1/decks/{deck_id}/cards/{count}", logRequests(limitBodies(negotiated(authenticated(limited(validate(canDraw(idempotent(handlePostDeckDraw(da))))))))))
+	handle("POST /v1/decks/{deck_id}/draw/{count}", logRequests(limitBodies(deprecatedDraw(negotiated(authenticated(limited(validate(canDraw(idempotent(handlePostDeckDraw(da)))))))))))
+	handle("POST /v1/decks/{deck_id}/shuffle", logRequests(limitBodies(negotiated(authenticated(limited(validate(canOwn(idempotent(handlePostDeckShuffle(da))))))))))
+	handle("POST /v1/decks/{deck_id}/shares", logRequests(limitBodies(negotiated(authenticated(limited(validate(canOwn(handlePostShare(da, cfg.keyring)))))))))
+	handle("GET /v1/decks/{deck_id}/events", logRequests(limitBodies(negotiated(authenticated(limited(validate(canRead(handleGetDeckEvents(da)))))))))
+	handle("GET /v1/decks/{deck_id}/events/stream", logRequests(limitBodies(authenticated(limited(validate(canRead(handleGetDeckEventsStream(da))))))))
+	handle("GET /v1/decks/{deck_id}/table", logRequests(limitBodies(authenticated(limited(validate(canRead(handleGetTable(log, da))))))))
+	handle("POST /v1/batch", logRequests(limitBodies(negotiated(authenticated(limited(validate(canCreate(idempotent(batchQuota(handlePostBatch(da)))))))))))
+	handle("GET /v1/admin/usage", logRequests(limitBodies(negotiated(authenticated(limited(validate(isAdmin(handleGetUsage(da)))))))))
+	handle("GET /v1/admin/export", logRequests(limitBodies(authenticated(limited(validate(isAdmin(handleGetExport(da))))))))
+	handle("POST /v1/admin/import", logRequests(limitImports(negotiated(authenticated(limited(validate(isAdmin(idempotent(handlePostImport(da))))))))))
+	handle("GET /v1/admin/keys", logRequests(limitBodies(negotiated(authenticated(limited(validate(isAdmin(handleGetKeys(cfg.keyring)))))))))
+	handle("POST /v1/admin/keys", logRequests(limitBodies(negotiated(authenticated(limited(validate(isAdmin(handlePostKey(cfg.keyring)))))))))
+	handle("DELETE /v1/admin/keys/{key_id}", logRequests(limitBodies(negotiated(authenticated(limited(validate(isAdmin(handleDeleteKey(cfg.keyring)))))))))
+	handle("GET /v1/replication/log", logRequests(limitBodies(authenticated(limited(validate(isAdmin(handleGetReplicationLog(da))))))))
+	handle("GET /v1/replication/status", logRequests(limitBodies(negotiated(authenticated(limited(validate(isAdmin(handleGetReplicationStatus(da)))))))))
+	handle("POST /v1/replication/promote", logRequests(limitBodies(negotiated(authenticated(limited(validate(isAdmin(idempotent(handlePostReplicationPromote(da))))))))))
+	handle("GET /openapi.yaml", logRequests(handleGetOpenAPIYAML()))
+	handle("GET /openapi.json", logRequests(handleGetOpenAPIJSON()))
+	handle("GET /docs", logRequests(handleGetDocs()))
+	handle("GET /problems/{code}", logRequests(handleGetProblem()))
+	if cfg.publicMetrics {
+		handle("GET /metrics", logRequests(handleGetMetrics(reg)))
+	} else {
+		handle("GET /metrics", logRequests(limitBodies(authenticated(limited(validate(isAdmin(handleGetMetrics(reg))))))))
+	}
 }
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+const (
+	contentTypeEventStream = "text/event-stream"
+	streamHeartbeat        = 15 * time.Second
+)
+
+// handleGetDeckEventsStream pushes every event of a deck as a Server-Sent
+// Event until the client disconnects. Event IDs are sequence numbers, so with
+// event sourcing enabled a client that reconnects with Last-Event-ID gets the
+// events it missed first.
+func handleGetDeckEventsStream(da *deck.DeckAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deckIDParam := r.PathValue("deck_id")
+		deckID, err := uuid.Parse(deckIDParam)
+		if err != nil {
+			respondProblem(w, r, invalidParameter("/path/deck_id", "Invalid deck ID"))
+			return
+		}
+
+		var last uint64
+		lastParam := r.Header.Get("Last-Event-ID")
+		if lastParam != "" {
+			last, err = strconv.ParseUint(lastParam, 10, 64)
+			if err != nil {
+				respondProblem(w, r, invalidParameter("/header/Last-Event-ID", "Invalid Last-Event-ID header"))
+				return
+			}
+		}
+
+		d, err := da.WithContext(r.Context()).Get(deckID)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		v, err := viewer(r, d)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+
+		// Subscribe before catching up, so no event falls in between.
+		events, unsubscribe := da.Subscribe(deckID)
+		defer unsubscribe()
+
+		var missed []deck.Event
+		if lastParam != "" {
+			missed, err = da.WithContext(r.Context()).History(deckID)
+			if err != nil && !errors.Is(err, deck.ErrEventsDisabled) {
+				respondProblem(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", contentTypeEventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		flush := func() {
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		send := func(e deck.Event) error {
+			if e.Seq != 0 && e.Seq <= last {
+				return nil
+			}
+			data, err := json.Marshal(v.Event(e))
+			if err != nil {
+				return err
+			}
+			if e.Seq != 0 {
+				last = e.Seq
+				_, err = fmt.Fprintf(w, "id: %d\n", e.Seq)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			return err
+		}
+
+		for _, e := range missed {
+			err = send(e)
+			if err != nil {
+				return
+			}
+		}
+		flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-events:
+				if !ok {
+					// Fell too far behind, the client reconnects and
+					// catches up.
+					return
+				}
+				err = send(e)
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			if err != nil {
+				return
+			}
+			flush()
+		}
+	})
+}
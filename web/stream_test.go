@@ -0,0 +1,107 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+// readEvents reads n Server-Sent Events, returning their id and event lines.
+func readEvents(t *testing.T, sc *bufio.Scanner, n int) []string {
+	var events []string
+	var current []string
+	for len(events) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if len(current) > 0 {
+				events = append(events, strings.Join(current, " "))
+			}
+			current = nil
+		case strings.HasPrefix(line, "id: "), strings.HasPrefix(line, "event: "):
+			current = append(current, line)
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("Expected %d events, got %v: %v", n, events, sc.Err())
+	}
+	return events
+}
+
+func TestDeckEventsStream(t *testing.T) {
+	// Test every change of a deck is pushed to the stream.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	server := httptest.NewServer(NewMux(log, da))
+	defer server.Close()
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := func(lastEventID string) *bufio.Scanner {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v1/decks/%s/events/stream", server.URL, d.DeckID), nil)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected a 200 event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewScanner(resp.Body)
+	}
+
+	live := stream("")
+	// The stream subscribes before it responds, so these can't be missed.
+	_, err = da.Draw(d.DeckID, 2)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	_, err = da.Shuffle(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to shuffle deck: %v", err)
+	}
+	events := readEvents(t, live, 2)
+	expected := []string{"id: 2 event: cards_drawn", "id: 3 event: deck_shuffled"}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected event %q, got %q", expected[i], events[i])
+		}
+	}
+
+	// Test a reconnecting client gets the events it missed first.
+	resumed := stream("1")
+	events = readEvents(t, resumed, 2)
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected resumed event %q, got %q", expected[i], events[i])
+		}
+	}
+	_, err = da.Draw(d.DeckID, 1)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	events = readEvents(t, resumed, 1)
+	if events[0] != "id: 4 event: cards_drawn" {
+		t.Errorf("Expected the new draw after the missed events, got %q", events[0])
+	}
+}
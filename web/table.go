@@ -0,0 +1,278 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/mtpereira/deck/auth"
+	"github.com/mtpereira/deck/deck"
+)
+
+const (
+	tablePing         = 15 * time.Second
+	tableWriteTimeout = 10 * time.Second
+	tableMaxCommand   = 4096
+	tableReplyBuffer  = 16
+)
+
+var errNoPlayer error = errors.New("Connect with a player to draw or play cards")
+var errUnknownCommand error = errors.New("Unknown command")
+var errInvalidCommand error = errors.New("Commands must be JSON objects")
+var errInvalidCount error = errors.New("Count must be between 1 and 52")
+var errTooManyCommands error = errors.New("Too many commands in flight, wait for their replies")
+
+// tableCommand is a message a client sends over the table channel. ID is
+// echoed in the reply so clients can match them.
+type tableCommand struct {
+	ID      string   `json:"id,omitempty"`
+	Type    string   `json:"type"`
+	Count   int      `json:"count,omitempty"`
+	Players []string `json:"players,omitempty"`
+	Card    string   `json:"card,omitempty"`
+	Pile    string   `json:"pile,omitempty"`
+}
+
+// tableMessage is a message the server sends over the table channel: the
+// deck state when a client joins, a result or error for every command, and
+// an event with the new deck state for every change made by anyone.
+type tableMessage struct {
+	Type  string      `json:"type"`
+	ID    string      `json:"id,omitempty"`
+	Event *deck.Event `json:"event,omitempty"`
+	Deck  *deckView   `json:"deck,omitempty"`
+	Error *problem    `json:"error,omitempty"`
+}
+
+// handleGetTable upgrades to a WebSocket that players of a deck send draw,
+// deal, play, flip and reveal commands over, and that broadcasts every change
+// of the deck. Clients that fall behind the broadcasts are disconnected, and
+// should reconnect to get the current state.
+func handleGetTable(log *slog.Logger, da *deck.DeckAPI) http.Handler {
+	upgrader := websocket.Upgrader{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deckIDParam := r.PathValue("deck_id")
+		deckID, err := uuid.Parse(deckIDParam)
+		if err != nil {
+			respondProblem(w, r, invalidParameter("/path/deck_id", "Invalid deck ID"))
+			return
+		}
+		player := r.URL.Query().Get("player")
+		if len(player) > 64 {
+			respondProblem(w, r, invalidParameter("/query/player", deck.ErrInvalidName.Error()))
+			return
+		}
+		// Players of bearer tokens sit at the table as themselves.
+		if p, ok := auth.FromContext(r.Context()); ok && player == "" {
+			player = p.Player
+		}
+		err = playAs(r.Context(), player)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		d, err := da.WithContext(r.Context()).Get(deckID)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		v, err := viewer(r, d)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+
+		events, unsubscribe := da.Subscribe(deckID)
+		defer unsubscribe()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader already responded.
+			return
+		}
+		defer conn.Close()
+		log.InfoContext(r.Context(), "table", "status", "joined", "deckID", deckID, "player", player)
+		defer log.InfoContext(r.Context(), "table", "status", "left", "deckID", deckID, "player", player)
+
+		view := newDeckView(d, v)
+		replies := make(chan tableMessage, tableReplyBuffer)
+		replies <- tableMessage{Type: "state", Deck: &view}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			readTable(r.Context(), log, conn, da, deckID, player, v, r.URL.Path, replies)
+		}()
+
+		ping := time.NewTicker(tablePing)
+		defer ping.Stop()
+		for {
+			var msg tableMessage
+			select {
+			case <-done:
+				return
+			case msg = <-replies:
+			case e, ok := <-events:
+				if !ok {
+					closeTable(conn, websocket.CloseTryAgainLater, "Too slow")
+					return
+				}
+				e = v.Event(e)
+				msg = tableMessage{Type: "event", Event: &e}
+				d, err := da.WithContext(r.Context()).Get(deckID)
+				if err == nil {
+					view := newDeckView(d, v)
+					msg.Deck = &view
+				}
+			case <-ping.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(tableWriteTimeout))
+				if err != nil {
+					return
+				}
+				continue
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(tableWriteTimeout))
+			err = conn.WriteJSON(msg)
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// readTable runs the commands a client sends until the connection breaks or
+// the client stops answering pings. Errors are reported as problems of
+// instance, the path of the table. It runs outside the handler, so it
+// recovers from its own panics instead of taking the server down.
+func readTable(ctx context.Context, log *slog.Logger, conn *websocket.Conn, da *deck.DeckAPI, deckID uuid.UUID, player string, v deck.Viewer, instance string, replies chan<- tableMessage) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.ErrorContext(ctx, "table", "status", "panicked", "deckID", deckID, "player", player, "panic", rec, "stack", string(debug.Stack()))
+			closeTable(conn, websocket.CloseInternalServerErr, "Internal error")
+		}
+	}()
+	conn.SetReadLimit(tableMaxCommand)
+	conn.SetReadDeadline(time.Now().Add(2 * tablePing))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * tablePing))
+	})
+
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd tableCommand
+		err = json.Unmarshal(b, &cmd)
+		if err != nil {
+			err = errInvalidCommand
+		} else {
+			err = runTableCommand(ctx, da, deckID, player, v, cmd, replies)
+		}
+		if err != nil {
+			p := newProblem(err, instance)
+			select {
+			case replies <- tableMessage{Type: "error", ID: cmd.ID, Error: &p}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// runTableCommand runs cmd, if the principal in ctx may: drawing, playing and
+// flipping need draw access, and dealing needs to own the deck. Commands are
+// only run while there's room for their reply, which only readTable sends, so
+// a command never runs without its result reaching the client.
+func runTableCommand(ctx context.Context, da *deck.DeckAPI, deckID uuid.UUID, player string, v deck.Viewer, cmd tableCommand, replies chan<- tableMessage) error {
+	if len(replies) == cap(replies) {
+		return errTooManyCommands
+	}
+	need := auth.AccessDraw
+	if cmd.Type == "deal" {
+		need = auth.AccessOwner
+	}
+	d, err := da.WithContext(ctx).Get(deckID)
+	if err != nil {
+		return err
+	}
+	err = authorize(ctx, d, need)
+	if err != nil {
+		return err
+	}
+
+	if (cmd.Type == "draw" || cmd.Type == "deal") && (cmd.Count < 1 || cmd.Count > 52) {
+		return errInvalidCount
+	}
+
+	da = forRequest(ctx, da)
+	switch cmd.Type {
+	case "draw":
+		if player == "" {
+			return errNoPlayer
+		}
+		d, err = da.Deal(deckID, []string{player}, cmd.Count)
+	case "deal":
+		d, err = da.Deal(deckID, cmd.Players, cmd.Count)
+	case "play":
+		if player == "" {
+			return errNoPlayer
+		}
+		var card deck.CardID
+		card, err = deck.ParseCard(cmd.Card)
+		if err != nil {
+			return err
+		}
+		d, err = da.Play(deckID, player, card, cmd.Pile)
+	case "flip":
+		if player == "" {
+			return errNoPlayer
+		}
+		if cmd.Card == "" {
+			d, err = da.FlipTop(deckID, cmd.Pile)
+			break
+		}
+		var card deck.CardID
+		card, err = deck.ParseCard(cmd.Card)
+		if err != nil {
+			return err
+		}
+		// Players only turn over the cards in their own hand.
+		d, err = da.FlipCardAs(deckID, player, card)
+	case "flip_pile":
+		if player == "" {
+			return errNoPlayer
+		}
+		d, err = da.FlipPile(deckID, cmd.Pile)
+	case "reveal":
+		if player == "" {
+			return errNoPlayer
+		}
+		d, err = da.RevealHand(deckID, player)
+	default:
+		return errUnknownCommand
+	}
+	if err != nil {
+		return err
+	}
+
+	view := newDeckView(d, v)
+	select {
+	case replies <- tableMessage{Type: "result", ID: cmd.ID, Deck: &view}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func closeTable(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(tableWriteTimeout))
+}
@@ -0,0 +1,202 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestTable(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	server := httptest.NewServer(NewMux(log, da))
+	defer server.Close()
+
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	join := func(player string) *websocket.Conn {
+		url := fmt.Sprintf("ws%s/v1/decks/%s/table?player=%s", strings.TrimPrefix(server.URL, "http"), d.DeckID, player)
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Failed to join the table: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	// readAll reads a message of every type, in any order: the result of a
+	// command may overtake the broadcast of its event.
+	readAll := func(conn *websocket.Conn, types ...string) map[string]tableMessage {
+		msgs := map[string]tableMessage{}
+		for range types {
+			var msg tableMessage
+			err := conn.ReadJSON(&msg)
+			if err != nil {
+				t.Fatalf("Failed to read a message: %v", err)
+			}
+			msgs[msg.Type] = msg
+		}
+		for _, typ := range types {
+			if _, ok := msgs[typ]; !ok {
+				t.Fatalf("Expected a %s message, got %+v", typ, msgs)
+			}
+		}
+		return msgs
+	}
+	read := func(conn *websocket.Conn, typ string) tableMessage {
+		return readAll(conn, typ)[typ]
+	}
+
+	// Test players get the deck state when they join.
+	ann := join("ann")
+	bob := join("bob")
+	if msg := read(ann, "state"); msg.Deck.Remaining != 52 {
+		t.Errorf("Expected 52 cards remaining, got %d", msg.Deck.Remaining)
+	}
+	read(bob, "state")
+
+	// Test a deal is answered and broadcast to everyone at the table.
+	err = ann.WriteJSON(tableCommand{ID: "1", Type: "deal", Players: []string{"ann", "bob"}, Count: 2})
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	msgs := readAll(ann, "event", "result")
+	for _, msg := range []tableMessage{msgs["event"], read(bob, "event")} {
+		if msg.Event.Type != deck.EventCardsDealt || msg.Deck.Remaining != 48 {
+			t.Errorf("Expected a deal leaving 48 cards, got %+v", msg)
+		}
+	}
+	msg := msgs["result"]
+	if msg.ID != "1" {
+		t.Errorf("Expected the result of command 1, got %q", msg.ID)
+	}
+	expected := []deck.Card{{Code: "2C", Orientation: deck.FaceDown}, {Code: "4C", Orientation: deck.FaceDown}}
+	if hand := msg.Deck.Hands["ann"]; len(hand) != 2 || hand[0] != expected[0] || hand[1] != expected[1] {
+		t.Errorf("Expected ann to hold %v, got %v", expected, hand)
+	}
+
+	// Test playing a card moves it from the hand to the pile.
+	err = bob.WriteJSON(tableCommand{ID: "2", Type: "play", Card: "3C", Pile: "discard"})
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	msg = read(ann, "event")
+	if msg.Event.Type != deck.EventCardPlayed || len(msg.Deck.Piles["discard"]) != 1 || len(msg.Deck.Hands["bob"]) != 1 {
+		t.Errorf("Expected 3C on the discard pile, got %+v", msg.Deck)
+	}
+	readAll(bob, "event", "result")
+
+	// Test commands that fail get an error, and don't change the deck.
+	err = bob.WriteJSON(tableCommand{ID: "3", Type: "play", Card: "2C", Pile: "discard"})
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	msg = read(bob, "error")
+	if msg.ID != "3" || msg.Error.Code != "card_not_in_hand" {
+		t.Errorf("Expected command 3 to fail with %v, got %+v", deck.ErrCardNotInHand, msg.Error)
+	}
+	// Test counts are bounded, so they can't overflow when multiplied by the
+	// players.
+	err = ann.WriteMessage(websocket.TextMessage, []byte(`{"id":"7","type":"deal","players":["ann","bob"],"count":4611686018427387905}`))
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	msg = read(ann, "error")
+	if msg.ID != "7" || msg.Error.Code != "invalid_command" {
+		t.Errorf("Expected command 7 to fail with an invalid_command problem, got %+v", msg.Error)
+	}
+	err = bob.WriteMessage(websocket.TextMessage, []byte("draw"))
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	read(bob, "error")
+
+	// Test changes made over HTTP are broadcast too.
+	_, err = da.Draw(d.DeckID, 1)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	if msg := read(bob, "event"); msg.Event.Type != deck.EventCardsDrawn {
+		t.Errorf("Expected a draw, got %v", msg.Event.Type)
+	}
+	read(ann, "event")
+
+	// Test players turn their own cards face up for everyone to see, and
+	// piles face down.
+	err = bob.WriteJSON(tableCommand{ID: "4", Type: "flip", Card: "5C"})
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	msg = read(ann, "event")
+	if hand := msg.Deck.Hands["bob"]; len(hand) != 1 || hand[0].Code != "5C" || hand[0].Orientation != deck.FaceUp {
+		t.Errorf("Expected bob's 5C face up, got %v", hand)
+	}
+	readAll(bob, "event", "result")
+	err = bob.WriteJSON(tableCommand{ID: "5", Type: "flip", Card: "2C"})
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	notInHand := read(bob, "error").Error
+	if notInHand.Code != "card_not_in_hand" {
+		t.Errorf("Expected bob not to flip ann's card, got %+v", notInHand)
+	}
+	err = bob.WriteJSON(tableCommand{ID: "6", Type: "flip_pile", Pile: "discard"})
+	if err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	msg = read(ann, "event")
+	if pile := msg.Deck.Piles["discard"]; len(pile) != 1 || !pile[0].Hidden || pile[0].Orientation != deck.FaceDown {
+		t.Errorf("Expected the discard pile face down, got %v", pile)
+	}
+	readAll(bob, "event", "result")
+
+	// Test cards in the deck and face down in piles can't be told apart from
+	// cards in other hands.
+	for _, card := range []string{"AS", "3C"} {
+		err = bob.WriteJSON(tableCommand{ID: "7", Type: "flip", Card: card})
+		if err != nil {
+			t.Fatalf("Failed to send command: %v", err)
+		}
+		if p := read(bob, "error").Error; p.Code != notInHand.Code || p.Status != notInHand.Status || p.Detail != notInHand.Detail {
+			t.Errorf("%s: expected %+v, got %+v", card, notInHand, p)
+		}
+	}
+
+	// Test a command isn't run while there's no room for its reply.
+	before, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	replies := make(chan tableMessage, 1)
+	replies <- tableMessage{Type: "state"}
+	err = runTableCommand(context.Background(), da, d.DeckID, "bob", deck.Viewer{All: true}, tableCommand{Type: "draw", Count: 1}, replies)
+	if !errors.Is(err, errTooManyCommands) || ErrorStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("Expected %v with status 429, got %v", errTooManyCommands, err)
+	}
+	<-replies
+	err = runTableCommand(context.Background(), da, d.DeckID, "bob", deck.Viewer{All: true}, tableCommand{Type: "draw", Count: 1}, replies)
+	if err != nil {
+		t.Fatalf("Failed to draw: %v", err)
+	}
+	after, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Failed to get deck: %v", err)
+	}
+	if reply := <-replies; reply.Type != "result" || after.Remaining != before.Remaining-1 {
+		t.Errorf("Expected one card drawn and its result, got %d cards left and %v", after.Remaining, reply)
+	}
+}
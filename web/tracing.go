@@ -0,0 +1,44 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/mtpereira/deck/web")
+
+// newTracingMiddleware starts a span for every request to a route, named
+// after the pattern it's registered with, continuing the trace of the
+// traceparent header if the request has one. The DeckAPI operations and
+// store calls of the request are part of it.
+func newTracingMiddleware() func(pattern string, h http.Handler) http.Handler {
+	return func(pattern string, h http.Handler) http.Handler {
+		method, route, _ := strings.Cut(pattern, " ")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			attrs := []attribute.KeyValue{
+				attribute.String("http.request.method", method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+			}
+			if deckID := r.PathValue("deck_id"); deckID != "" {
+				attrs = append(attrs, attribute.String("deck.id", deckID))
+			}
+			ctx, span := tracer.Start(ctx, pattern, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+			defer span.End()
+
+			sw := &statusWriter{ResponseWriter: w}
+			h.ServeHTTP(sw, r.WithContext(ctx))
+			span.SetAttributes(attribute.Int("http.response.status_code", sw.Status()))
+			if sw.Status() >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(sw.Status()))
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mtpereira/deck/deck"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	mux := NewMux(log, da)
+
+	// Test the request continues the trace of its traceparent, and the
+	// DeckAPI operation and store calls are part of it.
+	req := httptest.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/cards/2", d.DeckID), nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %v", rr.Code)
+	}
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range spans.Ended() {
+		if s.SpanContext().TraceID().String() == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			byName[s.Name()] = s
+		}
+	}
+	request, draw, update := byName["POST /v1/decks/{deck_id}/cards/{count}"], byName["DeckAPI.Draw"], byName["DeckStore.Update"]
+	if request == nil || draw == nil || update == nil {
+		t.Fatalf("Expected request, Draw and Update spans in the trace, got %v", byName)
+	}
+	if parent := request.Parent().SpanID().String(); parent != "00f067aa0ba902b7" {
+		t.Errorf("Expected the request span to be a child of the caller's, got %s", parent)
+	}
+	if draw.Parent().SpanID() != request.SpanContext().SpanID() || update.Parent().SpanID() != draw.SpanContext().SpanID() {
+		t.Errorf("Expected the Update span under the Draw span, under the request span")
+	}
+	for _, expected := range []struct {
+		span sdktrace.ReadOnlySpan
+		attr attribute.KeyValue
+	}{
+		{request, attribute.String("deck.id", d.DeckID.String())},
+		{request, attribute.Int("http.response.status_code", http.StatusOK)},
+		{draw, attribute.String("deck.id", d.DeckID.String())},
+		{draw, attribute.String("deck.operation", "Draw")},
+		{draw, attribute.Int("deck.cards", 2)},
+		{update, attribute.Int("deck.cards", 50)},
+	} {
+		found := false
+		for _, attr := range expected.span.Attributes() {
+			found = found || attr == expected.attr
+		}
+		if !found {
+			t.Errorf("Expected %s in the attributes of %s, got %v", expected.attr.Key, expected.span.Name(), expected.span.Attributes())
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openAPI is the part of an OpenAPI 3.0 document requests are validated
+// against.
+type openAPI struct {
+	Paths      map[string]map[string]operation `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type operation struct {
+	Parameters  []parameter         `yaml:"parameters"`
+	RequestBody *requestBody        `yaml:"requestBody"`
+	Responses   map[string]response `yaml:"responses"`
+}
+
+type parameter struct {
+	In       string  `yaml:"in"`
+	Name     string  `yaml:"name"`
+	Required bool    `yaml:"required"`
+	Schema   *schema `yaml:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `yaml:"required"`
+	Content  map[string]mediaType `yaml:"content"`
+}
+
+type response struct {
+	Headers map[string]any       `yaml:"headers"`
+	Content map[string]mediaType `yaml:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `yaml:"schema"`
+}
+
+type schema struct {
+	Ref         string             `yaml:"$ref"`
+	Type        string             `yaml:"type"`
+	Format      string             `yaml:"format"`
+	Pattern     string             `yaml:"pattern"`
+	Enum        []any              `yaml:"enum"`
+	Nullable    bool               `yaml:"nullable"`
+	Required    []string           `yaml:"required"`
+	Properties  map[string]*schema `yaml:"properties"`
+	Items       *schema            `yaml:"items"`
+	Minimum     *float64           `yaml:"minimum"`
+	Maximum     *float64           `yaml:"maximum"`
+	MinItems    *int               `yaml:"minItems"`
+	MaxItems    *int               `yaml:"maxItems"`
+	UniqueItems bool               `yaml:"uniqueItems"`
+}
+
+// violation is a part of a request or response that doesn't match the API
+// spec. Pointer is a JSON pointer into the request, rooted at its location,
+// such as /path/deck_id or /body/0/code.
+type violation struct {
+	Pointer string `json:"pointer"`
+	Detail  string `json:"detail"`
+}
+
+func (v violation) String() string {
+	return v.Pointer + ": " + v.Detail
+}
+
+// loadSpec decodes the embedded API spec once.
+var loadSpec = sync.OnceValues(func() (*openAPI, error) {
+	doc, err := loadSpecNode()
+	if err != nil {
+		return nil, err
+	}
+	var spec openAPI
+	err = doc.Decode(&spec)
+	if err != nil {
+		return nil, fmt.Errorf("decode api spec: %w", err)
+	}
+	return &spec, nil
+})
+
+var patterns sync.Map
+
+func (spec *openAPI) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		s = spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// validate returns every violation of the schema found in v, a value decoded
+// from JSON.
+func (spec *openAPI) validate(s *schema, v any, ptr string) []violation {
+	s = spec.resolve(s)
+	if s == nil {
+		return []violation{{ptr, "has no schema"}}
+	}
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return []violation{{ptr, "is null"}}
+	}
+
+	var vs []violation
+	switch s.Type {
+	case "object":
+		o, ok := v.(map[string]any)
+		if !ok {
+			return []violation{{ptr, "isn't an object"}}
+		}
+		for _, name := range s.Required {
+			if _, ok := o[name]; !ok {
+				vs = append(vs, violation{ptr + "/" + name, "is required"})
+			}
+		}
+		names := make([]string, 0, len(o))
+		for name := range o {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			if !ok && s.Properties == nil {
+				// Objects without properties are free-form.
+				continue
+			}
+			if !ok {
+				vs = append(vs, violation{ptr + "/" + name, "isn't a known property"})
+				continue
+			}
+			vs = append(vs, spec.validate(p, o[name], ptr+"/"+name)...)
+		}
+	case "array":
+		a, ok := v.([]any)
+		if !ok {
+			return []violation{{ptr, "isn't an array"}}
+		}
+		if s.MinItems != nil && len(a) < *s.MinItems {
+			vs = append(vs, violation{ptr, fmt.Sprintf("has less than %d items", *s.MinItems)})
+		}
+		if s.MaxItems != nil && len(a) > *s.MaxItems {
+			vs = append(vs, violation{ptr, fmt.Sprintf("has more than %d items", *s.MaxItems)})
+		}
+		seen := map[string]bool{}
+		for i, item := range a {
+			itemPtr := fmt.Sprintf("%s/%d", ptr, i)
+			if s.UniqueItems {
+				b, _ := json.Marshal(item)
+				if seen[string(b)] {
+					vs = append(vs, violation{itemPtr, "is duplicated"})
+				}
+				seen[string(b)] = true
+			}
+			vs = append(vs, spec.validate(s.Items, item, itemPtr)...)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || (s.Type == "integer" && n != math.Trunc(n)) {
+			return []violation{{ptr, "isn't an " + s.Type}}
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			vs = append(vs, violation{ptr, fmt.Sprintf("is less than %v", *s.Minimum)})
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			vs = append(vs, violation{ptr, fmt.Sprintf("is more than %v", *s.Maximum)})
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []violation{{ptr, "isn't a boolean"}}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []violation{{ptr, "isn't a string"}}
+		}
+		if s.Pattern != "" && !compilePattern(s.Pattern).MatchString(str) {
+			vs = append(vs, violation{ptr, "doesn't match " + s.Pattern})
+		}
+		switch s.Format {
+		case "uuid":
+			if _, err := uuid.Parse(str); err != nil {
+				vs = append(vs, violation{ptr, "isn't a uuid"})
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				vs = append(vs, violation{ptr, "isn't a date-time"})
+			}
+		}
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		vs = append(vs, violation{ptr, fmt.Sprintf("isn't one of %v", s.Enum)})
+	}
+	return vs
+}
+
+func compilePattern(p string) *regexp.Regexp {
+	if re, ok := patterns.Load(p); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(p)
+	patterns.Store(p, re)
+	return re
+}
+
+// validateParam converts a path or query parameter to the type in its schema
+// before validating it.
+func (spec *openAPI) validateParam(p parameter, raw string) []violation {
+	ptr := fmt.Sprintf("/%s/%s", p.In, p.Name)
+	var v any = raw
+	switch spec.resolve(p.Schema).Type {
+	case "integer", "number":
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return []violation{{ptr, "isn't a number"}}
+		}
+		v = n
+	case "boolean":
+		if raw != "true" && raw != "false" {
+			return []violation{{ptr, "isn't a boolean"}}
+		}
+		v = raw == "true"
+	}
+	return spec.validate(p.Schema, v, ptr)
+}
+
+// validateBody validates a JSON or NDJSON body against a media type. Bodies
+// of any other media type aren't validated.
+func (spec *openAPI) validateBody(contentType string, mt mediaType, body []byte) []violation {
+	values := [][]byte{body}
+	ndjson := false
+	switch contentType {
+	case "application/json", contentTypeProblem:
+	case contentTypeNDJSON:
+		values = bytes.Split(bytes.TrimRight(body, "\n"), []byte("\n"))
+		ndjson = true
+	default:
+		return nil
+	}
+
+	var vs []violation
+	for i, b := range values {
+		ptr := "/body"
+		if ndjson {
+			ptr = fmt.Sprintf("/body/%d", i)
+		}
+		var v any
+		err := json.Unmarshal(b, &v)
+		if err != nil {
+			vs = append(vs, violation{ptr, "isn't valid JSON"})
+			continue
+		}
+		vs = append(vs, spec.validate(mt.Schema, v, ptr)...)
+	}
+	return vs
+}
+
+// validateRequest checks the parameters and body of a request against an
+// operation. The request path values must have been set by the mux.
+func (spec *openAPI) validateRequest(op operation, r *http.Request) ([]violation, error) {
+	var vs []violation
+	for _, p := range op.Parameters {
+		var raw string
+		switch p.In {
+		case "path":
+			raw = r.PathValue(p.Name)
+		case "query":
+			raw = r.URL.Query().Get(p.Name)
+		case "header":
+			raw = r.Header.Get(p.Name)
+		default:
+			continue
+		}
+		if raw == "" {
+			if p.Required {
+				vs = append(vs, violation{fmt.Sprintf("/%s/%s", p.In, p.Name), "is required"})
+			}
+			continue
+		}
+		vs = append(vs, spec.validateParam(p, raw)...)
+	}
+
+	if op.RequestBody == nil || r.Body == nil {
+		return vs, nil
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			vs = append(vs, violation{"/body", "is required"})
+		}
+		return vs, nil
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	mt, ok := op.RequestBody.Content[contentType]
+	if !ok && contentType == "" && len(op.RequestBody.Content) == 1 {
+		// Requests without a content type get the only one there is.
+		for ct, m := range op.RequestBody.Content {
+			contentType, mt, ok = ct, m, true
+		}
+	}
+	if !ok {
+		return append(vs, violation{"/header/Content-Type", "isn't a supported content type"}), nil
+	}
+	return append(vs, spec.validateBody(contentType, mt, body)...), nil
+}
+
+// newValidationMiddleware rejects requests that don't match the API spec
+// before they reach the handler, with a 400 listing every violation. Routes
+// the spec doesn't describe aren't validated.
+func newValidationMiddleware(log *slog.Logger) func(h http.Handler) http.Handler {
+	spec, err := loadSpec()
+	if err != nil {
+		log.Error("api", "validation", "disabled", "error", err)
+		return func(h http.Handler) http.Handler { return h }
+	}
+
+	operations := http.NewServeMux()
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			operations.Handle(strings.ToUpper(method)+" "+path, http.NotFoundHandler())
+		}
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := operations.Handler(r)
+			method, path, _ := strings.Cut(pattern, " ")
+			op, ok := spec.Paths[path][strings.ToLower(method)]
+			if !ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			vs, err := spec.validateRequest(op, r)
+			if errors.Is(err, errBodyTooLarge) {
+				respondProblem(w, r, err)
+				return
+			}
+			if err != nil {
+				respondProblem(w, r, invalidRequest("/body", "Couldn't read the request"))
+				return
+			}
+			if len(vs) > 0 {
+				// Requests with nothing wrong but their parameters get the
+				// more specific invalid_parameter problem.
+				err := errInvalidParameter
+				for _, v := range vs {
+					if strings.HasPrefix(v.Pointer, "/body") {
+						err = errInvalidRequest
+					}
+				}
+				respondProblem(w, r, &requestError{err: err, detail: "Request doesn't match the API spec", violations: vs})
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
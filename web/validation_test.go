@@ -0,0 +1,133 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mtpereira/deck/deck"
+)
+
+func TestValidationMiddleware(t *testing.T) {
+	// Test every invalid path parameter is reported.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	mux := NewMux(log, da)
+
+	req, err := http.NewRequest("POST", "/v1/decks/not-a-uuid/cards/53", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %v", rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != contentTypeProblem {
+		t.Errorf("Expected a %s response, got %s", contentTypeProblem, rr.Header().Get("Content-Type"))
+	}
+	var er problem
+	err = json.NewDecoder(rr.Body).Decode(&er)
+	if err != nil {
+		t.Fatalf("Expected to get an error response, got %v", err)
+	}
+	if er.Code != "invalid_parameter" {
+		t.Errorf("Expected invalid_parameter, got %s", er.Code)
+	}
+	expected := []violation{
+		{Pointer: "/path/deck_id", Detail: "isn't a uuid"},
+		{Pointer: "/path/count", Detail: "is more than 52"},
+	}
+	if !reflect.DeepEqual(er.Errors, expected) {
+		t.Errorf("Expected violations %v, got %v", expected, er.Errors)
+	}
+
+	// Test every invalid body value is reported with its pointer.
+	req, err = http.NewRequest("POST", "/v1/decks?shuffled=yes", strings.NewReader(`[{"code":"AS"},{"code":"1Z"},{"code":"AS"}]`))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %v", rr.Code)
+	}
+	er = problem{}
+	err = json.NewDecoder(rr.Body).Decode(&er)
+	if err != nil {
+		t.Fatalf("Expected to get an error response, got %v", err)
+	}
+	if er.Code != "invalid_request" {
+		t.Errorf("Expected invalid_request, got %s", er.Code)
+	}
+	pointers := []string{}
+	for _, v := range er.Errors {
+		pointers = append(pointers, v.Pointer)
+	}
+	expectedPointers := []string{"/query/shuffled", "/body/1/code", "/body/2"}
+	if !reflect.DeepEqual(pointers, expectedPointers) {
+		t.Errorf("Expected violations at %v, got %v", expectedPointers, er.Errors)
+	}
+
+	// Test valid requests reach the handler with their body intact.
+	req, err = http.NewRequest("POST", "/v1/decks", strings.NewReader(`[{"code":"AS"},{"code":"KH"}]`))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %v: %s", rr.Code, rr.Body.String())
+	}
+	d, err := decodeDeck(rr.Body)
+	if err != nil {
+		t.Fatalf("Expected to get a Deck, got %v", err)
+	}
+	if d.Remaining != 2 {
+		t.Errorf("Expected a deck with 2 cards, got %d", d.Remaining)
+	}
+
+	// Test the request content type has to be in the spec.
+	req, err = http.NewRequest("POST", "/v1/decks", strings.NewReader(`AS KH`))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %v", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "/header/Content-Type") {
+		t.Errorf("Expected a content type violation, got %s", rr.Body.String())
+	}
+
+	// Test bodies over the limit get a 413 before they're read whole.
+	mux = NewMux(log, da, WithMaxBodySize(64))
+	req, err = http.NewRequest("POST", "/v1/decks", strings.NewReader("["+strings.Repeat(`{"code":"AS"},`, 10)+"]"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "body_too_large") {
+		t.Errorf("Expected a 413 body_too_large problem, got %v: %s", rr.Code, rr.Body.String())
+	}
+}
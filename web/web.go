@@ -4,41 +4,104 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mtpereira/deck/auth"
 	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/logging"
 )
 
-func NewMux(log *slog.Logger, da *deck.DeckAPI) *http.ServeMux {
+// router is the part of http.ServeMux that routes are registered with.
+type router interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// config holds the optional settings of the routes.
+type config struct {
+	idempotencyWindow time.Duration
+	rateLimit         RateLimit
+	routeRateLimits   map[string]RateLimit
+	dailyDecks        int
+	loginAttempts     int
+	limiterStore      LimiterStore
+	keyring           *auth.Keyring
+	verifier          *auth.Verifier
+	maxBodySize       int64
+	maxImportSize     int64
+	publicMetrics     bool
+}
+
+// Option configures optional route settings.
+type Option func(cfg *config)
+
+// WithIdempotencyWindow sets how long responses to requests with an
+// Idempotency-Key header are kept for replaying. Zero disables idempotency
+// keys.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(cfg *config) {
+		cfg.idempotencyWindow = window
+	}
+}
+
+func NewMux(log *slog.Logger, da *deck.DeckAPI, opts ...Option) *http.ServeMux {
 	mux := http.NewServeMux()
-	addRoutes(mux, log, da)
+	addRoutes(mux, log, da, opts...)
 	return mux
 }
 
-type wrappedWriter struct {
-	responseWriter http.ResponseWriter
-	statusCode     int
-}
+// requestIDHeader carries the ID of a request, which clients can set to
+// follow their requests through the logs, and which is made up otherwise.
+const requestIDHeader = "X-Request-ID"
 
-func (w *wrappedWriter) WriteHeader(statusCode int) {
-	w.responseWriter.WriteHeader(statusCode)
-	w.statusCode = statusCode
+// requestID returns the ID the client gave r, if it's short and printable
+// enough to be logged, or a new one.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 || strings.ContainsFunc(id, func(c rune) bool { return c < '!' || c > '~' }) {
+		return uuid.NewString()
+	}
+	return id
 }
 
+// newLoggerMiddleware logs every request with its status and size, under
+// its request ID, which is also passed on in the request context and the
+// response. Handlers that panic are logged and get a 500 problem, if they
+// hadn't responded yet.
 func newLoggerMiddleware(log *slog.Logger) func(h http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
-			wr := wrappedWriter{
-				responseWriter: w,
-				statusCode:     http.StatusOK,
+			id := requestID(r)
+			w.Header().Set(requestIDHeader, id)
+			ctx := logging.WithRequestID(r.Context(), id)
+			r = r.WithContext(ctx)
+			sw := &statusWriter{ResponseWriter: w}
+
+			attrs := []any{"request", r.URL.Path, "method", r.Method}
+			if deckID := r.PathValue("deck_id"); deckID != "" {
+				attrs = append(attrs, "deckID", deckID)
 			}
-			h.ServeHTTP(wr.responseWriter, r)
-			log.Info("api", "request", r.URL.Path, "status", wr.statusCode, "duration", time.Since(start))
+			defer func() {
+				v := recover()
+				if v != nil && v != http.ErrAbortHandler {
+					log.ErrorContext(ctx, "api", append(attrs, "panic", v, "stack", string(debug.Stack()))...)
+					if sw.status == 0 {
+						respondProblem(sw, r, errPanicked)
+					}
+				}
+				log.InfoContext(ctx, "api", append(attrs, "status", sw.Status(), "size", sw.size, "duration", time.Since(start))...)
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+			}()
+			h.ServeHTTP(sw, r)
 		})
 	}
 }
@@ -55,11 +118,21 @@ func handlePostDeck(da *deck.DeckAPI) http.Handler {
 		shuffled := false
 		err := getParam(&shuffled, shuffledParam, "true", "false")
 		if err != nil {
-			encodeJSON(w, http.StatusBadRequest, respondError(http.StatusBadRequest, "Invalid shuffled parameter"))
+			respondProblem(w, r, invalidParameter("/query/shuffled", "Invalid shuffled parameter"))
 			return
 		}
 
-		d := da.New(shuffled, nil)
+		cards, err := decodeCardsBody(r)
+		if err != nil {
+			respondProblem(w, r, invalidRequest("/body", "Invalid cards: "+err.Error()))
+			return
+		}
+
+		d, err := forRequest(r.Context(), da).NewOwned(owner(r.Context()), shuffled, cards)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
 		encodeJSON(w, http.StatusOK, deckResponse{
 			DeckID:    d.DeckID,
 			Shuffled:  d.Shuffled,
@@ -68,27 +141,58 @@ func handlePostDeck(da *deck.DeckAPI) http.Handler {
 	})
 }
 
-func handleGetDeck(da *deck.DeckAPI) http.Handler {
-	type deckResponse struct {
-		DeckID    uuid.UUID   `json:"deck_id"`
-		Shuffled  bool        `json:"shuffled"`
-		Remaining int         `json:"remaining"`
-		Cards     []deck.Card `json:"cards"`
+// deckView is a deck with the cards it holds that its viewer can see.
+type deckView struct {
+	DeckID    uuid.UUID              `json:"deck_id"`
+	Shuffled  bool                   `json:"shuffled"`
+	Remaining int                    `json:"remaining"`
+	Cards     []deck.Card            `json:"cards,omitempty"`
+	Hands     map[string][]deck.Card `json:"hands,omitempty"`
+	Piles     map[string][]deck.Card `json:"piles,omitempty"`
+}
+
+func newDeckView(d *deck.Deck, v deck.Viewer) deckView {
+	return deckView{
+		DeckID:    d.DeckID,
+		Shuffled:  d.Shuffled,
+		Remaining: d.Remaining,
+		Cards:     v.Cards(*d),
+		Hands:     v.Hands(*d),
+		Piles:     v.Piles(*d),
 	}
+}
+
+func handleGetDeck(da *deck.DeckAPI) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		deckIDParam := r.PathValue("deck_id")
 		deckID, err := uuid.Parse(deckIDParam)
 		if err != nil {
-			encodeJSON(w, http.StatusBadRequest, respondError(http.StatusBadRequest, "Invalid deck ID"))
+			respondProblem(w, r, invalidParameter("/path/deck_id", "Invalid deck ID"))
 			return
 		}
 
-		d, err := da.Get(deckID)
+		var d *deck.Deck
+		atParam := r.URL.Query().Get("at")
+		if atParam == "" {
+			d, err = da.WithContext(r.Context()).Get(deckID)
+		} else {
+			at, perr := strconv.ParseUint(atParam, 10, 64)
+			if perr != nil || at == 0 {
+				respondProblem(w, r, invalidParameter("/query/at", "Invalid at parameter"))
+				return
+			}
+			d, err = da.WithContext(r.Context()).GetAt(deckID, at)
+		}
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		v, err := viewer(r, d)
 		if err != nil {
-			encodeJSON(w, http.StatusNotFound, respondError(http.StatusNotFound, err.Error()))
+			respondProblem(w, r, err)
 			return
 		}
-		encodeJSON(w, http.StatusOK, deckResponse(*d))
+		encodeJSON(w, http.StatusOK, newDeckView(d, v))
 	})
 }
 
@@ -101,35 +205,132 @@ func handlePostDeckDraw(da *deck.DeckAPI) http.Handler {
 		deckIDParam := r.PathValue("deck_id")
 		deckID, err := uuid.Parse(deckIDParam)
 		if err != nil {
-			encodeJSON(w, http.StatusBadRequest, respondError(http.StatusBadRequest, "Invalid deck ID"))
+			respondProblem(w, r, invalidParameter("/path/deck_id", "Invalid deck ID"))
 			return
 		}
 
-		numberParam := r.PathValue("number")
-		cardsToDraw, err := strconv.Atoi(numberParam)
+		countParam := r.PathValue("count")
+		cardsToDraw, err := strconv.Atoi(countParam)
 		if err != nil {
-			encodeJSON(w, http.StatusBadRequest, respondError(http.StatusBadRequest, "Invalid number of cards to draw"))
+			respondProblem(w, r, invalidParameter("/path/count", "Invalid number of cards to draw"))
 			return
 		}
-		if cardsToDraw < 0 || cardsToDraw > 52 {
-			encodeJSON(w, http.StatusBadRequest, respondError(http.StatusBadRequest, "Invalid number of cards to draw"))
+		if cardsToDraw < 1 || cardsToDraw > 52 {
+			respondProblem(w, r, invalidParameter("/path/count", "Invalid number of cards to draw"))
 			return
 		}
 
-		cards, err := da.Draw(deckID, cardsToDraw)
+		cards, err := forRequest(r.Context(), da).Draw(deckID, cardsToDraw)
 		if err != nil {
-			if errors.Is(err, deck.ErrUnsufficientCards) {
-				encodeJSON(w, http.StatusBadRequest, respondError(http.StatusBadRequest, err.Error()))
-				return
-			}
-			encodeJSON(w, http.StatusInternalServerError, respondError(http.StatusInternalServerError, err.Error()))
+			respondProblem(w, r, err)
+			return
+		}
+
+		encodeJSON(w, http.StatusOK, cardsResponse{Cards: deck.Expand(cards)})
+	})
+}
+
+func handlePostDeckShuffle(da *deck.DeckAPI) http.Handler {
+	type deckResponse struct {
+		DeckID    uuid.UUID `json:"deck_id"`
+		Shuffled  bool      `json:"shuffled"`
+		Remaining int       `json:"remaining"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deckIDParam := r.PathValue("deck_id")
+		deckID, err := uuid.Parse(deckIDParam)
+		if err != nil {
+			respondProblem(w, r, invalidParameter("/path/deck_id", "Invalid deck ID"))
 			return
 		}
 
-		encodeJSON(w, http.StatusOK, cardsResponse{Cards: cards})
+		d, err := forRequest(r.Context(), da).Shuffle(deckID)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		encodeJSON(w, http.StatusOK, deckResponse{
+			DeckID:    d.DeckID,
+			Shuffled:  d.Shuffled,
+			Remaining: d.Remaining,
+		})
 	})
 }
 
+func handleGetDeckEvents(da *deck.DeckAPI) http.Handler {
+	type eventsResponse struct {
+		Events []deck.Event `json:"events"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deckIDParam := r.PathValue("deck_id")
+		deckID, err := uuid.Parse(deckIDParam)
+		if err != nil {
+			respondProblem(w, r, invalidParameter("/path/deck_id", "Invalid deck ID"))
+			return
+		}
+
+		events, err := da.WithContext(r.Context()).History(deckID)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		d, err := da.WithContext(r.Context()).Get(deckID)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		v, err := viewer(r, d)
+		if err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+		encodeJSON(w, http.StatusOK, eventsResponse{Events: v.Events(events)})
+	})
+}
+
+// decodeCardsBody reads the optional list of cards a deck is created with. An
+// empty or null body means a full deck.
+func decodeCardsBody(r *http.Request) ([]deck.CardID, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	var cards []deck.CardID
+	err := json.NewDecoder(r.Body).Decode(&cards)
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cards) > 52 {
+		return nil, errors.New("too many cards")
+	}
+	seen := make(map[deck.CardID]bool, len(cards))
+	for _, c := range cards {
+		if seen[c] {
+			return nil, fmt.Errorf("duplicated card %s", c.Card().Code)
+		}
+		seen[c] = true
+	}
+	return cards, nil
+}
+
+// newDeprecatedMiddleware marks the responses of a deprecated route with a
+// Deprecation header, as defined by RFC 9745, and links to the route that
+// replaces it.
+func newDeprecatedMiddleware(since time.Time, successor func(r *http.Request) string) func(h http.Handler) http.Handler {
+	deprecation := fmt.Sprintf("@%d", since.Unix())
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", deprecation)
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor(r)))
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
 func getParam(param any, paramString string, validValues ...string) error {
 	if paramString == "" {
 		return nil
@@ -157,15 +358,3 @@ func encodeJSON[T any](w http.ResponseWriter, status int, v T) error {
 	}
 	return nil
 }
-
-func respondError(errorCode int, message string) errorResponse {
-	return errorResponse{
-		Code:    errorCode,
-		Message: message,
-	}
-}
-
-type errorResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-}
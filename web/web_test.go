@@ -3,6 +3,7 @@ package web
 import (
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
@@ -13,24 +14,29 @@ import (
 	"testing"
 
 	"github.com/mtpereira/deck/deck"
+	"github.com/mtpereira/deck/logging"
 )
 
 func Test_handlePostDeckDraw(t *testing.T) {
 	// Test it correctly draws a card.
 	var deckID string
-	da := deck.NewAPI(slog.New(slog.NewTextHandler(io.Discard, nil)))
-	d := da.New(false, nil)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
 	deckID = d.DeckID.String()
 	cardsToDraw := 1
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/draw/%d", deckID, cardsToDraw), nil)
+	req, err := http.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/cards/%d", deckID, cardsToDraw), nil)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 
 	rr := httptest.NewRecorder()
 	handler := http.NewServeMux()
-	handler.Handle("POST /v1/decks/{deck_id}/draw/{number}", handlePostDeckDraw(da))
+	handler.Handle("POST /v1/decks/{deck_id}/cards/{count}", handlePostDeckDraw(da))
 
 	handler.ServeHTTP(rr, req)
 
@@ -56,14 +62,14 @@ func Test_handlePostDeckDraw(t *testing.T) {
 
 	// Test it fails to draw more cards than the ones available.
 	cardsToDraw = 52
-	req, err = http.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/draw/%d", deckID, cardsToDraw), nil)
+	req, err = http.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/cards/%d", deckID, cardsToDraw), nil)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 
 	rr = httptest.NewRecorder()
 	handler = http.NewServeMux()
-	handler.Handle("POST /v1/decks/{deck_id}/draw/{number}", handlePostDeckDraw(da))
+	handler.Handle("POST /v1/decks/{deck_id}/cards/{count}", handlePostDeckDraw(da))
 
 	handler.ServeHTTP(rr, req)
 
@@ -72,7 +78,10 @@ func Test_handlePostDeckDraw(t *testing.T) {
 	}
 
 	// Test it correctly handles concurrent draw requests.
-	e := da.New(false, nil)
+	e, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
 	deckID = e.DeckID.String()
 
 	cardsToDraw = 52
@@ -85,8 +94,8 @@ func Test_handlePostDeckDraw(t *testing.T) {
 	wg.Add(numReqs)
 	for _, rr := range recs {
 		handler = http.NewServeMux()
-		handler.Handle("POST /v1/decks/{deck_id}/draw/{number}", handlePostDeckDraw(da))
-		req, err = http.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/draw/%d", deckID, cardsToDraw), nil)
+		handler.Handle("POST /v1/decks/{deck_id}/cards/{count}", handlePostDeckDraw(da))
+		req, err = http.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/cards/%d", deckID, cardsToDraw), nil)
 		if err != nil {
 			t.Fatalf(err.Error())
 		}
@@ -136,7 +145,8 @@ func Test_handlePostDeck(t *testing.T) {
 		"true",
 		"false",
 	}
-	da := deck.NewAPI(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
 	for _, shuffledParam := range shuffledParamValues {
 		req, err := http.NewRequest("POST", fmt.Sprintf("/v1/decks?shuffled=%s", shuffledParam), nil)
 		if err != nil {
@@ -193,7 +203,7 @@ func Test_handlePostDeck(t *testing.T) {
 		t.Errorf("Expected 400 Bad Request, got %v", rr.Code)
 	}
 
-	expectedResponse := `{"code":400,"message":"Invalid shuffled parameter"}`
+	expectedResponse := `{"type":"/problems/invalid_parameter","title":"Invalid parameter","status":400,"detail":"Invalid shuffled parameter","instance":"/v1/decks","code":"invalid_parameter","errors":[{"pointer":"/query/shuffled","detail":"Invalid shuffled parameter"}]}`
 	if expectedResponse != strings.TrimRight(rr.Body.String(), "\n") {
 		t.Errorf("Expected to get %v, got %v", expectedResponse, rr.Body.String())
 	}
@@ -202,8 +212,12 @@ func Test_handlePostDeck(t *testing.T) {
 func Test_handleGetDeck(t *testing.T) {
 	// Test it returns an existing deck.
 	var deckID string
-	da := deck.NewAPI(slog.New(slog.NewTextHandler(io.Discard, nil)))
-	d := da.New(true, nil)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	d, err := da.New(true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
 	deckID = d.DeckID.String()
 
 	req, err := http.NewRequest("GET", fmt.Sprintf("/v1/decks/%s", deckID), nil)
@@ -251,8 +265,8 @@ func Test_handleGetDeck(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expected to get a error response, got %v", er)
 	}
-	if er.Code != rr.Code {
-		t.Errorf("Expected %v, got %v", rr.Code, er.Code)
+	if er.Status != rr.Code || er.Code != "deck_not_found" {
+		t.Errorf("Expected %v and deck_not_found, got %v and %v", rr.Code, er.Status, er.Code)
 	}
 	if strings.Contains(rr.Body.String(), "deck_id") {
 		t.Errorf("Error response should not have a deck, got %v", rr.Body.String())
@@ -267,8 +281,8 @@ func decodeDeck(b io.Reader) (deck.Deck, error) {
 	return d, nil
 }
 
-func decodeErrorResponse(b io.Reader) (errorResponse, error) {
-	var d errorResponse
+func decodeErrorResponse(b io.Reader) (problem, error) {
+	var d problem
 	if err := json.NewDecoder(b).Decode(&d); err != nil {
 		return d, fmt.Errorf("decode json: %w", err)
 	}
@@ -286,3 +300,238 @@ func decodeCards(b io.Reader) (cardsResponse, error) {
 type cardsResponse struct {
 	Cards []deck.Card `json:"cards"`
 }
+
+func Test_handleExportImport(t *testing.T) {
+	// Test every deck is exported, one per line.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	d, err := da.New(true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = da.Draw(d.DeckID, 5)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+	_, err = da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", "/v1/admin/export", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(handleGetExport(da))
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %v", rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON content type, got %v", rr.Header().Get("Content-Type"))
+	}
+	export := rr.Body.String()
+	lines := strings.Split(strings.TrimRight(export, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 exported decks, got %d", len(lines))
+	}
+
+	// Test the export is loaded into another store, keeping the deck order.
+	other := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	for range 2 {
+		req, err = http.NewRequest("POST", "/v1/admin/import", strings.NewReader(export))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		rr = httptest.NewRecorder()
+		handler = http.Handler(handlePostImport(other))
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected 200 OK, got %v", rr.Code)
+		}
+	}
+
+	expected, err := da.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Deck missing from store: %v", err)
+	}
+	imported, err := other.Get(d.DeckID)
+	if err != nil {
+		t.Fatalf("Deck missing from store after import: %v", err)
+	}
+	if !slices.Equal(imported.Cards, expected.Cards) || imported.Remaining != 47 {
+		t.Errorf("Expected imported deck %v, got %v", expected, imported)
+	}
+	if u := other.Usage(); u.Decks != 2 {
+		t.Errorf("Expected importing twice to keep 2 decks, got %d", u.Decks)
+	}
+
+	// Test an invalid deck makes the whole import fail.
+	invalid := `{"deck_id":"14ca6cac-e933-4484-8e3f-e5acd505d11d","remaining":1,"cards":[{"code":"2C"}]}
+{"deck_id":"24ca6cac-e933-4484-8e3f-e5acd505d11d","remaining":1,"cards":[{"code":"ZZ"}]}
+`
+	req, err = http.NewRequest("POST", "/v1/admin/import", strings.NewReader(invalid))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr = httptest.NewRecorder()
+	handler = http.Handler(handlePostImport(other))
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %v", rr.Code)
+	}
+	if u := other.Usage(); u.Decks != 2 {
+		t.Errorf("Expected a failed import to leave 2 decks, got %d", u.Decks)
+	}
+}
+
+func Test_handleGetDeckAt(t *testing.T) {
+	// Test it returns the deck as it was before a draw.
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}), deck.WithEventLog(deck.NewMemoryEventLog()))
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	_, err = da.Draw(d.DeckID, 10)
+	if err != nil {
+		t.Fatalf("Failed to draw cards: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/v1/decks/%s?at=1", d.DeckID), nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.NewServeMux()
+	handler.Handle("GET /v1/decks/{deck_id}", handleGetDeck(da))
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %v", rr.Code)
+	}
+	dr, err := decodeDeck(rr.Body)
+	if err != nil {
+		t.Errorf("Expected to get a Deck, got %v", dr)
+	}
+	if dr.Remaining != 52 {
+		t.Errorf("Expected 52 cards remaining at the first event, got %d", dr.Remaining)
+	}
+
+	// Test it rejects an invalid sequence number.
+	req, err = http.NewRequest("GET", fmt.Sprintf("/v1/decks/%s?at=first", d.DeckID), nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %v", rr.Code)
+	}
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	var buf strings.Builder
+	log := slog.New(logging.NewHandler(slog.NewTextHandler(&buf, nil)))
+	da := deck.NewAPI(log, deck.NewStore(log, deck.Limits{}))
+	d, err := da.New(false, nil)
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	mux := NewMux(log, da)
+
+	// Test the status and size of the response are logged, along with the
+	// deck, under the request ID the client sent.
+	buf.Reset()
+	req := httptest.NewRequest("POST", fmt.Sprintf("/v1/decks/%s/cards/2", d.DeckID), nil)
+	req.Header.Set("X-Request-ID", "draw-1")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %v", rr.Code)
+	}
+	if id := rr.Header().Get("X-Request-ID"); id != "draw-1" {
+		t.Errorf("Expected the request ID draw-1 in the response, got %q", id)
+	}
+	logs := buf.String()
+	for _, s := range []string{
+		fmt.Sprintf("msg=api request=/v1/decks/%s/cards/2 method=POST deckID=%s status=200 size=%d", d.DeckID, d.DeckID, rr.Body.Len()),
+		fmt.Sprintf("msg=store update=finished deckID=%s request_id=draw-1", d.DeckID),
+	} {
+		if !strings.Contains(logs, s) {
+			t.Errorf("Expected %q in the logs, got\n%s", s, logs)
+		}
+	}
+	if n := strings.Count(logs, "request_id=draw-1"); n != strings.Count(logs, "\n") {
+		t.Errorf("Expected every line to have the request ID, got\n%s", logs)
+	}
+
+	// Test a request ID is made up for requests without a usable one.
+	buf.Reset()
+	req = httptest.NewRequest("GET", "/v1/decks/14ca6cac-e933-4484-8e3f-e5acd505d11d", nil)
+	req.Header.Set("X-Request-ID", "two words")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	id := rr.Header().Get("X-Request-ID")
+	if id == "" || id == "two words" {
+		t.Errorf("Expected a new request ID, got %q", id)
+	}
+	if !strings.Contains(buf.String(), "status=404") || !strings.Contains(buf.String(), "request_id="+id) {
+		t.Errorf("Expected a 404 logged with the request ID %s, got\n%s", id, buf.String())
+	}
+
+	// Test panics are logged and get a 500 problem.
+	buf.Reset()
+	handler := newLoggerMiddleware(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("lost the deck")
+	}))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/decks", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 Internal Server Error, got %v", rr.Code)
+	}
+	var p problem
+	err = json.NewDecoder(rr.Body).Decode(&p)
+	if err != nil || p.Code != "internal_error" || strings.Contains(p.Detail, "lost the deck") {
+		t.Errorf("Expected an internal_error problem without the panic, got %+v", p)
+	}
+	if !strings.Contains(buf.String(), `panic="lost the deck"`) || !strings.Contains(buf.String(), "status=500") {
+		t.Errorf("Expected the panic and a 500 in the logs, got\n%s", buf.String())
+	}
+}
+
+func Test_handleGetProblem(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mux := NewMux(log, deck.NewAPI(log, deck.NewStore(log, deck.Limits{})))
+
+	// Test the type URI of every problem describes it.
+	types := []problemType{problemInternal}
+	for _, pt := range problemTypes {
+		types = append(types, pt.typ)
+	}
+	for _, pt := range types {
+		req := httptest.NewRequest("GET", problemTypeBase+pt.code, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), template.HTMLEscapeString(pt.title)) {
+			t.Errorf("%s: expected a page about %q, got %d", pt.code, pt.title, rr.Code)
+		}
+	}
+}